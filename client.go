@@ -652,7 +652,7 @@ type GraphEdge struct {
 
 // AddNode adds a node to the graph overlay.
 func (c *IPCClient) AddNode(namespace, nodeID, nodeType string, properties map[string]string) error {
-	key := fmt.Sprintf("_graph/%s/nodes/%s", namespace, nodeID)
+	key := graphSpace.Pack(namespace, "nodes", nodeID)
 
 	if properties == nil {
 		properties = make(map[string]string)
@@ -669,12 +669,12 @@ func (c *IPCClient) AddNode(namespace, nodeID, nodeType string, properties map[s
 		return err
 	}
 
-	return c.Put([]byte(key), value)
+	return c.Put(key, value)
 }
 
 // AddEdge adds an edge between nodes in the graph overlay.
 func (c *IPCClient) AddEdge(namespace, fromID, edgeType, toID string, properties map[string]string) error {
-	key := fmt.Sprintf("_graph/%s/edges/%s/%s/%s", namespace, fromID, edgeType, toID)
+	key := graphSpace.Pack(namespace, "edges", fromID, edgeType, toID)
 
 	if properties == nil {
 		properties = make(map[string]string)
@@ -692,7 +692,7 @@ func (c *IPCClient) AddEdge(namespace, fromID, edgeType, toID string, properties
 		return err
 	}
 
-	return c.Put([]byte(key), value)
+	return c.Put(key, value)
 }
 
 // TraverseResult contains the result of a graph traversal.
@@ -731,8 +731,8 @@ func (c *IPCClient) Traverse(namespace, startNode string, maxDepth int, order st
 		visited[current.nodeID] = true
 
 		// Get node data
-		nodeKey := fmt.Sprintf("_graph/%s/nodes/%s", namespace, current.nodeID)
-		nodeData, err := c.Get([]byte(nodeKey))
+		nodeKey := graphSpace.Pack(namespace, "nodes", current.nodeID)
+		nodeData, err := c.Get(nodeKey)
 		if err == nil && nodeData != nil {
 			var node GraphNode
 			if json.Unmarshal(nodeData, &node) == nil {
@@ -741,8 +741,8 @@ func (c *IPCClient) Traverse(namespace, startNode string, maxDepth int, order st
 		}
 
 		// Get outgoing edges
-		edgePrefix := fmt.Sprintf("_graph/%s/edges/%s/", namespace, current.nodeID)
-		edgeResults, err := c.Scan(edgePrefix)
+		edgePrefix := graphSpace.Sub(namespace, "edges", current.nodeID)
+		edgeResults, err := c.Scan(string(edgePrefix))
 		if err == nil {
 			for _, kv := range edgeResults {
 				var edge GraphEdge
@@ -775,7 +775,7 @@ type CacheEntry struct {
 func (c *IPCClient) CachePut(cacheName, key, value string, embedding []float32, ttlSeconds int64) error {
 	// Hash the key for storage
 	keyHash := fmt.Sprintf("%x", key)[:16]
-	cacheKey := fmt.Sprintf("_cache/%s/%s", cacheName, keyHash)
+	cacheKey := cacheSpace.Pack(cacheName, keyHash)
 
 	var expiresAt int64 = 0
 	if ttlSeconds > 0 {
@@ -794,13 +794,13 @@ func (c *IPCClient) CachePut(cacheName, key, value string, embedding []float32,
 		return err
 	}
 
-	return c.Put([]byte(cacheKey), cacheValue)
+	return c.Put(cacheKey, cacheValue)
 }
 
 // CacheGet looks up a value in the semantic cache by embedding similarity.
 func (c *IPCClient) CacheGet(cacheName string, queryEmbedding []float32, threshold float32) (string, bool, error) {
-	prefix := fmt.Sprintf("_cache/%s/", cacheName)
-	entries, err := c.Scan(prefix)
+	prefix := cacheSpace.Sub(cacheName)
+	entries, err := c.Scan(string(prefix))
 	if err != nil {
 		return "", false, err
 	}
@@ -890,19 +890,19 @@ func (c *IPCClient) StartTrace(name string) (*TraceInfo, error) {
 	nowUs := nowMicros()
 
 	// Store trace
-	traceKey := fmt.Sprintf("_traces/%s", traceID)
+	traceKey := traceSpace.Pack(traceID)
 	traceValue, _ := json.Marshal(map[string]interface{}{
 		"trace_id":     traceID,
 		"name":         name,
 		"start_us":     nowUs,
 		"root_span_id": spanID,
 	})
-	if err := c.Put([]byte(traceKey), traceValue); err != nil {
+	if err := c.Put(traceKey, traceValue); err != nil {
 		return nil, err
 	}
 
 	// Store root span
-	spanKey := fmt.Sprintf("_traces/%s/spans/%s", traceID, spanID)
+	spanKey := traceSpace.Pack(traceID, "spans", spanID)
 	spanValue, _ := json.Marshal(SpanData{
 		SpanID:       spanID,
 		Name:         name,
@@ -910,7 +910,7 @@ func (c *IPCClient) StartTrace(name string) (*TraceInfo, error) {
 		ParentSpanID: "",
 		Status:       "active",
 	})
-	if err := c.Put([]byte(spanKey), spanValue); err != nil {
+	if err := c.Put(spanKey, spanValue); err != nil {
 		return nil, err
 	}
 
@@ -922,7 +922,7 @@ func (c *IPCClient) StartSpan(traceID, parentSpanID, name string) (string, error
 	spanID := fmt.Sprintf("span_%x%x", now(), randUint32())
 	nowUs := nowMicros()
 
-	spanKey := fmt.Sprintf("_traces/%s/spans/%s", traceID, spanID)
+	spanKey := traceSpace.Pack(traceID, "spans", spanID)
 	spanValue, _ := json.Marshal(SpanData{
 		SpanID:       spanID,
 		Name:         name,
@@ -931,7 +931,7 @@ func (c *IPCClient) StartSpan(traceID, parentSpanID, name string) (string, error
 		Status:       "active",
 	})
 
-	if err := c.Put([]byte(spanKey), spanValue); err != nil {
+	if err := c.Put(spanKey, spanValue); err != nil {
 		return "", err
 	}
 
@@ -940,9 +940,9 @@ func (c *IPCClient) StartSpan(traceID, parentSpanID, name string) (string, error
 
 // EndSpan ends a span and returns its duration in microseconds.
 func (c *IPCClient) EndSpan(traceID, spanID, status string) (int64, error) {
-	spanKey := fmt.Sprintf("_traces/%s/spans/%s", traceID, spanID)
+	spanKey := traceSpace.Pack(traceID, "spans", spanID)
 
-	spanData, err := c.Get([]byte(spanKey))
+	spanData, err := c.Get(spanKey)
 	if err != nil || spanData == nil {
 		return 0, fmt.Errorf("span not found: %s", spanID)
 	}
@@ -960,7 +960,7 @@ func (c *IPCClient) EndSpan(traceID, spanID, status string) (int64, error) {
 	span.DurationUs = duration
 
 	updatedValue, _ := json.Marshal(span)
-	if err := c.Put([]byte(spanKey), updatedValue); err != nil {
+	if err := c.Put(spanKey, updatedValue); err != nil {
 		return 0, err
 	}
 
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	sochdb "github.com/sochdb/sochdb-go"
+	"github.com/sochdb/sochdb-go/embedded"
+)
+
+const usage = `Usage: sochdb-collection <export|import> [flags]
+
+Export a collection to, or import it from, JSONL or NumPy files.
+
+Examples:
+  sochdb-collection export -db ./data -namespace acme -collection docs -out docs.jsonl
+  sochdb-collection export -db ./data -namespace acme -collection docs -format npy -out docs.npy -sidecar docs.meta.jsonl
+  sochdb-collection import -db ./data -namespace acme -collection docs -dimension 384 -in pinecone.jsonl -id-prefix pc-
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = runExport(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// commonFlags are shared by export and import
+type commonFlags struct {
+	db         string
+	namespace  string
+	collection string
+	format     string
+	sidecar    string
+}
+
+func (f *commonFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.db, "db", "", "database path (required)")
+	fs.StringVar(&f.namespace, "namespace", "", "namespace name (required)")
+	fs.StringVar(&f.collection, "collection", "", "collection name (required)")
+	fs.StringVar(&f.format, "format", "jsonl", "file format: jsonl or npy")
+	fs.StringVar(&f.sidecar, "sidecar", "", "npy: JSONL file of IDs and metadata")
+}
+
+func (f *commonFlags) validate() error {
+	if f.db == "" || f.namespace == "" || f.collection == "" {
+		return fmt.Errorf("-db, -namespace and -collection are required")
+	}
+	if f.format != string(sochdb.TransferJSONL) && f.format != string(sochdb.TransferNPY) {
+		return fmt.Errorf("unknown format %q", f.format)
+	}
+	return nil
+}
+
+func runExport(args []string) error {
+	var common commonFlags
+	var out string
+
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	common.register(fs)
+	fs.StringVar(&out, "out", "-", "output file, - for stdout")
+	fs.Parse(args)
+	if err := common.validate(); err != nil {
+		return err
+	}
+
+	db, err := embedded.Open(common.db)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ns, err := sochdb.NewNamespaceManager(db).Namespace(common.namespace)
+	if err != nil {
+		return err
+	}
+	collection, err := ns.Collection(common.collection)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if out != "-" {
+		file, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	var opts sochdb.ExportOptions
+	if common.sidecar != "" {
+		file, err := os.Create(common.sidecar)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		opts.Sidecar = file
+	}
+
+	n, err := collection.Export(w, sochdb.TransferFormat(common.format), opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d vectors\n", n)
+	return nil
+}
+
+func runImport(args []string) error {
+	var common commonFlags
+	var in, idPrefix, metric string
+	var dimension, resume, batchSize int
+	var upsert bool
+
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	common.register(fs)
+	fs.StringVar(&in, "in", "-", "input file, - for stdin")
+	fs.StringVar(&idPrefix, "id-prefix", "", "prefix added to every imported ID")
+	fs.IntVar(&dimension, "dimension", 0, "dimension of the collection if it is created")
+	fs.StringVar(&metric, "metric", string(sochdb.DistanceMetricCosine), "metric of the collection if it is created")
+	fs.IntVar(&resume, "resume", 0, "records to skip, as reported by a failed import")
+	fs.IntVar(&batchSize, "batch-size", 0, "records per transaction (default 1000)")
+	fs.BoolVar(&upsert, "upsert", false, "replace existing vectors")
+	fs.Parse(args)
+	if err := common.validate(); err != nil {
+		return err
+	}
+
+	db, err := embedded.Open(common.db)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ns, err := sochdb.NewNamespaceManager(db).GetOrCreateNamespace(sochdb.NamespaceConfig{Name: common.namespace})
+	if err != nil {
+		return err
+	}
+	collection, err := ns.GetOrCreateCollection(sochdb.CollectionConfig{
+		Name:      common.collection,
+		Dimension: dimension,
+		Metric:    sochdb.DistanceMetric(metric),
+	})
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if in != "-" {
+		file, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	opts := sochdb.ImportOptions{Upsert: upsert, Resume: resume, BatchSize: batchSize}
+	if idPrefix != "" {
+		opts.MapID = func(id string) string {
+			if id == "" {
+				return ""
+			}
+			return idPrefix + id
+		}
+	}
+	if common.sidecar != "" {
+		file, err := os.Open(common.sidecar)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		opts.Sidecar = file
+	}
+
+	n, err := collection.Import(r, sochdb.TransferFormat(common.format), opts)
+	if err != nil {
+		return fmt.Errorf("%w (resume with -resume %d)", err, n)
+	}
+	fmt.Fprintf(os.Stderr, "Imported %d records\n", n)
+	return nil
+}
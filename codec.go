@@ -0,0 +1,202 @@
+// Value codecs for typed tables
+//
+// A Codec turns Go values into stored bytes and back. Typed tables prefix
+// every stored value with a small header recording the codec and schema
+// version, so a table can switch codecs or evolve its schema while old rows
+// stay readable.
+
+package sochdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec marshals values for storage
+type Codec interface {
+	// ID identifies the codec in stored value headers. IDs below 128 are
+	// reserved for built-in codecs.
+	ID() uint8
+	// Name returns a human-readable codec name
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Built-in codecs
+var (
+	JSONCodec     Codec = jsonCodec{}
+	MsgPackCodec  Codec = msgpackCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+	GobCodec      Codec = gobCodec{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[uint8]Codec{
+		JSONCodec.ID():     JSONCodec,
+		MsgPackCodec.ID():  MsgPackCodec,
+		ProtobufCodec.ID(): ProtobufCodec,
+		GobCodec.ID():      GobCodec,
+	}
+)
+
+// RegisterCodec makes a custom codec available for decoding stored values.
+// Custom codecs must use an ID of 128 or above.
+func RegisterCodec(codec Codec) error {
+	if codec.ID() < 128 {
+		return fmt.Errorf("codec ID %d is reserved for built-in codecs", codec.ID())
+	}
+
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	if existing, ok := codecs[codec.ID()]; ok && existing.Name() != codec.Name() {
+		return fmt.Errorf("codec ID %d already registered by %s", codec.ID(), existing.Name())
+	}
+	codecs[codec.ID()] = codec
+	return nil
+}
+
+func codecByID(id uint8) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	codec, ok := codecs[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec ID %d", id)
+	}
+	return codec, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() uint8                                  { return 1 }
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ID() uint8                                  { return 2 }
+func (msgpackCodec) Name() string                               { return "msgpack" }
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+// protobufCodec accepts either a proto.Message or a pointer to one, so tables
+// can be declared as Table[*pb.User].
+type protobufCodec struct{}
+
+func (protobufCodec) ID() uint8    { return 3 }
+func (protobufCodec) Name() string { return "protobuf" }
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, err := protoMessage(v, false)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, err := protoMessage(v, true)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+func protoMessage(v interface{}, allocate bool) (proto.Message, error) {
+	if msg, ok := v.(proto.Message); ok {
+		return msg, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Kind() == reflect.Ptr {
+		if rv.Elem().IsNil() {
+			if !allocate {
+				return nil, fmt.Errorf("protobuf codec: nil message")
+			}
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+		if msg, ok := rv.Elem().Interface().(proto.Message); ok {
+			return msg, nil
+		}
+	}
+
+	return nil, fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ID() uint8    { return 4 }
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ============================================================================
+// Value Header
+// ============================================================================
+
+// Stored values are laid out as:
+//
+//	magic (1) | header version (1) | codec ID (1) | schema version (uvarint) | payload
+const (
+	valueMagic         byte = 0xB7
+	valueHeaderVersion byte = 1
+)
+
+// encodeValue marshals v with the codec and prefixes the value header
+func encodeValue(codec Codec, schemaVersion uint32, v interface{}) ([]byte, error) {
+	payload, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, len(payload)+8)
+	buf = append(buf, valueMagic, valueHeaderVersion, codec.ID())
+	buf = binary.AppendUvarint(buf, uint64(schemaVersion))
+	return append(buf, payload...), nil
+}
+
+// decodeValue unmarshals a stored value into v and returns its schema
+// version. Values without a header are decoded with the fallback codec and
+// reported as schema version 0.
+func decodeValue(fallback Codec, data []byte, v interface{}) (uint32, error) {
+	if len(data) < 4 || data[0] != valueMagic || data[1] != valueHeaderVersion {
+		return 0, fallback.Unmarshal(data, v)
+	}
+
+	codec, err := codecByID(data[2])
+	if err != nil {
+		return 0, err
+	}
+
+	schemaVersion, n := binary.Uvarint(data[3:])
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid value header")
+	}
+
+	if err := codec.Unmarshal(data[3+n:], v); err != nil {
+		return 0, err
+	}
+	return uint32(schemaVersion), nil
+}
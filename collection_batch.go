@@ -0,0 +1,279 @@
+// Batch inserts
+//
+// InsertBatch validates dimensions and schemas before writing anything and
+// encodes the vectors (including quantized codes) on all CPUs. Each chunk is
+// then stored in one transaction: the items and their text and field index
+// entries are written first, and each vector index graph is then updated
+// with all of the chunk's vectors and flushed once. In atomic mode the whole
+// batch is one chunk; in best-effort mode each failed item is reported and
+// the rest are kept. An item that fails while being stored takes its chunk's
+// transaction down with it, so none of its writes survive, and the chunk is
+// stored again without it.
+//
+// Example:
+//
+//	results, err := docs.InsertBatch(items, sochdb.BatchOptions{Mode: sochdb.BatchBestEffort})
+//	for _, r := range results {
+//	    if r.Err != nil {
+//	        log.Printf("skipped %s: %v", r.ID, r.Err)
+//	    }
+//	}
+
+package sochdb
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/sochdb/sochdb-go/embedded"
+)
+
+// BatchMode selects how a batch insert handles failures
+type BatchMode int
+
+const (
+	// BatchAtomic inserts every item or none
+	BatchAtomic BatchMode = iota
+	// BatchBestEffort inserts the items it can and reports the rest
+	BatchBestEffort
+)
+
+const defaultBatchChunkSize = 1000
+
+// InsertItem is one vector of a batch insert. An empty ID is generated.
+type InsertItem struct {
+	ID       string
+	Vector   []float32
+	Metadata map[string]interface{}
+}
+
+// InsertResult is the outcome of one batch item
+type InsertResult struct {
+	ID  string
+	Err error
+}
+
+// BatchOptions configures InsertBatch
+type BatchOptions struct {
+	Mode BatchMode
+	// Upsert replaces existing vectors instead of failing with
+	// VectorExistsError
+	Upsert bool
+	// ChunkSize is the number of items per transaction in best-effort
+	// mode; default 1000. Atomic batches always use one transaction.
+	ChunkSize int
+	// IdempotencyKey stores an atomic batch once; repeating it within the
+	// collection's IdempotencyWindow returns the first batch's results
+	IdempotencyKey string
+}
+
+// BatchItemError is returned by an atomic batch insert when an item fails
+type BatchItemError struct {
+	Index int
+	ID    string
+	Err   error
+}
+
+func (e *BatchItemError) Error() string {
+	return fmt.Sprintf("batch item %d (%s): %v", e.Index, e.ID, e.Err)
+}
+
+func (e *BatchItemError) Unwrap() error {
+	return e.Err
+}
+
+// InsertBatch inserts many vectors
+//
+// Atomic batches return a BatchItemError naming the first failing item and
+// store nothing. Best-effort batches return one result per item; the error
+// return is only set for failures of the batch as a whole.
+func (c *Collection) InsertBatch(items []InsertItem, opts BatchOptions) (_ []InsertResult, err error) {
+	access, err := c.authorize(GrantOperationWrite)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { access.done(err) }()
+	if opts.IdempotencyKey != "" && opts.Mode != BatchAtomic {
+		return nil, errors.New("idempotency keys require an atomic batch")
+	}
+
+	// Schema defaults are filled in on a copy
+	if c.config.Schema != nil {
+		items = append([]InsertItem(nil), items...)
+	}
+
+	results := make([]InsertResult, len(items))
+	for i, item := range items {
+		results[i].ID = item.ID
+		if results[i].ID == "" {
+			results[i].ID = c.generateID()
+		}
+
+		if len(item.Vector) == 0 {
+			results[i].Err = fmt.Errorf("vector is empty")
+		} else if c.config.Dimension > 0 && len(item.Vector) != c.config.Dimension {
+			results[i].Err = fmt.Errorf("vector dimension mismatch: expected %d, got %d", c.config.Dimension, len(item.Vector))
+		} else {
+			items[i].Metadata, results[i].Err = c.conform(results[i].ID, item.Metadata)
+		}
+		if results[i].Err != nil && opts.Mode == BatchAtomic {
+			return nil, &BatchItemError{Index: i, ID: results[i].ID, Err: results[i].Err}
+		}
+	}
+
+	prepared := c.prepareBatch(items, results)
+	if opts.Mode == BatchAtomic {
+		for i := range results {
+			if results[i].Err != nil {
+				return nil, &BatchItemError{Index: i, ID: results[i].ID, Err: results[i].Err}
+			}
+		}
+	}
+
+	chunk := len(items)
+	if opts.Mode == BatchBestEffort {
+		chunk = opts.ChunkSize
+		if chunk <= 0 {
+			chunk = defaultBatchChunkSize
+		}
+	}
+
+	for start := 0; start < len(items); start += chunk {
+		end := start + chunk
+		if end > len(items) {
+			end = len(items)
+		}
+
+		var replayed []string
+		var err error
+		for {
+			err = retryConflicts(c.db, func(txn *embedded.Transaction) error {
+				if opts.IdempotencyKey == "" {
+					return c.storeBatch(txn, prepared[start:end], results[start:end], opts.Upsert)
+				}
+
+				now := time.Now().UnixMilli()
+				found, err := c.idempotency().lookup(txn, opts.IdempotencyKey, now, &replayed)
+				if err != nil || found {
+					return err
+				}
+				if err := c.storeBatch(txn, prepared[start:end], results[start:end], opts.Upsert); err != nil {
+					return err
+				}
+				ids := make([]string, len(results))
+				for i := range results {
+					ids[i] = results[i].ID
+				}
+				return c.idempotency().remember(txn, opts.IdempotencyKey, now, ids)
+			})
+
+			// Retry the chunk without the failed item
+			var itemErr *BatchItemError
+			if opts.Mode != BatchBestEffort || !errors.As(err, &itemErr) {
+				break
+			}
+			results[start+itemErr.Index].Err = itemErr.Err
+		}
+		if err == nil && replayed != nil {
+			results = make([]InsertResult, len(replayed))
+			for i, id := range replayed {
+				results[i].ID = id
+			}
+			return results, nil
+		}
+		if err == nil {
+			continue
+		}
+		if opts.Mode == BatchAtomic {
+			return nil, err
+		}
+
+		// The chunk's transaction failed as a whole
+		for i := start; i < end; i++ {
+			if results[i].Err == nil {
+				results[i].Err = err
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// prepareBatch encodes items on all CPUs, recording encoding failures in
+// results. Items that already failed are skipped.
+func (c *Collection) prepareBatch(items []InsertItem, results []InsertResult) []*preparedVector {
+	prepared := make([]*preparedVector, len(items))
+	now := time.Now().UnixMilli()
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(items) {
+		workers = len(items)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(items); i += workers {
+				if results[i].Err != nil {
+					continue
+				}
+				data := vectorData{Vector: items[i].Vector, Metadata: items[i].Metadata, Timestamp: now}
+				prepared[i], results[i].Err = c.prepareVector(results[i].ID, data)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	return prepared
+}
+
+// storeBatch writes prepared items within txn, skipping items that already
+// failed. The first failure is returned as a BatchItemError indexed within
+// prepared, and the transaction must be aborted.
+func (c *Collection) storeBatch(txn *embedded.Transaction, prepared []*preparedVector, results []InsertResult, upsert bool) error {
+	created := int64(0)
+	batch := make(indexBatch)
+
+	for i, p := range prepared {
+		if results[i].Err != nil {
+			continue
+		}
+		if err := c.storeBatchItem(txn, p, upsert, batch, &created); err != nil {
+			return &BatchItemError{Index: i, ID: p.id, Err: err}
+		}
+	}
+	if err := c.applyIndexBatch(txn, batch); err != nil {
+		return err
+	}
+
+	if created == 0 {
+		return nil
+	}
+	return c.addCount(txn, created)
+}
+
+func (c *Collection) storeBatchItem(txn *embedded.Transaction, p *preparedVector, upsert bool, batch indexBatch, created *int64) error {
+	if !upsert {
+		existing, err := txn.Get(c.vectorKey(p.id))
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return &VectorExistsError{Collection: c.name, ID: p.id}
+		}
+	}
+
+	isNew, err := c.storeVector(txn, p, batch)
+	if err != nil {
+		return err
+	}
+	if isNew {
+		*created++
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package sochdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCollectionInsertBatch tests atomic and best-effort batch inserts
+func TestCollectionInsertBatch(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 2})
+	require.NoError(t, err)
+
+	_, err = docs.Insert([]float32{1, 1}, nil, "taken")
+	require.NoError(t, err)
+
+	// A bad dimension fails the batch before anything is written
+	var itemErr *BatchItemError
+	_, err = docs.InsertMany([][]float32{{1, 0}, {1, 0, 0}}, nil, []string{"a", "b"})
+	require.ErrorAs(t, err, &itemErr)
+	assert.Equal(t, 1, itemErr.Index)
+
+	// A duplicate rolls back the whole batch
+	_, err = docs.InsertMany([][]float32{{1, 0}, {0, 1}}, nil, []string{"a", "taken"})
+	require.ErrorAs(t, err, &itemErr)
+	var exists *VectorExistsError
+	assert.ErrorAs(t, err, &exists)
+	got, err := docs.Get("a")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	items := make([]InsertItem, 0, 50)
+	for i := 0; i < 48; i++ {
+		items = append(items, InsertItem{Vector: []float32{float32(i), 1}, Metadata: map[string]interface{}{"i": i}})
+	}
+	items = append(items, InsertItem{ID: "taken", Vector: []float32{0, 0}}, InsertItem{ID: "short", Vector: []float32{0}})
+
+	results, err := docs.InsertBatch(items, BatchOptions{Mode: BatchBestEffort, ChunkSize: 16})
+	require.NoError(t, err)
+	require.Len(t, results, 50)
+	for _, r := range results[:48] {
+		assert.NoError(t, r.Err)
+		assert.NotEmpty(t, r.ID)
+	}
+	assert.ErrorAs(t, results[48].Err, &exists)
+	assert.Error(t, results[49].Err)
+
+	count, err := docs.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 49, count)
+
+	got, err = docs.Get(results[7].ID)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{7, 1}, got.Vector)
+
+	ids, err := docs.InsertMany([][]float32{{0, 2}, {2, 0}}, nil, []string{"x", "y"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x", "y"}, ids)
+
+	results, err = docs.InsertBatch([]InsertItem{{ID: "x", Vector: []float32{5, 5}}}, BatchOptions{Upsert: true})
+	require.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+	count, err = docs.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 51, count)
+}
+
+// TestInsertBatchBestEffortRollback tests that items failing while being
+// stored leave nothing behind
+func TestInsertBatchBestEffortRollback(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{
+		Name:  "tenant",
+		Quota: &NamespaceQuota{MaxVectors: 3},
+	})
+	require.NoError(t, err)
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 2})
+	require.NoError(t, err)
+
+	items := []InsertItem{
+		{ID: "a", Vector: []float32{1, 0}},
+		{ID: "b", Vector: []float32{0, 1}},
+		{ID: "c", Vector: []float32{1, 1}},
+		{ID: "d", Vector: []float32{2, 1}},
+		{ID: "a", Vector: []float32{3, 1}},
+	}
+	results, err := docs.InsertBatch(items, BatchOptions{Mode: BatchBestEffort})
+	require.NoError(t, err)
+
+	var quotaErr *QuotaExceededError
+	var exists *VectorExistsError
+	for _, r := range results[:3] {
+		assert.NoError(t, r.Err)
+	}
+	assert.ErrorAs(t, results[3].Err, &quotaErr)
+	assert.ErrorAs(t, results[4].Err, &exists)
+
+	got, err := docs.Get("d")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+	got, err = docs.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, []float32{1, 0}, got.Vector)
+
+	count, err := docs.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	usage, err := ns.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), usage.Vectors)
+	assert.Equal(t, int64(4), usage.Keys)
+}
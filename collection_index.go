@@ -0,0 +1,1232 @@
+// Vector index (HNSW)
+//
+// An indexed collection keeps a hierarchical navigable small world graph
+// over its default vectors, updated in the same transaction as each write.
+// Searches without a filter walk the graph instead of scoring every vector.
+// Indexed named vectors each keep a graph of their own, handled by the same
+// code through vectorIndex.
+//
+// Indexing existing vectors runs in the background. Searches keep using the
+// previous graph, or an exact scan without one, until the build finishes and
+// swaps the new graph in atomically; writes made during the build are logged
+// and applied before the swap. The previous graph is dropped only after the
+// swap, and a failed or cancelled build leaves it in place.
+// Changing HNSWM or HNSWEfConstruction through UpdateCollection rebuilds the
+// graph the same way.
+//
+// Example:
+//
+//	config.Indexed = true
+//	config.HNSWM = 32
+//	docs, build, err := ns.UpdateCollection(ctx, config)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for p := build.Progress(); !p.Done; p = build.Progress() {
+//	    log.Printf("indexed %d of %d vectors", p.Indexed, p.Total)
+//	    time.Sleep(time.Second)
+//	}
+//	if err := build.Wait(); err != nil {
+//	    log.Fatal(err)
+//	}
+
+package sochdb
+
+import (
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/sochdb/sochdb-go/embedded"
+	"github.com/sochdb/sochdb-go/keys"
+)
+
+const (
+	defaultHNSWM              = 16
+	defaultHNSWEfConstruction = 200
+	defaultHNSWEfSearch       = 64
+	indexBuildBatchSize       = 256
+)
+
+// IndexState is the state of a collection's latest index build
+type IndexState string
+
+const (
+	IndexStateBuilding IndexState = "building"
+	IndexStateReady    IndexState = "ready"
+	IndexStateFailed   IndexState = "failed" // Failed or cancelled; searches use the previous graph, or scan without one
+)
+
+// IndexStatus describes a collection's latest index build
+type IndexStatus struct {
+	State          IndexState `json:"state"`
+	Version        uint32     `json:"version"`
+	M              int        `json:"m"`
+	EfConstruction int        `json:"ef_construction"`
+	Indexed        int64      `json:"indexed"` // Vectors added so far
+	Total          int64      `json:"total"`   // Vectors present when the build started
+	StartedAt      int64      `json:"started_at"`
+	FinishedAt     int64      `json:"finished_at,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// IndexBuildProgress reports the progress of an index build
+type IndexBuildProgress struct {
+	Indexed uint64
+	Total   uint64
+	Done    bool
+}
+
+// IndexBuild builds a collection's vector index in the background
+type IndexBuild struct {
+	indexed atomic.Uint64
+	total   atomic.Uint64
+
+	done   chan struct{}
+	err    error
+	cancel context.CancelFunc
+}
+
+// Progress returns a snapshot of the build's progress
+func (b *IndexBuild) Progress() IndexBuildProgress {
+	progress := IndexBuildProgress{
+		Indexed: b.indexed.Load(),
+		Total:   b.total.Load(),
+	}
+	select {
+	case <-b.done:
+		progress.Done = true
+	default:
+	}
+	return progress
+}
+
+// Wait blocks until the build finishes and returns its error
+func (b *IndexBuild) Wait() error {
+	<-b.done
+	return b.err
+}
+
+// Cancel stops the build; Wait returns context.Canceled
+func (b *IndexBuild) Cancel() {
+	b.cancel()
+}
+
+// errIndexBuildSuperseded stops a build replaced by a newer one
+var errIndexBuildSuperseded = errors.New("index build superseded by a newer build")
+
+// indexVersions routes writes and searches to graph versions. Active serves
+// searches; Building is being built and has writes logged for it in its
+// graph's pending log.
+type indexVersions struct {
+	Active   uint32 `json:"active,omitempty"`
+	Building uint32 `json:"building,omitempty"`
+}
+
+// BuildIndex starts a background (re)build of the collection's vector
+// index with its current HNSW parameters. A build already in progress is
+// superseded.
+func (c *Collection) BuildIndex(ctx context.Context) (_ *IndexBuild, err error) {
+	access, err := c.authorize(GrantOperationWrite)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { access.done(err) }()
+	indexed, dimension, _ := c.indexConfig()
+	if !indexed {
+		return nil, fmt.Errorf("%s is not indexed", c.indexTarget())
+	}
+	if dimension <= 0 {
+		return nil, fmt.Errorf("%s needs a dimension to be indexed", c.indexTarget())
+	}
+
+	m, efConstruction := c.hnswParams()
+	var status IndexStatus
+	err = withTxn(c.db, func(txn *embedded.Transaction) error {
+		previous, err := c.loadIndexStatus(txn)
+		if err != nil {
+			return err
+		}
+		count, _, err := c.loadCount(txn)
+		if err != nil {
+			return err
+		}
+
+		status = IndexStatus{
+			State:          IndexStateBuilding,
+			Version:        1,
+			M:              m,
+			EfConstruction: efConstruction,
+			Total:          count,
+			StartedAt:      time.Now().UnixMilli(),
+		}
+		if previous != nil {
+			status.Version = previous.Version + 1
+		}
+
+		// Searches use the active graph until the new one is swapped in
+		versions, err := c.loadIndexVersions(txn)
+		if err != nil {
+			return err
+		}
+		versions.Building = status.Version
+		if err := c.putIndexVersions(txn, versions); err != nil {
+			return err
+		}
+		if err := c.putIndexStatus(txn, status); err != nil {
+			return err
+		}
+		return c.createGraph(txn, status.Version, m, efConstruction)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	build := &IndexBuild{
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+	build.total.Store(uint64(status.Total))
+
+	go func() {
+		defer close(build.done)
+		defer cancel()
+		build.err = c.buildIndex(ctx, build, status)
+		if build.err != nil {
+			c.failIndexBuild(status, build.err)
+		}
+	}()
+
+	return build, nil
+}
+
+// initIndex starts an empty graph for a new indexed collection within txn
+func (c *Collection) initIndex(txn *embedded.Transaction) error {
+	if indexed, dimension, _ := c.indexConfig(); !indexed || dimension <= 0 {
+		return nil
+	}
+
+	m, efConstruction := c.hnswParams()
+	now := time.Now().UnixMilli()
+	status := IndexStatus{
+		State:          IndexStateReady,
+		Version:        1,
+		M:              m,
+		EfConstruction: efConstruction,
+		StartedAt:      now,
+		FinishedAt:     now,
+	}
+	if err := c.createGraph(txn, status.Version, m, efConstruction); err != nil {
+		return err
+	}
+	if err := c.putIndexStatus(txn, status); err != nil {
+		return err
+	}
+	return c.putIndexVersions(txn, indexVersions{Active: status.Version})
+}
+
+// IndexStatus returns the state of the collection's latest index build, or
+// nil if it was never indexed
+func (c *Collection) IndexStatus() (_ *IndexStatus, err error) {
+	access, err := c.authorize(GrantOperationRead)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { access.done(err) }()
+
+	var status *IndexStatus
+	err = withTxn(c.db, func(txn *embedded.Transaction) error {
+		var err error
+		status, err = c.loadIndexStatus(txn)
+		return err
+	})
+	return status, err
+}
+
+func (c *Collection) buildIndex(ctx context.Context, build *IndexBuild, status IndexStatus) error {
+	var ids []string
+	err := withTxn(c.db, func(txn *embedded.Transaction) error {
+		var err error
+		ids, err = c.indexedIDs(txn)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	build.total.Store(uint64(len(ids)))
+	status.Total = int64(len(ids))
+
+	for len(ids) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n := indexBuildBatchSize
+		if n > len(ids) {
+			n = len(ids)
+		}
+
+		err := withTxn(c.db, func(txn *embedded.Transaction) error {
+			g, err := c.buildingGraph(txn, status.Version)
+			if err != nil {
+				return err
+			}
+			for _, id := range ids[:n] {
+				// Vectors changed since the snapshot are logged for catch-up
+				links, err := g.node(id)
+				if err != nil {
+					return err
+				}
+				if links != nil {
+					continue
+				}
+				vector, err := g.vector(id)
+				if err != nil {
+					return err
+				}
+				if len(vector) == 0 {
+					continue
+				}
+				if err := g.insert(id, vector); err != nil {
+					return err
+				}
+			}
+			if err := g.flush(); err != nil {
+				return err
+			}
+
+			progress := status
+			progress.Indexed = int64(build.indexed.Load()) + int64(n)
+			return c.putIndexStatus(txn, progress)
+		})
+		if err != nil {
+			return err
+		}
+
+		build.indexed.Add(uint64(n))
+		ids = ids[n:]
+	}
+
+	// Catch up on writes made during the build, then swap in the same
+	// transaction as the last of them
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		swapped := false
+		err := withTxn(c.db, func(txn *embedded.Transaction) error {
+			g, err := c.buildingGraph(txn, status.Version)
+			if err != nil {
+				return err
+			}
+
+			pending, err := collectKeys(txn, g.space.Sub("pending").Bytes())
+			if err != nil {
+				return err
+			}
+			last := len(pending) <= indexBuildBatchSize
+			if !last {
+				pending = pending[:indexBuildBatchSize]
+			}
+
+			for _, key := range pending {
+				tuple, err := g.space.Sub("pending").Unpack(key)
+				if err != nil || len(tuple) != 1 {
+					continue
+				}
+				id, _ := tuple[0].(string)
+				if err := g.reindex(id); err != nil {
+					return err
+				}
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+			}
+			if err := g.flush(); err != nil {
+				return err
+			}
+			if !last {
+				return nil
+			}
+
+			status.State = IndexStateReady
+			status.Indexed = status.Total
+			status.FinishedAt = time.Now().UnixMilli()
+			if err := c.putIndexStatus(txn, status); err != nil {
+				return err
+			}
+			swapped = true
+			return c.putIndexVersions(txn, indexVersions{Active: status.Version})
+		})
+		if err != nil {
+			return err
+		}
+		if !swapped {
+			continue
+		}
+
+		// The replaced graph and those of superseded builds are no longer
+		// used. Any left by a failed drop go with the next build's.
+		_ = c.dropGraphs(context.Background(), func(version uint32) bool {
+			return version < status.Version
+		})
+		return nil
+	}
+}
+
+// failIndexBuild records a failed build unless a newer one replaced it
+func (c *Collection) failIndexBuild(status IndexStatus, cause error) {
+	if errors.Is(cause, errIndexBuildSuperseded) {
+		return
+	}
+
+	failed := false
+	var active uint32
+	_ = withTxn(c.db, func(txn *embedded.Transaction) error {
+		failed = false
+		versions, err := c.loadIndexVersions(txn)
+		if err != nil || versions.Building != status.Version {
+			return err
+		}
+		active = versions.Active
+		if err := c.putIndexVersions(txn, indexVersions{Active: active}); err != nil {
+			return err
+		}
+
+		status.State = IndexStateFailed
+		status.FinishedAt = time.Now().UnixMilli()
+		status.Error = cause.Error()
+		failed = true
+		return c.putIndexStatus(txn, status)
+	})
+	// Newer versions may belong to a build started since
+	if failed {
+		_ = c.dropGraphs(context.Background(), func(version uint32) bool {
+			return version <= status.Version && version != active
+		})
+	}
+}
+
+// dropGraphs deletes the graph versions, with their pending logs, for which
+// drop returns true
+func (c *Collection) dropGraphs(ctx context.Context, drop func(version uint32) bool) error {
+	graphs := c.indexSpace().Sub("graphs")
+
+	var stale [][]byte
+	err := withTxn(c.db, func(txn *embedded.Transaction) error {
+		found, err := collectKeys(txn, graphs.Bytes())
+		if err != nil {
+			return err
+		}
+
+		stale = stale[:0]
+		for _, key := range found {
+			tuple, err := graphs.Unpack(key)
+			if err != nil || len(tuple) == 0 {
+				continue
+			}
+			if version, ok := tuple[0].(int64); ok && drop(uint32(version)) {
+				stale = append(stale, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for len(stale) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n := indexBuildBatchSize
+		if n > len(stale) {
+			n = len(stale)
+		}
+		err := withTxn(c.db, func(txn *embedded.Transaction) error {
+			for _, key := range stale[:n] {
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		stale = stale[n:]
+	}
+
+	return nil
+}
+
+// indexBatch collects the IDs whose vectors a batch changed, by vector name,
+// so that each graph is opened, updated and flushed once per transaction
+type indexBatch map[string][]string
+
+// updateIndex applies a write of id to the vector index within txn. A nil
+// record means the vector was deleted. With a batch, the update is deferred
+// until the batch is applied.
+func (c *Collection) updateIndex(txn *embedded.Transaction, id string, oldRecord, record []byte, batch indexBatch) error {
+	if sameVectorRecord(oldRecord, record) {
+		return nil
+	}
+	if batch != nil {
+		batch[c.vectorName] = append(batch[c.vectorName], id)
+		return nil
+	}
+	return c.reindex(txn, []string{id})
+}
+
+// applyIndexBatch applies the deferred updates of batch within txn
+func (c *Collection) applyIndexBatch(txn *embedded.Transaction, batch indexBatch) error {
+	for name, ids := range batch {
+		if err := c.vectorIndex(name).reindex(txn, ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindex applies writes of ids to the vector index within txn, flushing
+// the graph once
+func (c *Collection) reindex(txn *embedded.Transaction, ids []string) error {
+	versions, err := c.loadIndexVersions(txn)
+	if err != nil {
+		return err
+	}
+
+	if versions.Building != 0 {
+		for _, id := range ids {
+			if err := txn.Put(c.graphSpace(versions.Building).Pack("pending", id), []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	if versions.Active == 0 {
+		return nil
+	}
+
+	g, err := c.openGraph(txn, versions.Active)
+	if err != nil || g == nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := g.reindex(id); err != nil {
+			return err
+		}
+	}
+	return g.flush()
+}
+
+// sameVectorRecord reports whether two stored records hold the same vector,
+// ignoring their timestamps
+func sameVectorRecord(a, b []byte) bool {
+	if a == nil || b == nil || isLegacyVector(a) || isLegacyVector(b) {
+		return false
+	}
+	if len(a) < vectorHeaderSize || len(b) < vectorHeaderSize {
+		return false
+	}
+	return string(a[:6]) == string(b[:6]) && string(a[vectorHeaderSize:]) == string(b[vectorHeaderSize:])
+}
+
+// indexSearch walks the active graph. It reports false if the collection
+// has no usable index.
+func (c *Collection) indexSearch(txn *embedded.Transaction, query []float32, k int, floor float32) ([]SearchResult, bool, error) {
+	versions, err := c.loadIndexVersions(txn)
+	if err != nil || versions.Active == 0 {
+		return nil, false, err
+	}
+
+	g, err := c.openGraph(txn, versions.Active)
+	if err != nil || g == nil {
+		return nil, false, err
+	}
+
+	ef := defaultHNSWEfSearch
+	if ef < k {
+		ef = k
+	}
+	found, err := g.search(query, ef)
+	if err != nil {
+		return nil, false, err
+	}
+
+	results := make([]SearchResult, 0, k)
+	for _, r := range found {
+		if len(results) == k || r.Score < floor {
+			break
+		}
+		r.Distance = distanceFromScore(g.metric, r.Score)
+		results = append(results, r)
+	}
+	return results, true, nil
+}
+
+func (c *Collection) hnswParams() (int, int) {
+	m, efConstruction := c.config.HNSWM, c.config.HNSWEfConstruction
+	if c.vectorName != "" {
+		named := c.config.Vectors[c.vectorName]
+		m, efConstruction = named.HNSWM, named.HNSWEfConstruction
+	}
+	if m < 2 {
+		m = defaultHNSWM
+	}
+	if efConstruction <= 0 {
+		efConstruction = defaultHNSWEfConstruction
+	}
+	return m, efConstruction
+}
+
+// vectorIndex returns a handle whose index methods act on the index of the
+// named vector name
+func (c *Collection) vectorIndex(name string) *Collection {
+	view := *c
+	view.vectorName = name
+	return &view
+}
+
+// indexConfig returns whether the handle's vector is indexed, with its
+// dimension and metric
+func (c *Collection) indexConfig() (bool, int, DistanceMetric) {
+	if c.vectorName == "" {
+		return c.config.Indexed, c.config.Dimension, c.config.Metric
+	}
+	named := c.config.Vectors[c.vectorName]
+	return named.Indexed && !named.MultiVector, named.Dimension, named.Metric
+}
+
+// indexTarget names the handle's vector in errors
+func (c *Collection) indexTarget() string {
+	if c.vectorName == "" {
+		return "collection " + c.name
+	}
+	return fmt.Sprintf("named vector %q of collection %s", c.vectorName, c.name)
+}
+
+// indexedKey returns the key of the vector of id that the index covers
+func (c *Collection) indexedKey(id string) []byte {
+	if c.vectorName == "" {
+		return c.vectorKey(id)
+	}
+	return c.namedKey(c.vectorName, id)
+}
+
+// indexedIDs lists the IDs that hold a vector for the index
+func (c *Collection) indexedIDs(txn *embedded.Transaction) ([]string, error) {
+	var ids []string
+	if c.vectorName == "" {
+		err := c.scanVectors(txn, func(id string, vector []float32, _ func() (map[string]interface{}, error)) error {
+			if len(vector) > 0 {
+				ids = append(ids, id)
+			}
+			return nil
+		})
+		return ids, err
+	}
+
+	named := c.space().Sub("named", c.vectorName)
+	found, err := collectKeys(txn, named.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range found {
+		tuple, err := named.Unpack(key)
+		if err != nil || len(tuple) != 1 {
+			continue
+		}
+		if id, ok := tuple[0].(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (c *Collection) indexSpace() keys.Subspace {
+	if c.vectorName != "" {
+		return c.space().Sub("index", "named", c.vectorName)
+	}
+	return c.space().Sub("index")
+}
+
+func (c *Collection) loadIndexVersions(txn *embedded.Transaction) (indexVersions, error) {
+	var versions indexVersions
+	data, err := txn.Get(c.indexSpace().Pack("versions"))
+	if err != nil || data == nil {
+		return versions, err
+	}
+	err = json.Unmarshal(data, &versions)
+	return versions, err
+}
+
+func (c *Collection) putIndexVersions(txn *embedded.Transaction, versions indexVersions) error {
+	data, err := json.Marshal(versions)
+	if err != nil {
+		return err
+	}
+	return txn.Put(c.indexSpace().Pack("versions"), data)
+}
+
+func (c *Collection) loadIndexStatus(txn *embedded.Transaction) (*IndexStatus, error) {
+	data, err := txn.Get(c.indexSpace().Pack("status"))
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var status IndexStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *Collection) putIndexStatus(txn *embedded.Transaction, status IndexStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return txn.Put(c.indexSpace().Pack("status"), data)
+}
+
+// ============================================================================
+// HNSW Graph
+// ============================================================================
+
+// hnswHeader holds a graph's parameters and entry point
+type hnswHeader struct {
+	M              int    `json:"m"`
+	EfConstruction int    `json:"ef_construction"`
+	Entry          string `json:"entry,omitempty"`
+	Level          int    `json:"level"`
+}
+
+// hnswGraph reads and updates one graph version within a transaction,
+// caching nodes and vectors until flush
+type hnswGraph struct {
+	c      *Collection
+	txn    *embedded.Transaction
+	space  keys.Subspace
+	header hnswHeader
+	metric DistanceMetric
+
+	headerChanged bool
+	nodes         map[string][][]string // nil links: absent
+	dirty         map[string]bool
+	vectors       map[string][]float32
+}
+
+// createGraph stores the header of an empty graph
+func (c *Collection) createGraph(txn *embedded.Transaction, version uint32, m, efConstruction int) error {
+	data, err := json.Marshal(hnswHeader{M: m, EfConstruction: efConstruction})
+	if err != nil {
+		return err
+	}
+	return txn.Put(c.graphSpace(version).Pack("header"), data)
+}
+
+// openGraph loads a graph version; nil means it was dropped
+func (c *Collection) openGraph(txn *embedded.Transaction, version uint32) (*hnswGraph, error) {
+	space := c.graphSpace(version)
+	data, err := txn.Get(space.Pack("header"))
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	_, _, metric := c.indexConfig()
+	g := &hnswGraph{
+		c:       c,
+		txn:     txn,
+		space:   space,
+		metric:  metric,
+		nodes:   make(map[string][][]string),
+		dirty:   make(map[string]bool),
+		vectors: make(map[string][]float32),
+	}
+	if err := json.Unmarshal(data, &g.header); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// buildingGraph opens the graph of the build with version, failing if a
+// newer build replaced it
+func (c *Collection) buildingGraph(txn *embedded.Transaction, version uint32) (*hnswGraph, error) {
+	versions, err := c.loadIndexVersions(txn)
+	if err != nil {
+		return nil, err
+	}
+	if versions.Building != version {
+		return nil, errIndexBuildSuperseded
+	}
+
+	g, err := c.openGraph(txn, version)
+	if err == nil && g == nil {
+		err = errIndexBuildSuperseded
+	}
+	return g, err
+}
+
+func (c *Collection) graphSpace(version uint32) keys.Subspace {
+	return c.indexSpace().Sub("graphs", int64(version))
+}
+
+func (g *hnswGraph) node(id string) ([][]string, error) {
+	if links, ok := g.nodes[id]; ok {
+		return links, nil
+	}
+
+	data, err := g.txn.Get(g.space.Pack("nodes", id))
+	if err != nil {
+		return nil, err
+	}
+	var links [][]string
+	if data != nil {
+		if links, err = decodeLinks(data); err != nil {
+			return nil, err
+		}
+	}
+	g.nodes[id] = links
+	return links, nil
+}
+
+func (g *hnswGraph) setNode(id string, links [][]string) {
+	g.nodes[id] = links
+	g.dirty[id] = true
+}
+
+// vector loads the stored vector of id; nil means absent
+func (g *hnswGraph) vector(id string) ([]float32, error) {
+	if vector, ok := g.vectors[id]; ok {
+		return vector, nil
+	}
+
+	value, err := g.txn.Get(g.c.indexedKey(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var vector []float32
+	if isLegacyVector(value) {
+		var data vectorData
+		if err := json.Unmarshal(value, &data); err != nil {
+			return nil, err
+		}
+		vector = data.Vector
+	} else if value != nil {
+		if vector, _, err = decodeVector(value); err != nil {
+			return nil, err
+		}
+	}
+	g.vectors[id] = vector
+	return vector, nil
+}
+
+// similarity scores id against query; false means it cannot be compared
+func (g *hnswGraph) similarity(query []float32, id string) (float32, bool, error) {
+	vector, err := g.vector(id)
+	if err != nil || len(vector) != len(query) {
+		return 0, false, err
+	}
+	return score(g.metric, query, vector), true, nil
+}
+
+func (g *hnswGraph) maxLinks(level int) int {
+	if level == 0 {
+		return 2 * g.header.M
+	}
+	return g.header.M
+}
+
+func (g *hnswGraph) randomLevel() int {
+	mL := 1 / math.Log(float64(g.header.M))
+	return int(-math.Log(1-rand.Float64()) * mL)
+}
+
+// reindex replaces the node of id with its current vector, removing it if
+// the vector is gone
+func (g *hnswGraph) reindex(id string) error {
+	delete(g.vectors, id)
+	if err := g.remove(id); err != nil {
+		return err
+	}
+
+	vector, err := g.vector(id)
+	if err != nil || len(vector) == 0 {
+		return err
+	}
+	return g.insert(id, vector)
+}
+
+func (g *hnswGraph) insert(id string, vector []float32) error {
+	level := g.randomLevel()
+	links := make([][]string, level+1)
+	g.setNode(id, links)
+
+	if g.header.Entry == "" {
+		g.header.Entry, g.header.Level, g.headerChanged = id, level, true
+		return nil
+	}
+
+	entryScore, ok, err := g.similarity(vector, g.header.Entry)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("vector %s cannot be compared with the index entry point", id)
+	}
+	entry := []SearchResult{{ID: g.header.Entry, Score: entryScore}}
+
+	for l := g.header.Level; l > level; l-- {
+		if entry, err = g.searchLayer(vector, entry, 1, l); err != nil {
+			return err
+		}
+	}
+
+	top := level
+	if top > g.header.Level {
+		top = g.header.Level
+	}
+	for l := top; l >= 0; l-- {
+		found, err := g.searchLayer(vector, entry, g.header.EfConstruction, l)
+		if err != nil {
+			return err
+		}
+
+		for _, neighbor := range found {
+			if len(links[l]) == g.header.M {
+				break
+			}
+			if neighbor.ID == id {
+				continue
+			}
+			links[l] = append(links[l], neighbor.ID)
+			if err := g.link(neighbor.ID, id, l); err != nil {
+				return err
+			}
+		}
+		entry = found
+	}
+
+	if level > g.header.Level {
+		g.header.Entry, g.header.Level, g.headerChanged = id, level, true
+	}
+	return nil
+}
+
+// link adds a link from id to target at level, keeping only the closest
+// links when id has too many
+func (g *hnswGraph) link(id, target string, level int) error {
+	links, err := g.node(id)
+	if err != nil || level >= len(links) {
+		return err
+	}
+	for _, linked := range links[level] {
+		if linked == target {
+			return nil
+		}
+	}
+
+	links[level] = append(links[level], target)
+	if len(links[level]) > g.maxLinks(level) {
+		if links[level], err = g.closest(id, links[level], g.maxLinks(level)); err != nil {
+			return err
+		}
+	}
+
+	g.setNode(id, links)
+	return nil
+}
+
+// closest returns the n candidates closest to id, best first
+func (g *hnswGraph) closest(id string, candidates []string, n int) ([]string, error) {
+	vector, err := g.vector(id)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := &resultHeap{}
+	for _, candidate := range candidates {
+		s, ok, err := g.similarity(vector, candidate)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			kept.offer(SearchResult{ID: candidate, Score: s}, n)
+		}
+	}
+
+	selected := make([]string, 0, kept.Len())
+	for _, r := range kept.sorted() {
+		selected = append(selected, r.ID)
+	}
+	return selected, nil
+}
+
+// remove deletes the node of id and the links to it from its neighbors.
+// Each former neighbor is repaired by reselecting its links on that level
+// from its remaining links and the removed node's other neighbors, so
+// repeated updates do not leave it with ever fewer links.
+func (g *hnswGraph) remove(id string) error {
+	links, err := g.node(id)
+	if err != nil || links == nil {
+		return err
+	}
+	g.setNode(id, nil)
+
+	for l, neighbors := range links {
+		for _, neighbor := range neighbors {
+			theirs, err := g.node(neighbor)
+			if err != nil {
+				return err
+			}
+			if l >= len(theirs) {
+				continue
+			}
+
+			seen := map[string]bool{id: true, neighbor: true}
+			var candidates []string
+			for _, group := range [][]string{theirs[l], neighbors} {
+				for _, other := range group {
+					if !seen[other] {
+						seen[other] = true
+						candidates = append(candidates, other)
+					}
+				}
+			}
+			if theirs[l], err = g.closest(neighbor, candidates, g.maxLinks(l)); err != nil {
+				return err
+			}
+			g.setNode(neighbor, theirs)
+		}
+	}
+
+	if g.header.Entry == id {
+		return g.replaceEntry(links)
+	}
+	return nil
+}
+
+// replaceEntry picks a new entry point after the old one was removed,
+// preferring its neighbors on the highest level
+func (g *hnswGraph) replaceEntry(links [][]string) error {
+	g.header.Entry, g.header.Level, g.headerChanged = "", 0, true
+
+	for l := len(links) - 1; l >= 0; l-- {
+		for _, neighbor := range links[l] {
+			theirs, err := g.node(neighbor)
+			if err != nil {
+				return err
+			}
+			if theirs != nil {
+				g.header.Entry, g.header.Level = neighbor, len(theirs)-1
+				return nil
+			}
+		}
+	}
+
+	// Fall back to any remaining node
+	nodes := g.space.Sub("nodes")
+	iter := g.txn.ScanPrefix(nodes.Bytes())
+	defer iter.Close()
+
+	for {
+		key, value, ok := iter.Next()
+		if !ok {
+			break
+		}
+		tuple, err := nodes.Unpack(key)
+		if err != nil || len(tuple) != 1 {
+			continue
+		}
+		id, _ := tuple[0].(string)
+		if cached, ok := g.nodes[id]; ok && cached == nil {
+			continue
+		}
+		theirs, err := decodeLinks(value)
+		if err != nil {
+			return err
+		}
+		g.header.Entry, g.header.Level = id, len(theirs)-1
+		return nil
+	}
+	return iter.Err()
+}
+
+// search returns the ef best nodes for query, best first
+func (g *hnswGraph) search(query []float32, ef int) ([]SearchResult, error) {
+	if g.header.Entry == "" {
+		return []SearchResult{}, nil
+	}
+
+	entryScore, ok, err := g.similarity(query, g.header.Entry)
+	if err != nil || !ok {
+		return []SearchResult{}, err
+	}
+	entry := []SearchResult{{ID: g.header.Entry, Score: entryScore}}
+
+	for l := g.header.Level; l > 0; l-- {
+		if entry, err = g.searchLayer(query, entry, 1, l); err != nil {
+			return nil, err
+		}
+	}
+	return g.searchLayer(query, entry, ef, 0)
+}
+
+// searchLayer finds the ef nodes closest to query on one level, starting
+// from entry, best first
+func (g *hnswGraph) searchLayer(query []float32, entry []SearchResult, ef, level int) ([]SearchResult, error) {
+	visited := make(map[string]bool)
+	candidates := &hnswQueue{}
+	found := &resultHeap{}
+
+	for _, e := range entry {
+		visited[e.ID] = true
+		heap.Push(candidates, e)
+		found.offer(e, ef)
+	}
+
+	for candidates.Len() > 0 {
+		current := heap.Pop(candidates).(SearchResult)
+		if found.Len() >= ef && current.Score < (*found)[0].Score {
+			break
+		}
+
+		links, err := g.node(current.ID)
+		if err != nil {
+			return nil, err
+		}
+		if level >= len(links) {
+			continue
+		}
+
+		for _, neighbor := range links[level] {
+			if visited[neighbor] {
+				continue
+			}
+			visited[neighbor] = true
+
+			// A node reinserted on a lower level keeps the links to it from
+			// nodes it no longer links to
+			if level > 0 {
+				theirs, err := g.node(neighbor)
+				if err != nil {
+					return nil, err
+				}
+				if level >= len(theirs) {
+					continue
+				}
+			}
+
+			s, ok, err := g.similarity(query, neighbor)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			if found.Len() < ef || s > (*found)[0].Score {
+				result := SearchResult{ID: neighbor, Score: s}
+				heap.Push(candidates, result)
+				found.offer(result, ef)
+			}
+		}
+	}
+
+	return found.sorted(), nil
+}
+
+// flush writes the changed nodes and header
+func (g *hnswGraph) flush() error {
+	for id := range g.dirty {
+		key := g.space.Pack("nodes", id)
+		var err error
+		if links := g.nodes[id]; links == nil {
+			err = g.txn.Delete(key)
+		} else {
+			err = g.txn.Put(key, encodeLinks(links))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	g.dirty = make(map[string]bool)
+
+	if !g.headerChanged {
+		return nil
+	}
+	data, err := json.Marshal(g.header)
+	if err != nil {
+		return err
+	}
+	g.headerChanged = false
+	return g.txn.Put(g.space.Pack("header"), data)
+}
+
+// hnswQueue is a max-heap on score of nodes to expand
+type hnswQueue []SearchResult
+
+func (h hnswQueue) Len() int            { return len(h) }
+func (h hnswQueue) Less(i, j int) bool  { return h[i].Score > h[j].Score }
+func (h hnswQueue) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *hnswQueue) Push(x interface{}) { *h = append(*h, x.(SearchResult)) }
+func (h *hnswQueue) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Node layout: level count (uvarint), then per level a link count (uvarint)
+// and each linked ID, length-prefixed (uvarint)
+func encodeLinks(links [][]string) []byte {
+	buf := binary.AppendUvarint(nil, uint64(len(links)))
+	for _, level := range links {
+		buf = binary.AppendUvarint(buf, uint64(len(level)))
+		for _, id := range level {
+			buf = binary.AppendUvarint(buf, uint64(len(id)))
+			buf = append(buf, id...)
+		}
+	}
+	return buf
+}
+
+func decodeLinks(data []byte) ([][]string, error) {
+	invalid := errors.New("invalid index node")
+
+	levels, n := binary.Uvarint(data)
+	if n <= 0 || levels == 0 {
+		return nil, invalid
+	}
+	data = data[n:]
+
+	links := make([][]string, levels)
+	for l := range links {
+		count, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, invalid
+		}
+		data = data[n:]
+
+		links[l] = make([]string, 0, count)
+		for i := uint64(0); i < count; i++ {
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return nil, invalid
+			}
+			links[l] = append(links[l], string(data[n:n+int(size)]))
+			data = data[n+int(size):]
+		}
+	}
+	return links, nil
+}
@@ -0,0 +1,255 @@
+package sochdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/sochdb/sochdb-go/embedded"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCollectionIndexBuild tests HNSW indexing and background rebuilds
+func TestCollectionIndexBuild(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 8})
+	require.NoError(t, err)
+
+	rng := rand.New(rand.NewSource(7))
+	randomVector := func() []float32 {
+		v := make([]float32, 8)
+		for i := range v {
+			v[i] = rng.Float32()*2 - 1
+		}
+		return v
+	}
+	vectors := make(map[string][]float32)
+	items := make([]InsertItem, 300)
+	for i := range items {
+		items[i] = InsertItem{ID: fmt.Sprintf("v%03d", i), Vector: randomVector()}
+		vectors[items[i].ID] = items[i].Vector
+	}
+	_, err = docs.InsertBatch(items, BatchOptions{})
+	require.NoError(t, err)
+
+	status, err := docs.IndexStatus()
+	require.NoError(t, err)
+	assert.Nil(t, status)
+
+	config := docs.config
+	config.Indexed = true
+	docs, build, err := ns.UpdateCollection(context.Background(), config)
+	require.NoError(t, err)
+	require.NotNil(t, build)
+
+	// Writes during the build are caught up before the swap
+	late := randomVector()
+	_, err = docs.Insert(late, nil, "late")
+	require.NoError(t, err)
+	vectors["late"] = late
+
+	require.NoError(t, build.Wait())
+	progress := build.Progress()
+	assert.True(t, progress.Done)
+	assert.Equal(t, progress.Total, progress.Indexed)
+
+	status, err = docs.IndexStatus()
+	require.NoError(t, err)
+	require.NotNil(t, status)
+	assert.Equal(t, IndexStateReady, status.State)
+	assert.Equal(t, defaultHNSWM, status.M)
+
+	recall := func(query []float32) int {
+		exact := &resultHeap{}
+		for id, v := range vectors {
+			exact.offer(SearchResult{ID: id, Score: score(DistanceMetricCosine, query, v)}, 10)
+		}
+		want := make(map[string]bool)
+		for _, r := range *exact {
+			want[r.ID] = true
+		}
+
+		results, err := docs.Search(SearchRequest{QueryVector: query, K: 10})
+		require.NoError(t, err)
+		hits := 0
+		for _, r := range results {
+			if want[r.ID] {
+				hits++
+			}
+		}
+		return hits
+	}
+	for i := 0; i < 5; i++ {
+		assert.GreaterOrEqual(t, recall(randomVector()), 8)
+	}
+
+	results, err := docs.Search(SearchRequest{QueryVector: late, K: 1})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "late", results[0].ID)
+
+	// The graph follows writes and deletes
+	fresh := randomVector()
+	_, err = docs.Insert(fresh, nil, "fresh")
+	require.NoError(t, err)
+	results, err = docs.Search(SearchRequest{QueryVector: fresh, K: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "fresh", results[0].ID)
+	require.NoError(t, docs.UpdateVector("fresh", late))
+	require.NoError(t, docs.Delete("late"))
+	results, err = docs.Search(SearchRequest{QueryVector: late, K: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "fresh", results[0].ID)
+
+	// graphs returns the routed versions and the versions with stored graphs
+	graphs := func() (indexVersions, map[int64]bool) {
+		var versions indexVersions
+		stored := make(map[int64]bool)
+		require.NoError(t, withTxn(db, func(txn *embedded.Transaction) error {
+			var err error
+			if versions, err = docs.loadIndexVersions(txn); err != nil {
+				return err
+			}
+			found, err := collectKeys(txn, docs.indexSpace().Sub("graphs").Bytes())
+			for _, key := range found {
+				tuple, _ := docs.indexSpace().Sub("graphs").Unpack(key)
+				stored[tuple[0].(int64)] = true
+			}
+			return err
+		}))
+		return versions, stored
+	}
+	ready, _ := graphs()
+	require.NotZero(t, ready.Active)
+
+	// A cancelled rebuild keeps the previous graph serving searches
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	config.HNSWM = 8
+	docs, build, err = ns.UpdateCollection(cancelled, config)
+	require.NoError(t, err)
+	assert.ErrorIs(t, build.Wait(), context.Canceled)
+	status, err = docs.IndexStatus()
+	require.NoError(t, err)
+	assert.Equal(t, IndexStateFailed, status.State)
+	versions, stored := graphs()
+	assert.Equal(t, indexVersions{Active: ready.Active}, versions)
+	assert.Equal(t, map[int64]bool{int64(ready.Active): true}, stored)
+	results, err = docs.Search(SearchRequest{QueryVector: late, K: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "fresh", results[0].ID)
+	require.NoError(t, withTxn(db, func(txn *embedded.Transaction) error {
+		_, used, err := docs.indexSearch(txn, late, 1, float32(math.Inf(-1)))
+		assert.True(t, used)
+		return err
+	}))
+
+	// A successful rebuild drops the graph it replaces
+	build, err = docs.BuildIndex(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, build.Wait())
+	status, err = docs.IndexStatus()
+	require.NoError(t, err)
+	assert.Equal(t, IndexStateReady, status.State)
+	assert.Equal(t, 8, status.M)
+	assert.GreaterOrEqual(t, recall(randomVector()), 8)
+	versions, stored = graphs()
+	assert.Equal(t, indexVersions{Active: status.Version}, versions)
+	assert.Equal(t, map[int64]bool{int64(status.Version): true}, stored)
+
+	// Disabling the index drops it
+	config.Indexed = false
+	docs, build, err = ns.UpdateCollection(context.Background(), config)
+	require.NoError(t, err)
+	assert.Nil(t, build)
+	_, err = docs.BuildIndex(context.Background())
+	assert.Error(t, err)
+
+	// New indexed collections maintain their graph from the first write
+	indexed, err := ns.CreateCollection(CollectionConfig{Name: "indexed", Dimension: 8, Indexed: true})
+	require.NoError(t, err)
+	_, err = indexed.InsertBatch(items[:50], BatchOptions{})
+	require.NoError(t, err)
+	results, err = indexed.Search(SearchRequest{QueryVector: items[7].Vector, K: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "v007", results[0].ID)
+}
+
+// TestCollectionIndexUpserts tests that the graph keeps its recall while the
+// same vectors are replaced over and over
+func TestCollectionIndexUpserts(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 16, Indexed: true, HNSWM: 4, HNSWEfConstruction: 16})
+	require.NoError(t, err)
+
+	rng := rand.New(rand.NewSource(11))
+	randomVector := func() []float32 {
+		v := make([]float32, 16)
+		for i := range v {
+			v[i] = rng.Float32()*2 - 1
+		}
+		return v
+	}
+	for round := 0; round < 6; round++ {
+		items := make([]InsertItem, 500)
+		for i := range items {
+			items[i] = InsertItem{ID: fmt.Sprintf("v%03d", i), Vector: randomVector()}
+		}
+		_, err = docs.InsertBatch(items, BatchOptions{Upsert: true})
+		require.NoError(t, err)
+	}
+
+	// The neighbors of replaced nodes are reconnected
+	require.NoError(t, withTxn(db, func(txn *embedded.Transaction) error {
+		versions, err := docs.loadIndexVersions(txn)
+		if err != nil {
+			return err
+		}
+		g, err := docs.openGraph(txn, versions.Active)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 500; i++ {
+			links, err := g.node(fmt.Sprintf("v%03d", i))
+			if err != nil {
+				return err
+			}
+			require.NotEmpty(t, links)
+			assert.GreaterOrEqual(t, len(links[0]), 4, "v%03d", i)
+		}
+		return nil
+	}))
+
+	hits, total := 0, 0
+	for i := 0; i < 20; i++ {
+		query := randomVector()
+		var exact []SearchResult
+		require.NoError(t, withTxn(db, func(txn *embedded.Transaction) error {
+			var err error
+			exact, err = docs.exactSearch(txn, query, 10, float32(math.Inf(-1)), nil)
+			return err
+		}))
+		want := make(map[string]bool)
+		for _, r := range exact {
+			want[r.ID] = true
+		}
+
+		results, err := docs.Search(SearchRequest{QueryVector: query, K: 10})
+		require.NoError(t, err)
+		for _, r := range results {
+			if want[r.ID] {
+				hits++
+			}
+		}
+		total += len(exact)
+	}
+	assert.GreaterOrEqual(t, float64(hits)/float64(total), 0.9)
+}
@@ -0,0 +1,486 @@
+// Named and multi-vector documents
+//
+// Besides its default vector, a collection entry can hold named vectors
+// declared in CollectionConfig.Vectors, each with its own dimension and
+// metric (e.g. separate title and body embeddings). A multi-vector field
+// holds many vectors per document, such as ColBERT token embeddings, and is
+// scored by late interaction: the sum over query vectors of the best match
+// in the document (MaxSim).
+//
+// A single-vector field with Indexed set keeps its own HNSW graph, with its
+// own HNSWM and HNSWEfConstruction, maintained like the default vector's
+// (see collection_index.go) and used by searches on that name without a
+// filter. Multi-vector fields cannot be indexed and are always scanned.
+// Index settings of named vectors are fixed at creation; BuildNamedIndex
+// rebuilds one.
+//
+// Example:
+//
+//	docs, err := ns.CreateCollection(sochdb.CollectionConfig{
+//	    Name: "papers",
+//	    Vectors: map[string]sochdb.NamedVectorConfig{
+//	        "title":  {Dimension: 384, Indexed: true},
+//	        "tokens": {Dimension: 128, MultiVector: true},
+//	    },
+//	})
+//
+//	_, err = docs.InsertDocument(sochdb.Document{
+//	    ID:           "paper1",
+//	    Vectors:      map[string][]float32{"title": titleEmbedding},
+//	    MultiVectors: map[string][][]float32{"tokens": tokenEmbeddings},
+//	})
+//
+//	results, err := docs.Search(sochdb.SearchRequest{
+//	    VectorName:   "tokens",
+//	    QueryVectors: queryTokenEmbeddings,
+//	    K:            10,
+//	})
+
+package sochdb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sochdb/sochdb-go/embedded"
+)
+
+// NamedVectorConfig configures a named vector of a collection
+type NamedVectorConfig struct {
+	Dimension      int            `json:"dimension,omitempty"`
+	Metric         DistanceMetric `json:"metric,omitempty"`
+	VectorEncoding VectorEncoding `json:"vector_encoding,omitempty"`
+	MultiVector    bool           `json:"multi_vector,omitempty"` // Many vectors per document, scored by MaxSim
+
+	// HNSW index of a single-vector field
+	Indexed            bool `json:"indexed,omitempty"`
+	HNSWM              int  `json:"hnsw_m,omitempty"`
+	HNSWEfConstruction int  `json:"hnsw_ef_construction,omitempty"`
+}
+
+// validateNamedVectors checks the named vector configs of a new collection
+func validateNamedVectors(collection string, vectors map[string]NamedVectorConfig) error {
+	for name, config := range vectors {
+		if config.Indexed && config.MultiVector {
+			return fmt.Errorf("collection %s: multi-vector field %q cannot be indexed", collection, name)
+		}
+	}
+	return nil
+}
+
+// Document is a collection entry with named vectors
+type Document struct {
+	ID           string
+	Vector       []float32              // The default vector; optional
+	Vectors      map[string][]float32   // Single-vector fields by name
+	MultiVectors map[string][][]float32 // Multi-vector fields by name
+	Metadata     map[string]interface{}
+	Timestamp    int64 // Unix milliseconds, set on write
+}
+
+// InsertDocument adds a document with named vectors
+// Returns VectorExistsError if the ID is taken.
+func (c *Collection) InsertDocument(doc Document) (string, error) {
+	return c.putDocument(doc, false)
+}
+
+// UpsertDocument adds a document, or replaces every vector and the metadata
+// stored under its ID
+func (c *Collection) UpsertDocument(doc Document) (string, error) {
+	return c.putDocument(doc, true)
+}
+
+// GetDocument retrieves a document with all of its named vectors
+func (c *Collection) GetDocument(id string) (_ *Document, err error) {
+	access, err := c.authorize(GrantOperationRead)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { access.done(err) }()
+
+	var doc *Document
+	err = withTxn(c.db, func(txn *embedded.Transaction) error {
+		data, err := c.readVector(txn, id)
+		if err != nil || data == nil {
+			return err
+		}
+
+		doc = &Document{ID: id, Metadata: data.Metadata, Timestamp: data.Timestamp}
+		if len(data.Vector) > 0 {
+			doc.Vector = data.Vector
+		}
+
+		for name, config := range c.config.Vectors {
+			value, err := txn.Get(c.namedKey(name, id))
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				continue
+			}
+
+			if config.MultiVector {
+				vectors, err := decodeMultiVector(value)
+				if err != nil {
+					return err
+				}
+				if doc.MultiVectors == nil {
+					doc.MultiVectors = make(map[string][][]float32)
+				}
+				doc.MultiVectors[name] = vectors
+			} else {
+				vector, _, err := decodeVector(value)
+				if err != nil {
+					return err
+				}
+				if doc.Vectors == nil {
+					doc.Vectors = make(map[string][]float32)
+				}
+				doc.Vectors[name] = vector
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+func (c *Collection) putDocument(doc Document, replace bool) (_ string, err error) {
+	if err := c.validateDocument(doc); err != nil {
+		return "", err
+	}
+
+	access, err := c.authorize(GrantOperationWrite)
+	if err != nil {
+		return "", err
+	}
+	defer func() { access.done(err) }()
+
+	id := doc.ID
+	if id == "" {
+		id = c.generateID()
+	}
+
+	metadata, err := c.conform(id, doc.Metadata)
+	if err != nil {
+		return "", err
+	}
+
+	timestamp := time.Now().UnixMilli()
+	p, err := c.prepareVector(id, vectorData{Vector: doc.Vector, Metadata: metadata, Timestamp: timestamp})
+	if err != nil {
+		return "", err
+	}
+	if p.named, err = c.encodeNamed(doc, timestamp); err != nil {
+		return "", err
+	}
+
+	err = retryConflicts(c.db, func(txn *embedded.Transaction) error {
+		if !replace {
+			existing, err := txn.Get(c.vectorKey(id))
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				return &VectorExistsError{Collection: c.name, ID: id}
+			}
+		}
+
+		created, err := c.storeVector(txn, p, nil)
+		if err != nil || !created {
+			return err
+		}
+		return c.addCount(txn, 1)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (c *Collection) validateDocument(doc Document) error {
+	if len(doc.Vector) > 0 && c.config.Dimension > 0 && len(doc.Vector) != c.config.Dimension {
+		return fmt.Errorf("vector dimension mismatch: expected %d, got %d", c.config.Dimension, len(doc.Vector))
+	}
+
+	check := func(name string, vector []float32, multi bool) error {
+		config, ok := c.config.Vectors[name]
+		if !ok {
+			return fmt.Errorf("collection %s has no named vector %q", c.name, name)
+		}
+		if config.MultiVector != multi {
+			if multi {
+				return fmt.Errorf("named vector %q takes a single vector", name)
+			}
+			return fmt.Errorf("named vector %q takes multiple vectors", name)
+		}
+		if len(vector) == 0 {
+			return fmt.Errorf("named vector %q is empty", name)
+		}
+		if config.Dimension > 0 && len(vector) != config.Dimension {
+			return fmt.Errorf("named vector %q dimension mismatch: expected %d, got %d", name, config.Dimension, len(vector))
+		}
+		return nil
+	}
+
+	for name, vector := range doc.Vectors {
+		if err := check(name, vector, false); err != nil {
+			return err
+		}
+	}
+	for name, vectors := range doc.MultiVectors {
+		if len(vectors) == 0 {
+			return fmt.Errorf("named vector %q is empty", name)
+		}
+		for _, vector := range vectors {
+			if err := check(name, vector, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// encodeNamed encodes a document's named vectors by name
+func (c *Collection) encodeNamed(doc Document, timestamp int64) (map[string][]byte, error) {
+	named := make(map[string][]byte, len(c.config.Vectors))
+
+	for name, config := range c.config.Vectors {
+		var err error
+		if vectors, ok := doc.MultiVectors[name]; ok && config.MultiVector {
+			named[name], err = encodeMultiVector(vectors, config.VectorEncoding, timestamp)
+		} else if vector, ok := doc.Vectors[name]; ok && !config.MultiVector {
+			named[name], err = encodeVector(vector, config.VectorEncoding, timestamp)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return named, nil
+}
+
+// storeNamed replaces the named vectors of id within txn, removing those
+// missing from named, and returns the usage change. Index updates are added
+// to batch if it is not nil.
+func (c *Collection) storeNamed(txn *embedded.Transaction, id string, named map[string][]byte, batch indexBatch) (NamespaceUsage, error) {
+	var delta NamespaceUsage
+
+	for name := range c.config.Vectors {
+		key := c.namedKey(name, id)
+		old, err := txn.Get(key)
+		if err != nil {
+			return delta, err
+		}
+
+		value := named[name]
+		delta = addUsage(delta, keyUsageDelta(key, old, value))
+
+		if value != nil {
+			err = txn.Put(key, value)
+		} else if old != nil {
+			err = txn.Delete(key)
+		}
+		if err != nil {
+			return delta, err
+		}
+
+		if config := c.config.Vectors[name]; config.Indexed && (old != nil || value != nil) {
+			if err := c.vectorIndex(name).updateIndex(txn, id, old, value, batch); err != nil {
+				return delta, err
+			}
+		}
+	}
+
+	return delta, nil
+}
+
+// BuildNamedIndex starts a background (re)build of the index of the named
+// vector name; see BuildIndex
+func (c *Collection) BuildNamedIndex(ctx context.Context, name string) (*IndexBuild, error) {
+	if _, ok := c.config.Vectors[name]; !ok {
+		return nil, fmt.Errorf("collection %s has no named vector %q", c.name, name)
+	}
+	return c.vectorIndex(name).BuildIndex(ctx)
+}
+
+// NamedIndexStatus returns the state of the latest index build of the
+// named vector name, or nil if it was never indexed
+func (c *Collection) NamedIndexStatus(name string) (*IndexStatus, error) {
+	if _, ok := c.config.Vectors[name]; !ok {
+		return nil, fmt.Errorf("collection %s has no named vector %q", c.name, name)
+	}
+	return c.vectorIndex(name).IndexStatus()
+}
+
+func (c *Collection) namedKey(name, id string) []byte {
+	return c.space().Pack("named", name, id)
+}
+
+// namedSearch ranks documents by one of their named vectors
+func (c *Collection) namedSearch(txn *embedded.Transaction, request SearchRequest, k int, floor float32, filter map[string]interface{}) ([]SearchResult, error) {
+	config := c.config.Vectors[request.VectorName]
+	queries := request.QueryVectors
+	if len(request.QueryVector) > 0 {
+		queries = append([][]float32{request.QueryVector}, queries...)
+	}
+
+	// The graph cannot guarantee K filtered results or complete ranges
+	if config.Indexed && filter == nil && k != unboundedK && len(queries) == 1 {
+		results, ok, err := c.vectorIndex(request.VectorName).indexSearch(txn, queries[0], k, floor)
+		if err != nil || ok {
+			return results, err
+		}
+	}
+
+	top := &resultHeap{}
+	named := c.space().Sub("named", request.VectorName)
+
+	iter := txn.ScanPrefix(named.Bytes())
+	defer iter.Close()
+
+	for {
+		key, value, ok := iter.Next()
+		if !ok {
+			break
+		}
+		tuple, err := named.Unpack(key)
+		if err != nil || len(tuple) != 1 {
+			continue
+		}
+		id, ok := tuple[0].(string)
+		if !ok {
+			continue
+		}
+
+		var vectors [][]float32
+		if config.MultiVector {
+			if vectors, err = decodeMultiVector(value); err != nil {
+				return nil, err
+			}
+		} else {
+			vector, _, err := decodeVector(value)
+			if err != nil {
+				return nil, err
+			}
+			vectors = [][]float32{vector}
+		}
+
+		s := maxSim(config.Metric, queries, vectors)
+		if s < floor {
+			continue
+		}
+		if filter != nil {
+			metadata, err := c.readMetadata(txn, id)
+			if err != nil {
+				return nil, err
+			}
+			if !matchesFilter(metadata, filter) {
+				continue
+			}
+		}
+
+		result := SearchResult{ID: id, Score: s}
+		if len(queries) == 1 {
+			result.Distance = distanceFromScore(config.Metric, s)
+		}
+		top.offer(result, k)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return top.sorted(), nil
+}
+
+// validateNamedQuery checks a search on a named vector
+func (c *Collection) validateNamedQuery(request SearchRequest) error {
+	config, ok := c.config.Vectors[request.VectorName]
+	if !ok {
+		return fmt.Errorf("collection %s has no named vector %q", c.name, request.VectorName)
+	}
+	queries := request.QueryVectors
+	if len(request.QueryVector) > 0 {
+		queries = append([][]float32{request.QueryVector}, queries...)
+	}
+	if len(queries) == 0 {
+		return errors.New("search requires a query vector or query text")
+	}
+	if len(queries) > 1 && !config.MultiVector {
+		return fmt.Errorf("named vector %q takes a single query vector", request.VectorName)
+	}
+	for _, q := range queries {
+		if config.Dimension > 0 && len(q) != config.Dimension {
+			return fmt.Errorf("query dimension mismatch: expected %d, got %d", config.Dimension, len(q))
+		}
+	}
+	return nil
+}
+
+// maxSim scores a document by late interaction: the sum over queries of
+// the best score against any document vector. With one query and one
+// document vector it is the plain score.
+func maxSim(metric DistanceMetric, queries, vectors [][]float32) float32 {
+	var total float32
+	for _, q := range queries {
+		best := float32(math.Inf(-1))
+		for _, v := range vectors {
+			if len(v) != len(q) {
+				continue
+			}
+			if s := score(metric, q, v); s > best {
+				best = s
+			}
+		}
+		if !math.IsInf(float64(best), -1) {
+			total += best
+		}
+	}
+	return total
+}
+
+// Multi-vector layout: count (uvarint), then each vector as a
+// length-prefixed (uvarint) binary vector record
+func encodeMultiVector(vectors [][]float32, encoding VectorEncoding, timestamp int64) ([]byte, error) {
+	buf := binary.AppendUvarint(nil, uint64(len(vectors)))
+	for _, vector := range vectors {
+		record, err := encodeVector(vector, encoding, timestamp)
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(record)))
+		buf = append(buf, record...)
+	}
+	return buf, nil
+}
+
+func decodeMultiVector(data []byte) ([][]float32, error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, errors.New("invalid multi-vector record")
+	}
+	data = data[n:]
+
+	vectors := make([][]float32, 0, count)
+	for i := uint64(0); i < count; i++ {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < size {
+			return nil, errors.New("truncated multi-vector record")
+		}
+		vector, _, err := decodeVector(data[n : n+int(size)])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, vector)
+		data = data[n+int(size):]
+	}
+	return vectors, nil
+}
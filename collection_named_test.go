@@ -0,0 +1,227 @@
+package sochdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/sochdb/sochdb-go/embedded"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCollectionNamedVectors tests named and multi-vector documents
+func TestCollectionNamedVectors(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+	papers, err := ns.CreateCollection(CollectionConfig{
+		Name: "papers",
+		Vectors: map[string]NamedVectorConfig{
+			"title":  {Dimension: 2, Metric: DistanceMetricDotProduct},
+			"tokens": {Dimension: 2, MultiVector: true, VectorEncoding: VectorEncodingFloat16},
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = papers.InsertDocument(Document{
+		ID:           "a",
+		Vectors:      map[string][]float32{"title": {1, 0}},
+		MultiVectors: map[string][][]float32{"tokens": {{1, 0}, {0, 1}}},
+		Metadata:     map[string]interface{}{"year": 2024},
+	})
+	require.NoError(t, err)
+	_, err = papers.InsertDocument(Document{
+		ID:           "b",
+		Vectors:      map[string][]float32{"title": {0, 3}},
+		MultiVectors: map[string][][]float32{"tokens": {{1, 1}}},
+	})
+	require.NoError(t, err)
+
+	_, err = papers.InsertDocument(Document{ID: "c", Vectors: map[string][]float32{"body": {1, 0}}})
+	assert.Error(t, err)
+	_, err = papers.InsertDocument(Document{ID: "c", Vectors: map[string][]float32{"tokens": {1, 0}}})
+	assert.Error(t, err)
+
+	doc, err := papers.GetDocument("a")
+	require.NoError(t, err)
+	assert.Nil(t, doc.Vector)
+	assert.Equal(t, []float32{1, 0}, doc.Vectors["title"])
+	assert.Equal(t, [][]float32{{1, 0}, {0, 1}}, doc.MultiVectors["tokens"])
+	assert.Equal(t, float64(2024), doc.Metadata["year"])
+
+	results, err := papers.Search(SearchRequest{VectorName: "title", QueryVector: []float32{0, 1}, K: 2})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "b", results[0].ID)
+	assert.Equal(t, float32(3), results[0].Score)
+
+	// MaxSim: "a" matches each query token exactly, "b" only partially
+	results, err = papers.Search(SearchRequest{VectorName: "tokens", QueryVectors: [][]float32{{1, 0}, {0, 1}}, K: 2})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "a", results[0].ID)
+	assert.InDelta(t, 2, results[0].Score, 1e-6)
+
+	_, err = papers.Search(SearchRequest{VectorName: "title", QueryVectors: [][]float32{{1, 0}, {0, 1}}})
+	assert.Error(t, err)
+	_, err = papers.Search(SearchRequest{VectorName: "missing", QueryVector: []float32{1, 0}})
+	assert.Error(t, err)
+
+	// Upserting without a field removes it; deleting removes the rest
+	_, err = papers.UpsertDocument(Document{ID: "a", Vectors: map[string][]float32{"title": {1, 1}}})
+	require.NoError(t, err)
+	doc, err = papers.GetDocument("a")
+	require.NoError(t, err)
+	assert.Nil(t, doc.MultiVectors)
+	assert.Nil(t, doc.Metadata)
+
+	require.NoError(t, papers.Delete("b"))
+	results, err = papers.Search(SearchRequest{VectorName: "tokens", QueryVector: []float32{1, 1}})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	usage, err := ns.Usage()
+	require.NoError(t, err)
+	require.NoError(t, papers.Delete("a"))
+	after, err := ns.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), after.Keys)
+	assert.Less(t, after.Bytes, usage.Bytes)
+	assert.Equal(t, int64(0), after.Vectors)
+}
+
+// TestCollectionNamedIndex tests HNSW indexes on named vectors
+func TestCollectionNamedIndex(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+
+	_, err = ns.CreateCollection(CollectionConfig{
+		Name:    "invalid",
+		Vectors: map[string]NamedVectorConfig{"tokens": {Dimension: 2, MultiVector: true, Indexed: true}},
+	})
+	assert.Error(t, err)
+
+	papers, err := ns.CreateCollection(CollectionConfig{
+		Name: "papers",
+		Vectors: map[string]NamedVectorConfig{
+			"title": {Dimension: 2, Indexed: true, HNSWM: 4},
+			"body":  {Dimension: 2},
+		},
+	})
+	require.NoError(t, err)
+
+	for id, vector := range map[string][]float32{"a": {1, 0}, "b": {0, 1}, "c": {1, 1}} {
+		_, err = papers.InsertDocument(Document{
+			ID:      id,
+			Vectors: map[string][]float32{"title": vector, "body": vector},
+		})
+		require.NoError(t, err)
+	}
+
+	// inGraph reports which IDs the active graph of the title index holds
+	title := papers.vectorIndex("title")
+	inGraph := func(ids ...string) map[string]bool {
+		held := make(map[string]bool)
+		require.NoError(t, withTxn(db, func(txn *embedded.Transaction) error {
+			versions, err := title.loadIndexVersions(txn)
+			if err != nil {
+				return err
+			}
+			g, err := title.openGraph(txn, versions.Active)
+			if err != nil {
+				return err
+			}
+			require.NotNil(t, g)
+			for _, id := range ids {
+				links, err := g.node(id)
+				if err != nil {
+					return err
+				}
+				held[id] = links != nil
+			}
+			return nil
+		}))
+		return held
+	}
+	assert.Equal(t, map[string]bool{"a": true, "b": true, "c": true}, inGraph("a", "b", "c"))
+
+	results, err := papers.Search(SearchRequest{VectorName: "title", QueryVector: []float32{0, 1}, K: 1})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "b", results[0].ID)
+	require.NoError(t, withTxn(db, func(txn *embedded.Transaction) error {
+		_, used, err := title.indexSearch(txn, []float32{0, 1}, 1, float32(math.Inf(-1)))
+		assert.True(t, used)
+		return err
+	}))
+
+	// The unindexed field and the default vector have no graph
+	status, err := papers.NamedIndexStatus("body")
+	require.NoError(t, err)
+	assert.Nil(t, status)
+	status, err = papers.IndexStatus()
+	require.NoError(t, err)
+	assert.Nil(t, status)
+	_, err = papers.BuildNamedIndex(context.Background(), "body")
+	assert.Error(t, err)
+
+	// Removing the field or the document removes its node
+	_, err = papers.UpsertDocument(Document{ID: "b", Vectors: map[string][]float32{"body": {0, 1}}})
+	require.NoError(t, err)
+	require.NoError(t, papers.Delete("c"))
+	assert.Equal(t, map[string]bool{"a": true, "b": false, "c": false}, inGraph("a", "b", "c"))
+
+	results, err = papers.Search(SearchRequest{VectorName: "title", QueryVector: []float32{0, 1}, K: 3})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "a", results[0].ID)
+
+	// A rebuild indexes only documents holding the field
+	build, err := papers.BuildNamedIndex(context.Background(), "title")
+	require.NoError(t, err)
+	require.NoError(t, build.Wait())
+	status, err = papers.NamedIndexStatus("title")
+	require.NoError(t, err)
+	assert.Equal(t, IndexStateReady, status.State)
+	assert.Equal(t, 4, status.M)
+	assert.Equal(t, int64(1), status.Total)
+	assert.Equal(t, map[string]bool{"a": true, "b": false}, inGraph("a", "b"))
+}
+
+// TestCollectionDocumentConcurrency tests that concurrent document writes
+// retry conflicts like plain inserts
+func TestCollectionDocumentConcurrency(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+	papers, err := ns.CreateCollection(CollectionConfig{
+		Name:    "papers",
+		Vectors: map[string]NamedVectorConfig{"title": {Dimension: 2, Indexed: true}},
+	})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				_, err := papers.UpsertDocument(Document{
+					ID:      fmt.Sprintf("%d-%d", w, i%4),
+					Vectors: map[string][]float32{"title": {float32(w), float32(i)}},
+				})
+				assert.NoError(t, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	count, err := papers.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 16, count)
+}
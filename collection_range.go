@@ -0,0 +1,111 @@
+// Range search and score thresholds
+//
+// A search with MinScore or MaxDistance drops results past the threshold,
+// and with no K it returns every match instead of a fixed number. Distances
+// follow the collection's metric: 1 - similarity for cosine, L2 distance for
+// euclidean, and the negated dot product for dot, so a smaller distance is
+// always closer.
+//
+// Example:
+//
+//	// Near-duplicates of a new document
+//	dups, err := docs.RangeSearch(embedding, 0.05)
+//
+//	// At most 10 results scoring 0.8 or better
+//	minScore := float32(0.8)
+//	results, err := docs.Search(sochdb.SearchRequest{
+//	    QueryVector: embedding,
+//	    K:           10,
+//	    MinScore:    &minScore,
+//	})
+
+package sochdb
+
+import (
+	"errors"
+	"math"
+)
+
+// unboundedK is the result limit of a range search
+const unboundedK = math.MaxInt
+
+// RangeSearch returns every vector within radius of query, closest first
+func (c *Collection) RangeSearch(query []float32, radius float32) ([]SearchResult, error) {
+	if radius < 0 {
+		return nil, errors.New("radius must not be negative")
+	}
+	return c.Search(SearchRequest{QueryVector: query, MaxDistance: &radius})
+}
+
+// distanceFromScore converts a score to a distance under metric
+func distanceFromScore(metric DistanceMetric, score float32) float32 {
+	switch metric {
+	case DistanceMetricDotProduct, DistanceMetricEuclidean:
+		return -score
+	default:
+		return 1 - score
+	}
+}
+
+// scoreFromDistance converts a distance to a score under metric
+func scoreFromDistance(metric DistanceMetric, distance float32) float32 {
+	switch metric {
+	case DistanceMetricDotProduct, DistanceMetricEuclidean:
+		return -distance
+	default:
+		return 1 - distance
+	}
+}
+
+// searchMetric returns the metric a request's vectors are scored with
+func (c *Collection) searchMetric(request SearchRequest) DistanceMetric {
+	if request.VectorName != "" {
+		return c.config.Vectors[request.VectorName].Metric
+	}
+	return c.config.Metric
+}
+
+// vectorFloor returns the lowest vector score a request accepts
+func (c *Collection) vectorFloor(request SearchRequest) float32 {
+	floor := float32(math.Inf(-1))
+	if request.MaxDistance != nil {
+		floor = scoreFromDistance(c.searchMetric(request), *request.MaxDistance)
+	}
+	if request.MinScore != nil && request.QueryText == "" && *request.MinScore > floor {
+		floor = *request.MinScore
+	}
+	return floor
+}
+
+// validateThresholds checks the thresholds of a search
+func validateThresholds(request SearchRequest) error {
+	if request.MaxDistance == nil {
+		return nil
+	}
+	if len(request.QueryVector) == 0 && len(request.QueryVectors) == 0 {
+		return errors.New("MaxDistance requires a query vector")
+	}
+	if len(request.QueryVectors) > 0 {
+		return errors.New("MaxDistance requires a single query vector")
+	}
+	return nil
+}
+
+// aboveScore drops results scoring below minScore
+func aboveScore(results []SearchResult, minScore float32) []SearchResult {
+	kept := results[:0]
+	for _, r := range results {
+		if r.Score >= minScore {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// saturatingMul multiplies a result limit without overflowing
+func saturatingMul(k, factor int) int {
+	if k > unboundedK/factor {
+		return unboundedK
+	}
+	return k * factor
+}
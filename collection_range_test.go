@@ -0,0 +1,76 @@
+package sochdb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCollectionRangeSearch tests thresholds and radius search per metric
+func TestCollectionRangeSearch(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+
+	items := []InsertItem{
+		{ID: "x", Vector: []float32{1, 0}},
+		{ID: "near", Vector: []float32{2, 0.1}},
+		{ID: "y", Vector: []float32{0, 1}},
+		{ID: "far", Vector: []float32{-3, 0}},
+	}
+	ids := func(results []SearchResult) []string {
+		out := make([]string, len(results))
+		for i, r := range results {
+			out[i] = r.ID
+		}
+		return out
+	}
+
+	cosine, err := ns.CreateCollection(CollectionConfig{Name: "cosine", Dimension: 2, Metric: DistanceMetricCosine})
+	require.NoError(t, err)
+	_, err = cosine.InsertBatch(items, BatchOptions{})
+	require.NoError(t, err)
+
+	results, err := cosine.RangeSearch([]float32{1, 0}, 0.01)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x", "near"}, ids(results))
+	assert.InDelta(t, 0, results[0].Distance, 1e-6)
+
+	// Cosine distance to an orthogonal vector is 1
+	results, err = cosine.RangeSearch([]float32{1, 0}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x", "near", "y"}, ids(results))
+
+	euclidean, err := ns.CreateCollection(CollectionConfig{Name: "euclidean", Dimension: 2, Metric: DistanceMetricEuclidean})
+	require.NoError(t, err)
+	_, err = euclidean.InsertBatch(items, BatchOptions{})
+	require.NoError(t, err)
+
+	results, err = euclidean.RangeSearch([]float32{1, 0}, 1.5)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x", "near", "y"}, ids(results))
+	assert.InDelta(t, math.Sqrt2, results[2].Distance, 1e-5)
+
+	dot, err := ns.CreateCollection(CollectionConfig{Name: "dot", Dimension: 2, Metric: DistanceMetricDotProduct})
+	require.NoError(t, err)
+	_, err = dot.InsertBatch(items, BatchOptions{})
+	require.NoError(t, err)
+
+	minScore := float32(0.5)
+	results, err = dot.Search(SearchRequest{QueryVector: []float32{1, 0}, MinScore: &minScore})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"near", "x"}, ids(results))
+
+	// K still caps a threshold search
+	results, err = dot.Search(SearchRequest{QueryVector: []float32{1, 0}, K: 1, MinScore: &minScore})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"near"}, ids(results))
+
+	_, err = cosine.RangeSearch([]float32{1, 0}, -1)
+	assert.Error(t, err)
+	radius := float32(1)
+	_, err = cosine.Search(SearchRequest{QueryText: "anything", MaxDistance: &radius})
+	assert.Error(t, err)
+}
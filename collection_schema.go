@@ -0,0 +1,383 @@
+// Collection metadata schema
+//
+// A collection can declare the metadata fields of its vectors with their
+// types, whether they are required, and defaults. Inserts, upserts and
+// metadata updates that do not match are rejected with a
+// MetadataSchemaError, and so are search filters on fields of the wrong
+// type. Fields marked Indexed get an equality index that filtered vector
+// searches use to score only the matching vectors.
+//
+// Example:
+//
+//	docs, err := ns.CreateCollection(sochdb.CollectionConfig{
+//	    Name:      "articles",
+//	    Dimension: 384,
+//	    Schema: &sochdb.MetadataSchema{
+//	        Fields: map[string]sochdb.FieldSchema{
+//	            "tenant": {Type: sochdb.FieldString, Required: true, Indexed: true},
+//	            "year":   {Type: sochdb.FieldInteger},
+//	            "tags":   {Type: sochdb.FieldStringList},
+//	            "draft":  {Type: sochdb.FieldBool, Default: false},
+//	        },
+//	        Strict: true,
+//	    },
+//	})
+
+package sochdb
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sochdb/sochdb-go/embedded"
+	"github.com/sochdb/sochdb-go/keys"
+)
+
+// FieldType is the type of a metadata field
+type FieldType string
+
+const (
+	FieldString     FieldType = "string"
+	FieldNumber     FieldType = "number"
+	FieldInteger    FieldType = "integer"
+	FieldBool       FieldType = "bool"
+	FieldStringList FieldType = "string_list"
+	FieldObject     FieldType = "object"
+)
+
+// FieldSchema declares one metadata field
+type FieldSchema struct {
+	Type     FieldType   `json:"type"`
+	Required bool        `json:"required,omitempty"`
+	Default  interface{} `json:"default,omitempty"` // Stored when the field is missing
+	Indexed  bool        `json:"indexed,omitempty"` // Index values for filtered search; scalar and list fields only
+}
+
+// MetadataSchema declares the metadata fields of a collection
+type MetadataSchema struct {
+	Fields map[string]FieldSchema `json:"fields"`
+	// Strict rejects fields that are not declared
+	Strict bool `json:"strict,omitempty"`
+}
+
+// MetadataSchemaError is returned when metadata or a search filter does not
+// match the collection's schema
+type MetadataSchemaError struct {
+	Collection string
+	ID         string // Empty for filters and schema definitions
+	Field      string
+	Reason     string
+}
+
+func (e *MetadataSchemaError) Error() string {
+	if e.ID == "" {
+		return fmt.Sprintf("collection %s: field %q %s", e.Collection, e.Field, e.Reason)
+	}
+	return fmt.Sprintf("collection %s: vector %s: field %q %s", e.Collection, e.ID, e.Field, e.Reason)
+}
+
+// validateSchema checks a schema definition when a collection is created
+func validateSchema(collection string, schema *MetadataSchema) error {
+	if schema == nil {
+		return nil
+	}
+
+	for name, field := range schema.Fields {
+		switch field.Type {
+		case FieldString, FieldNumber, FieldInteger, FieldBool, FieldStringList:
+		case FieldObject:
+			if field.Indexed {
+				return &MetadataSchemaError{Collection: collection, Field: name, Reason: "cannot index an object field"}
+			}
+		default:
+			return &MetadataSchemaError{Collection: collection, Field: name, Reason: fmt.Sprintf("has unknown type %q", field.Type)}
+		}
+
+		if field.Default != nil && !field.Type.matches(field.Default) {
+			return &MetadataSchemaError{Collection: collection, Field: name, Reason: fmt.Sprintf("default is not a %s", field.Type)}
+		}
+	}
+	return nil
+}
+
+// conform checks metadata against the collection's schema and returns it
+// with defaults filled in. The input map is not modified.
+func (c *Collection) conform(id string, metadata map[string]interface{}) (map[string]interface{}, error) {
+	schema := c.config.Schema
+	if schema == nil {
+		return metadata, nil
+	}
+
+	if schema.Strict {
+		for name := range metadata {
+			if _, ok := schema.Fields[name]; !ok {
+				return nil, &MetadataSchemaError{Collection: c.name, ID: id, Field: name, Reason: "is not declared in the schema"}
+			}
+		}
+	}
+
+	var conformed map[string]interface{}
+	for _, name := range sortedFieldNames(schema) {
+		field := schema.Fields[name]
+		value, ok := metadata[name]
+		if ok && value != nil {
+			if !field.Type.matches(value) {
+				return nil, &MetadataSchemaError{Collection: c.name, ID: id, Field: name, Reason: fmt.Sprintf("must be a %s, got %T", field.Type, value)}
+			}
+			continue
+		}
+
+		if field.Default != nil {
+			if conformed == nil {
+				conformed = make(map[string]interface{}, len(metadata)+1)
+				for k, v := range metadata {
+					conformed[k] = v
+				}
+			}
+			conformed[name] = field.Default
+		} else if field.Required {
+			return nil, &MetadataSchemaError{Collection: c.name, ID: id, Field: name, Reason: "is required"}
+		}
+	}
+
+	if conformed == nil {
+		return metadata, nil
+	}
+	return conformed, nil
+}
+
+// checkFilter type-checks a normalized search filter against the schema
+func (c *Collection) checkFilter(filter map[string]interface{}) error {
+	schema := c.config.Schema
+	if schema == nil {
+		return nil
+	}
+
+	for name, value := range filter {
+		field, ok := schema.Fields[name]
+		if !ok {
+			if schema.Strict {
+				return &MetadataSchemaError{Collection: c.name, Field: name, Reason: "is not declared in the schema"}
+			}
+			continue
+		}
+		if value != nil && !field.Type.matches(value) {
+			return &MetadataSchemaError{Collection: c.name, Field: name, Reason: fmt.Sprintf("is a %s; cannot filter by %T", field.Type, value)}
+		}
+	}
+	return nil
+}
+
+// matches reports whether a metadata value has type t
+func (t FieldType) matches(value interface{}) bool {
+	switch t {
+	case FieldString:
+		_, ok := value.(string)
+		return ok
+	case FieldBool:
+		_, ok := value.(bool)
+		return ok
+	case FieldNumber, FieldInteger:
+		normalized, err := normalizeIndexValue(value)
+		if _, ok := normalized.(keys.Tuple); err != nil || !ok {
+			return false
+		}
+		return t == FieldNumber || isIntegerIndexValue(normalized)
+	case FieldStringList:
+		switch list := value.(type) {
+		case []string:
+			return true
+		case []interface{}:
+			for _, v := range list {
+				if _, ok := v.(string); !ok {
+					return false
+				}
+			}
+			return true
+		}
+		return false
+	case FieldObject:
+		_, ok := value.(map[string]interface{})
+		return ok
+	}
+	return false
+}
+
+func sortedFieldNames(schema *MetadataSchema) []string {
+	names := make([]string, 0, len(schema.Fields))
+	for name := range schema.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ============================================================================
+// Field Indexes
+// ============================================================================
+
+func (c *Collection) fieldSpace() keys.Subspace {
+	return c.space().Sub("fields")
+}
+
+// hasFieldIndexes reports whether the schema declares indexed fields
+func (c *Collection) hasFieldIndexes() bool {
+	if c.config.Schema == nil {
+		return false
+	}
+	for _, field := range c.config.Schema.Fields {
+		if field.Indexed {
+			return true
+		}
+	}
+	return false
+}
+
+// indexFields moves the field index entries of id from its old metadata to
+// its new metadata within txn. Nil metadata has no entries.
+func (c *Collection) indexFields(txn *embedded.Transaction, id string, old, metadata map[string]interface{}) error {
+	if !c.hasFieldIndexes() {
+		return nil
+	}
+
+	for name, field := range c.config.Schema.Fields {
+		if !field.Indexed {
+			continue
+		}
+		for _, v := range fieldIndexValues(old[name]) {
+			if err := txn.Delete(c.fieldSpace().Pack(name, v, id)); err != nil {
+				return err
+			}
+		}
+		for _, v := range fieldIndexValues(metadata[name]) {
+			if err := txn.Put(c.fieldSpace().Pack(name, v, id), []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fieldIndexValues returns the normalized index values of a field value,
+// one per element for lists
+func fieldIndexValues(value interface{}) []interface{} {
+	var raw []interface{}
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		raw = v
+	case []string:
+		for _, s := range v {
+			raw = append(raw, s)
+		}
+	default:
+		raw = []interface{}{v}
+	}
+
+	values := make([]interface{}, 0, len(raw))
+	for _, v := range raw {
+		if normalized, err := normalizeIndexValue(v); err == nil {
+			values = append(values, normalized)
+		}
+	}
+	return values
+}
+
+// filterCandidates returns the IDs matching the most selective indexed
+// scalar field of filter. It reports false if no field of filter is
+// indexed.
+func (c *Collection) filterCandidates(txn *embedded.Transaction, filter map[string]interface{}) ([]string, bool, error) {
+	if !c.hasFieldIndexes() {
+		return nil, false, nil
+	}
+
+	var best []string
+	found := false
+	for name, want := range filter {
+		field, ok := c.config.Schema.Fields[name]
+		if !ok || !field.Indexed || field.Type == FieldStringList {
+			continue
+		}
+		value, err := normalizeIndexValue(want)
+		if err != nil {
+			continue
+		}
+
+		prefix := c.fieldSpace().Sub(name, value)
+		entries, err := collectKeys(txn, prefix.Bytes())
+		if err != nil {
+			return nil, false, err
+		}
+		if found && len(entries) >= len(best) {
+			continue
+		}
+
+		best, found = best[:0], true
+		for _, key := range entries {
+			tuple, err := prefix.Unpack(key)
+			if err != nil || len(tuple) != 1 {
+				continue
+			}
+			if id, ok := tuple[0].(string); ok {
+				best = append(best, id)
+			}
+		}
+	}
+	return best, found, nil
+}
+
+// candidateSearch scores only the given vectors
+func (c *Collection) candidateSearch(txn *embedded.Transaction, request SearchRequest, ids []string, k int, floor float32, filter map[string]interface{}) ([]SearchResult, error) {
+	metric := c.searchMetric(request)
+	queries := request.QueryVectors
+	if len(request.QueryVector) > 0 {
+		queries = append([][]float32{request.QueryVector}, queries...)
+	}
+
+	top := &resultHeap{}
+	for _, id := range ids {
+		data, err := c.readVector(txn, id)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil || !matchesFilter(data.Metadata, filter) {
+			continue
+		}
+
+		vectors := [][]float32{data.Vector}
+		if request.VectorName != "" {
+			value, err := txn.Get(c.namedKey(request.VectorName, id))
+			if err != nil {
+				return nil, err
+			}
+			if value == nil {
+				continue
+			}
+			if c.config.Vectors[request.VectorName].MultiVector {
+				vectors, err = decodeMultiVector(value)
+			} else {
+				var vector []float32
+				vector, _, err = decodeVector(value)
+				vectors = [][]float32{vector}
+			}
+			if err != nil {
+				return nil, err
+			}
+		} else if len(data.Vector) != len(request.QueryVector) {
+			continue
+		}
+
+		s := maxSim(metric, queries, vectors)
+		if s < floor {
+			continue
+		}
+		result := SearchResult{ID: id, Score: s}
+		if len(queries) == 1 {
+			result.Distance = distanceFromScore(metric, s)
+		}
+		top.offer(result, k)
+	}
+
+	return top.sorted(), nil
+}
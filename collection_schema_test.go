@@ -0,0 +1,125 @@
+package sochdb
+
+import (
+	"testing"
+
+	"github.com/sochdb/sochdb-go/embedded"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCollectionSchema tests typed metadata validation and field indexes
+func TestCollectionSchema(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+
+	_, err = ns.CreateCollection(CollectionConfig{Name: "bad", Schema: &MetadataSchema{
+		Fields: map[string]FieldSchema{"year": {Type: FieldInteger, Default: "soon"}},
+	}})
+	var schemaErr *MetadataSchemaError
+	assert.ErrorAs(t, err, &schemaErr)
+
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 2, Schema: &MetadataSchema{
+		Fields: map[string]FieldSchema{
+			"tenant": {Type: FieldString, Required: true, Indexed: true},
+			"year":   {Type: FieldInteger, Indexed: true},
+			"tags":   {Type: FieldStringList},
+			"draft":  {Type: FieldBool, Default: false},
+		},
+		Strict: true,
+	}})
+	require.NoError(t, err)
+
+	_, err = docs.Insert([]float32{1, 0}, map[string]interface{}{"tenant": "acme", "year": 2024, "tags": []string{"go"}}, "a")
+	require.NoError(t, err)
+	data, err := docs.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, false, data.Metadata["draft"])
+
+	_, err = docs.Insert([]float32{1, 0}, map[string]interface{}{"year": 2024}, "b")
+	require.ErrorAs(t, err, &schemaErr)
+	assert.Equal(t, "tenant", schemaErr.Field)
+	assert.Equal(t, "b", schemaErr.ID)
+
+	_, err = docs.Upsert([]float32{1, 0}, map[string]interface{}{"tenant": "acme", "year": 2024.5}, "b")
+	assert.ErrorAs(t, err, &schemaErr)
+	_, err = docs.Upsert([]float32{1, 0}, map[string]interface{}{"tenant": "acme", "color": "red"}, "b")
+	assert.ErrorAs(t, err, &schemaErr)
+	assert.ErrorAs(t, docs.UpdateMetadata("a", map[string]interface{}{"tenant": nil}), &schemaErr)
+
+	// One bad item fails an atomic batch without changing the caller's items
+	items := []InsertItem{
+		{ID: "c", Vector: []float32{0, 1}, Metadata: map[string]interface{}{"tenant": "globex", "year": 2023}},
+		{ID: "d", Vector: []float32{0.5, 0.5}, Metadata: map[string]interface{}{"tenant": "acme"}},
+		{ID: "e", Vector: []float32{1, 1}, Metadata: map[string]interface{}{"tenant": 7}},
+	}
+	_, err = docs.InsertBatch(items, BatchOptions{})
+	var itemErr *BatchItemError
+	require.ErrorAs(t, err, &itemErr)
+	assert.Equal(t, 2, itemErr.Index)
+	assert.ErrorAs(t, err, &schemaErr)
+	assert.NotContains(t, items[0].Metadata, "draft")
+
+	_, err = docs.InsertBatch(items[:2], BatchOptions{})
+	require.NoError(t, err)
+
+	// Filters are type-checked and use the field indexes
+	_, err = docs.Search(SearchRequest{QueryVector: []float32{1, 0}, Filter: map[string]interface{}{"year": "2024"}})
+	assert.ErrorAs(t, err, &schemaErr)
+	_, err = docs.Search(SearchRequest{QueryVector: []float32{1, 0}, Filter: map[string]interface{}{"colour": "red"}})
+	assert.ErrorAs(t, err, &schemaErr)
+
+	results, err := docs.Search(SearchRequest{QueryVector: []float32{1, 0}, Filter: map[string]interface{}{"tenant": "acme"}})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "a", results[0].ID)
+	assert.Equal(t, "d", results[1].ID)
+
+	require.NoError(t, docs.UpdateMetadata("d", map[string]interface{}{"tenant": "globex"}))
+	results, err = docs.Search(SearchRequest{QueryVector: []float32{1, 0}, Filter: map[string]interface{}{"tenant": "globex", "draft": false}})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "d", results[0].ID)
+
+	require.NoError(t, docs.Delete("c"))
+	results, err = docs.Search(SearchRequest{QueryVector: []float32{1, 0}, Filter: map[string]interface{}{"year": 2023}})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+	results, err = docs.Search(SearchRequest{QueryVector: []float32{1, 0}, Filter: map[string]interface{}{"year": 2024}})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "a", results[0].ID)
+}
+
+// TestCollectionSchemaLargeIntegers tests field indexes on integers above 2^53
+func TestCollectionSchemaLargeIntegers(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 2, Schema: &MetadataSchema{
+		Fields: map[string]FieldSchema{"account": {Type: FieldInteger, Indexed: true}},
+	}})
+	require.NoError(t, err)
+
+	_, err = docs.Insert([]float32{1, 0}, map[string]interface{}{"account": int64(1<<60 + 1)}, "a")
+	require.NoError(t, err)
+	_, err = docs.Insert([]float32{1, 0}, map[string]interface{}{"account": int64(1<<60 + 2)}, "b")
+	require.NoError(t, err)
+
+	results, err := docs.Search(SearchRequest{QueryVector: []float32{1, 0}, Filter: map[string]interface{}{"account": int64(1<<60 + 2)}})
+	require.NoError(t, err)
+	require.NotEmpty(t, results)
+	assert.Equal(t, "b", results[0].ID)
+
+	// Moving a value removes its old index entry
+	require.NoError(t, docs.UpdateMetadata("b", map[string]interface{}{"account": 5}))
+	var stale int
+	require.NoError(t, db.WithTransaction(func(txn *embedded.Transaction) error {
+		ids, _, err := docs.filterCandidates(txn, map[string]interface{}{"account": int64(1<<60 + 2)})
+		stale = len(ids)
+		return err
+	}))
+	assert.Zero(t, stale)
+}
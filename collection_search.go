@@ -0,0 +1,347 @@
+// Collection search
+//
+// Vector search scores vectors with the collection's metric, where a higher
+// score is always better: cosine similarity, dot product, or negated
+// euclidean distance. Collections with a trained quantizer scan compact codes
+// first and re-score the best candidates at full precision. Keyword and
+// hybrid search are in collection_text.go.
+
+package sochdb
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	"github.com/sochdb/sochdb-go/embedded"
+)
+
+// Search finds the K best matches for a query vector, a keyword query over
+// the collection's TextField, or both combined
+func (c *Collection) Search(request SearchRequest) (_ []SearchResult, err error) {
+	access, err := c.authorize(GrantOperationRead)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { access.done(err) }()
+	hasVector := len(request.QueryVector) > 0 || len(request.QueryVectors) > 0
+	if !hasVector && request.QueryText == "" {
+		return nil, errors.New("search requires a query vector or query text")
+	}
+	if request.VectorName != "" {
+		if err := c.validateNamedQuery(request); err != nil {
+			return nil, err
+		}
+	} else if len(request.QueryVectors) > 0 {
+		return nil, errors.New("multiple query vectors require a multi-vector VectorName")
+	} else if hasVector && c.config.Dimension > 0 && len(request.QueryVector) != c.config.Dimension {
+		return nil, fmt.Errorf("query dimension mismatch: expected %d, got %d", c.config.Dimension, len(request.QueryVector))
+	}
+	if request.QueryText != "" && c.config.TextField == "" {
+		return nil, fmt.Errorf("collection %s has no text field for keyword search", c.name)
+	}
+	if err := validateThresholds(request); err != nil {
+		return nil, err
+	}
+
+	// Without K, a search with a threshold returns every match
+	k := request.K
+	if k <= 0 {
+		k = 10
+		if request.MinScore != nil || request.MaxDistance != nil {
+			k = unboundedK
+		}
+	}
+
+	fetch := k
+	diversified := request.MMR || request.CollapseField != ""
+	if diversified {
+		fetch = request.FetchK
+		if fetch < k {
+			fetch = saturatingMul(k, diversityFetchFactor)
+		}
+	}
+	floor := c.vectorFloor(request)
+
+	filter, err := normalizeFilter(request.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkFilter(filter); err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	var textDeltas [][]byte
+	err = withTxn(c.db, func(txn *embedded.Transaction) error {
+		var stats *textStats
+		var err error
+		if request.QueryText != "" {
+			if stats, textDeltas, err = c.loadTextStats(txn); err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case request.QueryText == "":
+			results, err = c.vectorSearch(txn, request, fetch, floor, filter)
+		case !hasVector:
+			results, err = c.keywordSearch(txn, stats, request.QueryText, fetch, filter)
+		default:
+			results, err = c.hybridSearch(txn, stats, request, fetch, floor, filter)
+		}
+		if err == nil && request.MinScore != nil {
+			results = aboveScore(results, *request.MinScore)
+		}
+		if err == nil && diversified {
+			results, err = c.diversify(txn, request, results, k)
+		}
+		if err != nil || !request.IncludeMetadata {
+			return err
+		}
+
+		for i := range results {
+			data, err := c.readVector(txn, results[i].ID)
+			if err != nil {
+				return err
+			}
+			if data != nil {
+				results[i].Metadata = data.Metadata
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(textDeltas) >= countCompactThreshold {
+		err = retryConflicts(c.db, c.compactTextStats)
+	}
+	return results, err
+}
+
+// vectorSearch ranks vectors by similarity to the request's query vectors,
+// skipping those scoring below floor
+func (c *Collection) vectorSearch(txn *embedded.Transaction, request SearchRequest, k int, floor float32, filter map[string]interface{}) ([]SearchResult, error) {
+	if filter != nil {
+		// Index keys are exact, so look them up with the caller's values;
+		// JSON normalization rounds integers above 2^53
+		ids, ok, err := c.filterCandidates(txn, request.Filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return c.candidateSearch(txn, request, ids, k, floor, filter)
+		}
+	}
+	if request.VectorName != "" {
+		return c.namedSearch(txn, request, k, floor, filter)
+	}
+	// The graph cannot guarantee K filtered results or complete ranges
+	if filter == nil && k != unboundedK {
+		results, ok, err := c.indexSearch(txn, request.QueryVector, k, floor)
+		if err != nil || ok {
+			return results, err
+		}
+	}
+	if c.config.Codebook != nil {
+		return c.quantizedSearch(txn, request.QueryVector, k, floor, filter)
+	}
+	return c.exactSearch(txn, request.QueryVector, k, floor, filter)
+}
+
+// exactSearch scores every vector at full precision
+func (c *Collection) exactSearch(txn *embedded.Transaction, query []float32, k int, floor float32, filter map[string]interface{}) ([]SearchResult, error) {
+	top := &resultHeap{}
+
+	err := c.scanVectors(txn, func(id string, vector []float32, metadata func() (map[string]interface{}, error)) error {
+		if len(vector) != len(query) {
+			return nil
+		}
+		s := score(c.config.Metric, query, vector)
+		if s < floor {
+			return nil
+		}
+		if filter != nil {
+			m, err := metadata()
+			if err != nil {
+				return err
+			}
+			if !matchesFilter(m, filter) {
+				return nil
+			}
+		}
+
+		top.offer(SearchResult{ID: id, Score: s, Distance: distanceFromScore(c.config.Metric, s)}, k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return top.sorted(), nil
+}
+
+// scanVectors calls fn for every vector in the collection. Metadata is only
+// loaded when fn asks for it.
+func (c *Collection) scanVectors(txn *embedded.Transaction, fn func(id string, vector []float32, metadata func() (map[string]interface{}, error)) error) error {
+	vectors := c.space().Sub("vectors")
+
+	iter := txn.ScanPrefix(vectors.Bytes())
+	defer iter.Close()
+
+	for {
+		key, value, ok := iter.Next()
+		if !ok {
+			break
+		}
+
+		tuple, err := vectors.Unpack(key)
+		if err != nil || len(tuple) != 1 {
+			continue
+		}
+		id, ok := tuple[0].(string)
+		if !ok {
+			continue
+		}
+
+		var vector []float32
+		var metadata func() (map[string]interface{}, error)
+
+		if isLegacyVector(value) {
+			var data vectorData
+			if err := json.Unmarshal(value, &data); err != nil {
+				return err
+			}
+			vector = data.Vector
+			metadata = func() (map[string]interface{}, error) { return data.Metadata, nil }
+		} else {
+			if vector, _, err = decodeVector(value); err != nil {
+				return err
+			}
+			metadata = func() (map[string]interface{}, error) { return c.readMetadata(txn, id) }
+		}
+
+		if err := fn(id, vector, metadata); err != nil {
+			return err
+		}
+	}
+
+	return iter.Err()
+}
+
+// readMetadata loads the metadata of a binary-format vector
+func (c *Collection) readMetadata(txn *embedded.Transaction, id string) (map[string]interface{}, error) {
+	data, err := txn.Get(c.vectorMetaKey(id))
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var stored vectorMeta
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	return stored.Metadata, nil
+}
+
+// score returns the similarity of two vectors; higher is more similar
+func score(metric DistanceMetric, a, b []float32) float32 {
+	var dot, normA, normB float64
+	for i := range a {
+		x, y := float64(a[i]), float64(b[i])
+		dot += x * y
+		normA += x * x
+		normB += y * y
+	}
+	return scoreFromProducts(metric, dot, normA, normB)
+}
+
+// scoreFromProducts computes a score from a dot product and squared norms
+func scoreFromProducts(metric DistanceMetric, dot, normA, normB float64) float32 {
+	switch metric {
+	case DistanceMetricDotProduct:
+		return float32(dot)
+	case DistanceMetricEuclidean:
+		return float32(-math.Sqrt(math.Max(0, normA-2*dot+normB)))
+	default:
+		if normA == 0 || normB == 0 {
+			return 0
+		}
+		return float32(dot / math.Sqrt(normA*normB))
+	}
+}
+
+// normalizeFilter round-trips a filter through JSON so its values compare
+// equal to decoded metadata (e.g. int 3 and float64 3)
+func normalizeFilter(filter map[string]interface{}) (map[string]interface{}, error) {
+	if len(filter) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// matchesFilter reports whether metadata has every field of filter
+func matchesFilter(metadata, filter map[string]interface{}) bool {
+	for field, want := range filter {
+		got, ok := metadata[field]
+		if !ok || !reflect.DeepEqual(got, want) {
+			return false
+		}
+	}
+	return true
+}
+
+// ============================================================================
+// Top-K Selection
+// ============================================================================
+
+// resultHeap is a min-heap on score holding the best results seen so far
+type resultHeap []SearchResult
+
+func (h resultHeap) Len() int            { return len(h) }
+func (h resultHeap) Less(i, j int) bool  { return h[i].Score < h[j].Score }
+func (h resultHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *resultHeap) Push(x interface{}) { *h = append(*h, x.(SearchResult)) }
+func (h *resultHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// offer keeps result if it is among the best k
+func (h *resultHeap) offer(result SearchResult, k int) {
+	if h.Len() < k {
+		heap.Push(h, result)
+	} else if result.Score > (*h)[0].Score {
+		(*h)[0] = result
+		heap.Fix(h, 0)
+	}
+}
+
+// sorted returns the results best first
+func (h *resultHeap) sorted() []SearchResult {
+	results := append([]SearchResult{}, *h...)
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	return results
+}
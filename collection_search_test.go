@@ -0,0 +1,47 @@
+package sochdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCollectionSearch tests exact search with metrics and filters
+func TestCollectionSearch(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 2, Metric: DistanceMetricEuclidean})
+	require.NoError(t, err)
+	_, err = docs.Insert([]float32{0, 0}, map[string]interface{}{"lang": "en", "year": 2020}, "origin")
+	require.NoError(t, err)
+	_, err = docs.Insert([]float32{3, 4}, map[string]interface{}{"lang": "de", "year": 2021}, "far")
+	require.NoError(t, err)
+	_, err = docs.Insert([]float32{1, 0}, map[string]interface{}{"lang": "en", "year": 2021}, "near")
+	require.NoError(t, err)
+
+	results, err := docs.Search(SearchRequest{QueryVector: []float32{0, 0}, K: 2})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "origin", results[0].ID)
+	assert.Equal(t, "near", results[1].ID)
+	assert.Equal(t, float32(-1), results[1].Score)
+	assert.Nil(t, results[0].Metadata)
+
+	results, err = docs.Search(SearchRequest{
+		QueryVector:     []float32{0, 0},
+		K:               5,
+		Filter:          map[string]interface{}{"year": 2021},
+		IncludeMetadata: true,
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "near", results[0].ID)
+	assert.Equal(t, "en", results[0].Metadata["lang"])
+	assert.Equal(t, float32(-5), results[1].Score)
+
+	_, err = docs.Search(SearchRequest{QueryVector: []float32{1}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,372 @@
+// Collection keyword and hybrid search
+//
+// A collection with a TextField keeps a persistent inverted index over that
+// metadata field, updated in the same transaction as each write. Searches
+// with QueryText rank by BM25; searches with both QueryText and QueryVector
+// fuse the keyword and vector rankings.
+//
+// Example:
+//
+//	docs, err := ns.CreateCollection(sochdb.CollectionConfig{
+//	    Name:      "articles",
+//	    Dimension: 384,
+//	    TextField: "content",
+//	})
+//
+//	alpha := float32(0.7) // favor the vector ranking
+//	results, err := docs.Search(sochdb.SearchRequest{
+//	    QueryVector: embedding,
+//	    QueryText:   "vector database tutorial",
+//	    Alpha:       &alpha,
+//	    Fusion:      sochdb.FusionLinear,
+//	    K:           10,
+//	})
+
+package sochdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/sochdb/sochdb-go/embedded"
+	"github.com/sochdb/sochdb-go/keys"
+)
+
+// FusionMode selects how hybrid search combines rankings
+type FusionMode string
+
+const (
+	FusionRRF    FusionMode = "rrf"    // Reciprocal Rank Fusion (default)
+	FusionLinear FusionMode = "linear" // Weighted sum of min-max normalized scores
+)
+
+// BM25 parameters, matching BM25Scorer's defaults
+const (
+	collectionBM25K1 = 1.5
+	collectionBM25B  = 0.75
+
+	defaultRRFConstant     = 60
+	defaultHybridAlpha     = 0.5
+	hybridCandidateFactor  = 4
+	minHybridCandidateSize = 50
+)
+
+// textDoc records what the inverted index holds for one vector
+type textDoc struct {
+	Length int            `json:"length"`
+	Terms  map[string]int `json:"terms"`
+}
+
+// textStats tracks corpus totals for BM25
+type textStats struct {
+	Docs        int64 `json:"docs"`
+	TotalLength int64 `json:"total_length"`
+}
+
+func (c *Collection) textSpace() keys.Subspace {
+	return c.space().Sub("text")
+}
+
+// indexText replaces the indexed text of a vector within txn. A nil
+// metadata removes the vector from the index.
+func (c *Collection) indexText(txn *embedded.Transaction, id string, metadata map[string]interface{}) error {
+	if c.config.TextField == "" {
+		return nil
+	}
+
+	space := c.textSpace()
+	docKey := space.Pack("docs", id)
+	var delta textStats
+
+	old, err := txn.Get(docKey)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		var doc textDoc
+		if err := json.Unmarshal(old, &doc); err != nil {
+			return err
+		}
+		for term := range doc.Terms {
+			if err := txn.Delete(space.Pack("postings", term, id)); err != nil {
+				return err
+			}
+		}
+		if err := txn.Delete(docKey); err != nil {
+			return err
+		}
+		delta.Docs--
+		delta.TotalLength -= int64(doc.Length)
+	}
+
+	text, _ := metadata[c.config.TextField].(string)
+	terms := tokenize(text)
+	if len(terms) > 0 {
+		doc := textDoc{Length: len(terms), Terms: make(map[string]int)}
+		for _, term := range terms {
+			doc.Terms[term]++
+		}
+
+		for term, tf := range doc.Terms {
+			posting := binary.AppendUvarint(nil, uint64(tf))
+			posting = binary.AppendUvarint(posting, uint64(doc.Length))
+			if err := txn.Put(space.Pack("postings", term, id), posting); err != nil {
+				return err
+			}
+		}
+
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		if err := txn.Put(docKey, data); err != nil {
+			return err
+		}
+		delta.Docs++
+		delta.TotalLength += int64(doc.Length)
+	}
+
+	return c.addTextStats(txn, delta)
+}
+
+// The corpus totals are kept like the vector count: a base record plus one
+// delta record per transaction that changed them, so concurrent writers do
+// not rewrite a shared key. Searches fold the deltas back into the base once
+// enough have accumulated.
+
+// loadTextStats sums the corpus total records, returning the keys of the
+// delta records alongside the totals
+func (c *Collection) loadTextStats(txn *embedded.Transaction) (*textStats, [][]byte, error) {
+	stats := &textStats{}
+	var deltas [][]byte
+
+	base := c.textSpace().Pack("stats")
+	iter := txn.ScanPrefix(base)
+	defer iter.Close()
+
+	for {
+		key, value, ok := iter.Next()
+		if !ok {
+			break
+		}
+
+		var record textStats
+		if err := json.Unmarshal(value, &record); err != nil {
+			return nil, nil, err
+		}
+		stats.Docs += record.Docs
+		stats.TotalLength += record.TotalLength
+		if !bytes.Equal(key, base) {
+			deltas = append(deltas, key)
+		}
+	}
+
+	return stats, deltas, iter.Err()
+}
+
+// addTextStats adds delta to the transaction's own corpus total record
+func (c *Collection) addTextStats(txn *embedded.Transaction, delta textStats) error {
+	if delta == (textStats{}) {
+		return nil
+	}
+	key := c.textSpace().Pack("stats", txn.ID())
+
+	var stats textStats
+	data, err := txn.Get(key)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		if err := json.Unmarshal(data, &stats); err != nil {
+			return err
+		}
+	}
+
+	stats.Docs += delta.Docs
+	stats.TotalLength += delta.TotalLength
+	if data, err = json.Marshal(stats); err != nil {
+		return err
+	}
+	return txn.Put(key, data)
+}
+
+// compactTextStats replaces the corpus total records with a single base
+// record
+func (c *Collection) compactTextStats(txn *embedded.Transaction) error {
+	stats, deltas, err := c.loadTextStats(txn)
+	if err != nil {
+		return err
+	}
+	for _, key := range deltas {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return txn.Put(c.textSpace().Pack("stats"), data)
+}
+
+// keywordSearch ranks vectors by the BM25 score of their text field, given
+// the corpus totals
+func (c *Collection) keywordSearch(txn *embedded.Transaction, stats *textStats, query string, k int, filter map[string]interface{}) ([]SearchResult, error) {
+	if stats.Docs == 0 {
+		return []SearchResult{}, nil
+	}
+	avgLength := float64(stats.TotalLength) / float64(stats.Docs)
+
+	scores := make(map[string]float64)
+	seen := make(map[string]bool)
+	for _, term := range tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		postings := c.textSpace().Sub("postings", term)
+		type posting struct {
+			id         string
+			tf, length uint64
+		}
+		var matches []posting
+
+		iter := txn.ScanPrefix(postings.Bytes())
+		for {
+			key, value, ok := iter.Next()
+			if !ok {
+				break
+			}
+			tuple, err := postings.Unpack(key)
+			if err != nil || len(tuple) != 1 {
+				continue
+			}
+			id, _ := tuple[0].(string)
+			tf, n := binary.Uvarint(value)
+			if n <= 0 {
+				continue
+			}
+			length, m := binary.Uvarint(value[n:])
+			if m <= 0 {
+				continue
+			}
+			matches = append(matches, posting{id: id, tf: tf, length: length})
+		}
+		iter.Close()
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+
+		df := float64(len(matches))
+		idf := math.Log((float64(stats.Docs)-df+0.5)/(df+0.5) + 1.0)
+		for _, p := range matches {
+			tf := float64(p.tf)
+			denominator := tf + collectionBM25K1*(1-collectionBM25B+collectionBM25B*float64(p.length)/avgLength)
+			scores[p.id] += idf * tf * (collectionBM25K1 + 1) / denominator
+		}
+	}
+
+	top := &resultHeap{}
+	for id, s := range scores {
+		if filter != nil {
+			metadata, err := c.readMetadata(txn, id)
+			if err != nil {
+				return nil, err
+			}
+			if !matchesFilter(metadata, filter) {
+				continue
+			}
+		}
+		top.offer(SearchResult{ID: id, Score: float32(s)}, k)
+	}
+
+	return top.sorted(), nil
+}
+
+// hybridSearch fuses the vector and keyword rankings of a request
+func (c *Collection) hybridSearch(txn *embedded.Transaction, stats *textStats, request SearchRequest, k int, floor float32, filter map[string]interface{}) ([]SearchResult, error) {
+	fetch := saturatingMul(k, hybridCandidateFactor)
+	if fetch < minHybridCandidateSize {
+		fetch = minHybridCandidateSize
+	}
+
+	semantic, err := c.vectorSearch(txn, request, fetch, floor, filter)
+	if err != nil {
+		return nil, err
+	}
+	lexical, err := c.keywordSearch(txn, stats, request.QueryText, fetch, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	alpha := defaultHybridAlpha
+	if request.Alpha != nil {
+		alpha = float64(*request.Alpha)
+		if !(alpha >= 0 && alpha <= 1) {
+			return nil, fmt.Errorf("alpha must be in [0, 1], got %g", alpha)
+		}
+	}
+
+	fused := make(map[string]float64)
+	switch request.Fusion {
+	case "", FusionRRF:
+		constant := request.RRFConstant
+		if constant <= 0 {
+			constant = defaultRRFConstant
+		}
+		for rank, r := range semantic {
+			fused[r.ID] += alpha / float64(constant+rank+1)
+		}
+		for rank, r := range lexical {
+			fused[r.ID] += (1 - alpha) / float64(constant+rank+1)
+		}
+	case FusionLinear:
+		for id, s := range normalizeScores(semantic) {
+			fused[id] += alpha * s
+		}
+		for id, s := range normalizeScores(lexical) {
+			fused[id] += (1 - alpha) * s
+		}
+	default:
+		return nil, errors.New("unknown fusion mode: " + string(request.Fusion))
+	}
+
+	top := &resultHeap{}
+	for id, s := range fused {
+		top.offer(SearchResult{ID: id, Score: float32(s)}, k)
+	}
+	return top.sorted(), nil
+}
+
+// normalizeScores min-max scales scores to [0, 1]
+func normalizeScores(results []SearchResult) map[string]float64 {
+	normalized := make(map[string]float64, len(results))
+	if len(results) == 0 {
+		return normalized
+	}
+
+	lo, hi := results[0].Score, results[0].Score
+	for _, r := range results {
+		if r.Score < lo {
+			lo = r.Score
+		}
+		if r.Score > hi {
+			hi = r.Score
+		}
+	}
+
+	for _, r := range results {
+		if hi == lo {
+			normalized[r.ID] = 1
+		} else {
+			normalized[r.ID] = float64((r.Score - lo) / (hi - lo))
+		}
+	}
+	return normalized
+}
@@ -0,0 +1,125 @@
+package sochdb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/sochdb/sochdb-go/embedded"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCollectionHybridSearch tests keyword and hybrid collection search
+func TestCollectionHybridSearch(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 2, TextField: "content"})
+	require.NoError(t, err)
+
+	items := []InsertItem{
+		{ID: "go", Vector: []float32{1, 0}, Metadata: map[string]interface{}{"content": "Go database tutorial", "lang": "en"}},
+		{ID: "rust", Vector: []float32{0, 1}, Metadata: map[string]interface{}{"content": "Rust vector database internals", "lang": "en"}},
+		{ID: "cook", Vector: []float32{0.9, 0.1}, Metadata: map[string]interface{}{"content": "Cooking with vegetables", "lang": "de"}},
+		{ID: "none", Vector: []float32{1, 1}},
+	}
+	_, err = docs.InsertBatch(items, BatchOptions{})
+	require.NoError(t, err)
+
+	results, err := docs.Search(SearchRequest{QueryText: "vector database", K: 5})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "rust", results[0].ID)
+	assert.Equal(t, "go", results[1].ID)
+
+	// The index follows metadata updates and deletes
+	require.NoError(t, docs.UpdateMetadata("cook", map[string]interface{}{"content": "vector cooking"}))
+	require.NoError(t, docs.Delete("rust"))
+	results, err = docs.Search(SearchRequest{QueryText: "vector", K: 5})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "cook", results[0].ID)
+
+	// The vector ranking prefers "go"; the keyword ranking only has "cook"
+	for _, fusion := range []FusionMode{FusionRRF, FusionLinear} {
+		alpha := float32(0.2)
+		results, err = docs.Search(SearchRequest{
+			QueryVector: []float32{1, 0},
+			QueryText:   "cooking",
+			Alpha:       &alpha,
+			Fusion:      fusion,
+			K:           2,
+		})
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		assert.Equal(t, "cook", results[0].ID, fusion)
+
+		// The endpoints use one ranking alone
+		for alpha, want := range map[float32]string{0: "cook", 1: "go"} {
+			results, err = docs.Search(SearchRequest{
+				QueryVector: []float32{1, 0},
+				QueryText:   "cooking",
+				Alpha:       &alpha,
+				Fusion:      fusion,
+				K:           1,
+			})
+			require.NoError(t, err)
+			require.Len(t, results, 1)
+			assert.Equal(t, want, results[0].ID, "%s alpha %g", fusion, alpha)
+		}
+	}
+
+	outOfRange := float32(1.5)
+	_, err = docs.Search(SearchRequest{QueryVector: []float32{1, 0}, QueryText: "cooking", Alpha: &outOfRange})
+	assert.Error(t, err)
+
+	results, err = docs.Search(SearchRequest{QueryText: "database vector cooking", Filter: map[string]interface{}{"lang": "en"}})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "go", results[0].ID)
+
+	plain, err := ns.CreateCollection(CollectionConfig{Name: "plain"})
+	require.NoError(t, err)
+	_, err = plain.Search(SearchRequest{QueryText: "anything"})
+	assert.Error(t, err)
+}
+
+// TestCollectionTextStats tests the corpus totals under concurrent writers
+// and their compaction
+func TestCollectionTextStats(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 2, TextField: "content"})
+	require.NoError(t, err)
+
+	const writers, perWriter = 4, countCompactThreshold / 2
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				metadata := map[string]interface{}{"content": "vector database"}
+				_, err := docs.Upsert([]float32{1, 0}, metadata, fmt.Sprintf("%d-%d", w, i))
+				assert.NoError(t, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+	require.NoError(t, docs.UpdateMetadata("0-0", map[string]interface{}{"content": "vector"}))
+
+	results, err := docs.Search(SearchRequest{QueryText: "database", K: 1})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	require.NoError(t, db.WithTransaction(func(txn *embedded.Transaction) error {
+		stats, deltas, err := docs.loadTextStats(txn)
+		require.NoError(t, err)
+		assert.Equal(t, textStats{Docs: writers * perWriter, TotalLength: 2*writers*perWriter - 1}, *stats)
+		assert.Empty(t, deltas)
+		return nil
+	}))
+}
@@ -0,0 +1,488 @@
+// Collection import and export
+//
+// Collections stream to and from two formats. JSONL holds one object per
+// vector with its ID, vector, metadata and any named vectors. NumPy holds
+// the default vectors as a float32 .npy matrix, with IDs and metadata in a
+// JSONL sidecar, one line per row. Imports also accept the field names of
+// Pinecone ("values") and Weaviate ("properties") dumps.
+//
+// Imports commit in batches and return the number of records committed, so
+// a failed import can resume from where it stopped.
+//
+// Example:
+//
+//	out, _ := os.Create("docs.jsonl")
+//	n, err := docs.Export(out, sochdb.TransferJSONL, sochdb.ExportOptions{})
+//
+//	in, _ := os.Open("docs.jsonl")
+//	n, err = staging.Import(in, sochdb.TransferJSONL, sochdb.ImportOptions{
+//	    MapID: func(id string) string { return "prod-" + id },
+//	})
+//	if err != nil {
+//	    // Retry later with ImportOptions{Resume: n}
+//	}
+
+package sochdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+
+	"github.com/sochdb/sochdb-go/embedded"
+)
+
+// TransferFormat selects the file format of an import or export
+type TransferFormat string
+
+const (
+	TransferJSONL TransferFormat = "jsonl" // One JSON object per vector
+	TransferNPY   TransferFormat = "npy"   // NumPy matrix plus a JSONL sidecar of IDs and metadata
+)
+
+const defaultImportBatchSize = 1000
+
+// ExportOptions configures Collection.Export
+type ExportOptions struct {
+	// Sidecar receives the IDs and metadata of an NPY export, one JSON
+	// line per row
+	Sidecar io.Writer
+}
+
+// ImportOptions configures Collection.Import
+type ImportOptions struct {
+	// Sidecar holds the IDs and metadata of an NPY import, one JSON line
+	// per row. Without it, rows get generated IDs.
+	Sidecar io.Reader
+	// MapID rewrites source IDs; an empty result generates a new ID
+	MapID func(id string) string
+	// Upsert replaces existing vectors instead of failing
+	Upsert bool
+	// Resume skips this many records, as returned by a failed import
+	Resume int
+	// BatchSize is the number of records per transaction; default 1000
+	BatchSize int
+}
+
+// transferRecord is one line of a JSONL export or NPY sidecar
+type transferRecord struct {
+	ID           string                 `json:"id"`
+	Vector       []float32              `json:"vector,omitempty"`
+	Metadata     map[string]interface{} `json:"metadata,omitempty"`
+	Vectors      map[string][]float32   `json:"vectors,omitempty"`
+	MultiVectors map[string][][]float32 `json:"multi_vectors,omitempty"`
+
+	// Aliases used by other vector databases' dumps
+	Values     []float32              `json:"values,omitempty"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// Export writes every vector of the collection to w from one consistent
+// snapshot and returns the number written. NPY exports include only
+// default vectors of the collection's dimension.
+func (c *Collection) Export(w io.Writer, format TransferFormat, opts ExportOptions) (_ int, err error) {
+	access, err := c.authorize(GrantOperationRead)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { access.done(err) }()
+
+	switch format {
+	case TransferJSONL:
+	case TransferNPY:
+		if c.config.Dimension <= 0 {
+			return 0, fmt.Errorf("collection %s needs a dimension for NPY export", c.name)
+		}
+	default:
+		return 0, fmt.Errorf("unknown transfer format: %s", format)
+	}
+
+	var written int
+	err = withTxn(c.db, func(txn *embedded.Transaction) error {
+		written = 0
+		if format == TransferNPY {
+			return c.exportNPY(txn, w, opts.Sidecar, &written)
+		}
+		return c.exportJSONL(txn, w, &written)
+	})
+	return written, err
+}
+
+func (c *Collection) exportJSONL(txn *embedded.Transaction, w io.Writer, written *int) error {
+	out := bufio.NewWriter(w)
+	encoder := json.NewEncoder(out)
+
+	err := c.scanVectors(txn, func(id string, vector []float32, metadata func() (map[string]interface{}, error)) error {
+		record := transferRecord{ID: id, Vector: vector}
+
+		var err error
+		if record.Metadata, err = metadata(); err != nil {
+			return err
+		}
+		if err := c.readTransferNamed(txn, &record); err != nil {
+			return err
+		}
+
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+		*written++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// readTransferNamed loads the named vectors of a record
+func (c *Collection) readTransferNamed(txn *embedded.Transaction, record *transferRecord) error {
+	for name, config := range c.config.Vectors {
+		value, err := txn.Get(c.namedKey(name, record.ID))
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			continue
+		}
+
+		if config.MultiVector {
+			vectors, err := decodeMultiVector(value)
+			if err != nil {
+				return err
+			}
+			if record.MultiVectors == nil {
+				record.MultiVectors = make(map[string][][]float32)
+			}
+			record.MultiVectors[name] = vectors
+		} else {
+			vector, _, err := decodeVector(value)
+			if err != nil {
+				return err
+			}
+			if record.Vectors == nil {
+				record.Vectors = make(map[string][]float32)
+			}
+			record.Vectors[name] = vector
+		}
+	}
+	return nil
+}
+
+func (c *Collection) exportNPY(txn *embedded.Transaction, w io.Writer, sidecar io.Writer, written *int) error {
+	dimension := c.config.Dimension
+
+	// The header needs the row count before the rows
+	rows := 0
+	err := c.scanVectors(txn, func(_ string, vector []float32, _ func() (map[string]interface{}, error)) error {
+		if len(vector) == dimension {
+			rows++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(w)
+	if _, err := out.Write(npyHeader(rows, dimension)); err != nil {
+		return err
+	}
+
+	var side *bufio.Writer
+	var encoder *json.Encoder
+	if sidecar != nil {
+		side = bufio.NewWriter(sidecar)
+		encoder = json.NewEncoder(side)
+	}
+
+	row := make([]byte, 4*dimension)
+	err = c.scanVectors(txn, func(id string, vector []float32, metadata func() (map[string]interface{}, error)) error {
+		if len(vector) != dimension {
+			return nil
+		}
+
+		for i, v := range vector {
+			binary.LittleEndian.PutUint32(row[4*i:], math.Float32bits(v))
+		}
+		if _, err := out.Write(row); err != nil {
+			return err
+		}
+
+		if encoder != nil {
+			m, err := metadata()
+			if err != nil {
+				return err
+			}
+			if err := encoder.Encode(transferRecord{ID: id, Metadata: m}); err != nil {
+				return err
+			}
+		}
+		*written++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if side != nil {
+		if err := side.Flush(); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+// Import reads vectors from r into the collection in batches of one
+// transaction each. It returns the number of records committed, counting
+// those skipped by Resume; after a failure, import again with that number
+// as Resume to continue.
+func (c *Collection) Import(r io.Reader, format TransferFormat, opts ImportOptions) (_ int, err error) {
+	access, err := c.authorize(GrantOperationWrite)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { access.done(err) }()
+
+	var next func() (*transferRecord, error)
+	switch format {
+	case TransferJSONL:
+		next = jsonlReader(r)
+	case TransferNPY:
+		var err error
+		if next, err = npyReader(r, opts.Sidecar); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unknown transfer format: %s", format)
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultImportBatchSize
+	}
+
+	committed := 0
+	var batch []InsertItem
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := c.InsertBatch(batch, BatchOptions{Mode: BatchAtomic, Upsert: opts.Upsert})
+		if err != nil {
+			return err
+		}
+		committed += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		record, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return committed, fmt.Errorf("record %d: %w", committed+len(batch)+1, err)
+		}
+		if committed < opts.Resume {
+			committed++
+			continue
+		}
+
+		id := record.ID
+		if opts.MapID != nil {
+			id = opts.MapID(id)
+		}
+
+		// Documents with named vectors are written one at a time, in order
+		if len(record.Vectors) > 0 || len(record.MultiVectors) > 0 {
+			if err := flush(); err != nil {
+				return committed, err
+			}
+			_, err := c.putDocument(Document{
+				ID:           id,
+				Vector:       record.Vector,
+				Vectors:      record.Vectors,
+				MultiVectors: record.MultiVectors,
+				Metadata:     record.Metadata,
+			}, opts.Upsert)
+			if err != nil {
+				return committed, &BatchItemError{Index: committed, ID: id, Err: err}
+			}
+			committed++
+			continue
+		}
+
+		batch = append(batch, InsertItem{ID: id, Vector: record.Vector, Metadata: record.Metadata})
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return committed, err
+			}
+		}
+	}
+
+	return committed, flush()
+}
+
+// jsonlReader returns a function reading one record per call, io.EOF at the
+// end
+func jsonlReader(r io.Reader) func() (*transferRecord, error) {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	return func() (*transferRecord, error) {
+		var record transferRecord
+		if err := decoder.Decode(&record); err != nil {
+			return nil, err
+		}
+		if record.Vector == nil {
+			record.Vector = record.Values
+		}
+		if record.Metadata == nil {
+			record.Metadata = record.Properties
+		}
+		return &record, nil
+	}
+}
+
+// ============================================================================
+// NumPy
+// ============================================================================
+
+var (
+	npyMagic      = []byte("\x93NUMPY")
+	npyDescrRE    = regexp.MustCompile(`'descr'\s*:\s*'([^']*)'`)
+	npyFortranRE  = regexp.MustCompile(`'fortran_order'\s*:\s*(True|False)`)
+	npyShapeRE    = regexp.MustCompile(`'shape'\s*:\s*\(\s*(\d+)\s*,\s*(\d*)\s*,?\s*\)`)
+	errNPYInvalid = errors.New("invalid .npy file")
+)
+
+// npyHeader returns a version 1.0 header for a rows x dimension float32
+// matrix
+func npyHeader(rows, dimension int) []byte {
+	dict := fmt.Sprintf("{'descr': '<f4', 'fortran_order': False, 'shape': (%d, %d), }", rows, dimension)
+
+	// Magic, version and length take 10 bytes; the whole header is padded
+	// with spaces to a multiple of 64 and ends in a newline
+	padding := 64 - (10+len(dict)+1)%64
+	if padding == 64 {
+		padding = 0
+	}
+	dict += string(bytes.Repeat([]byte(" "), padding)) + "\n"
+
+	header := append([]byte{}, npyMagic...)
+	header = append(header, 1, 0)
+	header = binary.LittleEndian.AppendUint16(header, uint16(len(dict)))
+	return append(header, dict...)
+}
+
+// npyReader parses the header of a 2-D float matrix and returns a function
+// reading one row per call, paired with the sidecar line of the same row
+func npyReader(r io.Reader, sidecar io.Reader) (func() (*transferRecord, error), error) {
+	in := bufio.NewReader(r)
+
+	prefix := make([]byte, 8)
+	if _, err := io.ReadFull(in, prefix); err != nil || !bytes.Equal(prefix[:6], npyMagic) {
+		return nil, errNPYInvalid
+	}
+
+	var headerLen int
+	switch prefix[6] {
+	case 1:
+		var n uint16
+		if err := binary.Read(in, binary.LittleEndian, &n); err != nil {
+			return nil, errNPYInvalid
+		}
+		headerLen = int(n)
+	case 2, 3:
+		var n uint32
+		if err := binary.Read(in, binary.LittleEndian, &n); err != nil {
+			return nil, errNPYInvalid
+		}
+		headerLen = int(n)
+	default:
+		return nil, fmt.Errorf("unsupported .npy version %d", prefix[6])
+	}
+
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return nil, errNPYInvalid
+	}
+
+	descr := npyDescrRE.FindSubmatch(header)
+	fortran := npyFortranRE.FindSubmatch(header)
+	shape := npyShapeRE.FindSubmatch(header)
+	if descr == nil || fortran == nil || shape == nil {
+		return nil, errNPYInvalid
+	}
+	if string(fortran[1]) == "True" {
+		return nil, errors.New("fortran-order .npy files are not supported")
+	}
+
+	var width int
+	var order binary.ByteOrder
+	switch string(descr[1]) {
+	case "<f4", "|f4":
+		width, order = 4, binary.LittleEndian
+	case ">f4":
+		width, order = 4, binary.BigEndian
+	case "<f8", "|f8":
+		width, order = 8, binary.LittleEndian
+	case ">f8":
+		width, order = 8, binary.BigEndian
+	default:
+		return nil, fmt.Errorf("unsupported .npy dtype %s", descr[1])
+	}
+
+	rows, _ := strconv.Atoi(string(shape[1]))
+	dimension := 1
+	if len(shape[2]) > 0 {
+		dimension, _ = strconv.Atoi(string(shape[2]))
+	}
+
+	var side func() (*transferRecord, error)
+	if sidecar != nil {
+		side = jsonlReader(sidecar)
+	}
+
+	row := make([]byte, width*dimension)
+	read := 0
+	return func() (*transferRecord, error) {
+		if read == rows {
+			return nil, io.EOF
+		}
+		if _, err := io.ReadFull(in, row); err != nil {
+			return nil, fmt.Errorf("reading row %d: %w", read, err)
+		}
+
+		record := &transferRecord{Vector: make([]float32, dimension)}
+		for i := range record.Vector {
+			if width == 4 {
+				record.Vector[i] = math.Float32frombits(order.Uint32(row[4*i:]))
+			} else {
+				record.Vector[i] = float32(math.Float64frombits(order.Uint64(row[8*i:])))
+			}
+		}
+
+		if side != nil {
+			meta, err := side()
+			if err == io.EOF {
+				return nil, fmt.Errorf("sidecar ends before row %d", read)
+			}
+			if err != nil {
+				return nil, err
+			}
+			record.ID, record.Metadata = meta.ID, meta.Metadata
+		}
+
+		read++
+		return record, nil
+	}, nil
+}
@@ -0,0 +1,96 @@
+package sochdb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCollectionTransfer tests JSONL and NPY export and import
+func TestCollectionTransfer(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+	source, err := ns.CreateCollection(CollectionConfig{
+		Name:      "source",
+		Dimension: 3,
+		Vectors:   map[string]NamedVectorConfig{"title": {Dimension: 2}},
+	})
+	require.NoError(t, err)
+
+	_, err = source.InsertBatch([]InsertItem{
+		{ID: "a", Vector: []float32{1, 2, 3}, Metadata: map[string]interface{}{"tag": "x"}},
+		{ID: "b", Vector: []float32{4, 5, 6}},
+	}, BatchOptions{})
+	require.NoError(t, err)
+	_, err = source.InsertDocument(Document{ID: "c", Vector: []float32{7, 8, 9}, Vectors: map[string][]float32{"title": {1, 0}}})
+	require.NoError(t, err)
+
+	// JSONL keeps named vectors and remaps IDs
+	var jsonl bytes.Buffer
+	n, err := source.Export(&jsonl, TransferJSONL, ExportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	copied, err := ns.CreateCollection(CollectionConfig{Name: "copy", Dimension: 3, Vectors: source.config.Vectors})
+	require.NoError(t, err)
+	n, err = copied.Import(bytes.NewReader(jsonl.Bytes()), TransferJSONL, ImportOptions{
+		MapID: func(id string) string { return "new-" + id },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	data, err := copied.Get("new-a")
+	require.NoError(t, err)
+	require.NotNil(t, data)
+	assert.Equal(t, []float32{1, 2, 3}, data.Vector)
+	assert.Equal(t, "x", data.Metadata["tag"])
+	doc, err := copied.GetDocument("new-c")
+	require.NoError(t, err)
+	assert.Equal(t, []float32{1, 0}, doc.Vectors["title"])
+
+	// NPY stores vectors in a matrix with a sidecar of IDs and metadata
+	var npy, sidecar bytes.Buffer
+	n, err = source.Export(&npy, TransferNPY, ExportOptions{Sidecar: &sidecar})
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 0, (npy.Len()-3*3*4)%64)
+
+	matrix, err := ns.CreateCollection(CollectionConfig{Name: "matrix", Dimension: 3})
+	require.NoError(t, err)
+	n, err = matrix.Import(bytes.NewReader(npy.Bytes()), TransferNPY, ImportOptions{Sidecar: bytes.NewReader(sidecar.Bytes())})
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	data, err = matrix.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, []float32{4, 5, 6}, data.Vector)
+
+	// A failed import resumes from the committed count
+	dump := `{"id":"p1","values":[1,0,0],"metadata":{"src":"pinecone"}}
+{"id":"p2","values":[0,1,0]}
+{"id":"p3","values":[0,1]}
+{"id":"w1","vector":[0,0,1],"properties":{"src":"weaviate"}}
+`
+	target, err := ns.CreateCollection(CollectionConfig{Name: "target", Dimension: 3})
+	require.NoError(t, err)
+	n, err = target.Import(strings.NewReader(dump), TransferJSONL, ImportOptions{BatchSize: 1})
+	assert.Error(t, err)
+	assert.Equal(t, 2, n)
+
+	fixed := strings.Replace(dump, "[0,1]", "[0,1,1]", 1)
+	n, err = target.Import(strings.NewReader(fixed), TransferJSONL, ImportOptions{Resume: n})
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	count, err := target.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+	data, err = target.Get("w1")
+	require.NoError(t, err)
+	assert.Equal(t, "weaviate", data.Metadata["src"])
+
+	_, err = target.Export(&jsonl, TransferFormat("csv"), ExportOptions{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,232 @@
+// Result diversification
+//
+// Maximal Marginal Relevance (MMR) reorders candidates so each pick balances
+// its relevance against its similarity to the picks before it, and
+// collapsing keeps only the best result per value of a metadata field (e.g.
+// one chunk per source document). Both apply to Collection.Search and
+// HybridRetriever.Retrieve.
+//
+// Example:
+//
+//	results, err := docs.Search(sochdb.SearchRequest{
+//	    QueryVector:   embedding,
+//	    K:             5,
+//	    MMR:           true,
+//	    MMRLambda:     0.6, // 1 = pure relevance, 0 = pure diversity
+//	    FetchK:        50,
+//	    CollapseField: "doc_id",
+//	})
+
+package sochdb
+
+import (
+	"math"
+
+	"github.com/sochdb/sochdb-go/embedded"
+)
+
+const (
+	defaultMMRLambda     = 0.5
+	diversityFetchFactor = 4
+)
+
+// mmrSelect picks up to k of n candidates by Maximal Marginal Relevance
+// relevance must be scaled to [0, 1]; sim(i, j) is the similarity of two
+// candidates. It returns candidate indexes in pick order.
+func mmrSelect(relevance []float64, sim func(i, j int) float64, lambda float64, k int) []int {
+	n := len(relevance)
+	if k > n {
+		k = n
+	}
+
+	picked := make([]int, 0, k)
+	used := make([]bool, n)
+	// maxSim[i] is the highest similarity of candidate i to any pick
+	maxSim := make([]float64, n)
+	for i := range maxSim {
+		maxSim[i] = math.Inf(-1)
+	}
+
+	for len(picked) < k {
+		best, bestScore := -1, math.Inf(-1)
+		for i := 0; i < n; i++ {
+			if used[i] {
+				continue
+			}
+			penalty := 0.0
+			if len(picked) > 0 {
+				penalty = maxSim[i]
+			}
+			if s := lambda*relevance[i] - (1-lambda)*penalty; s > bestScore {
+				best, bestScore = i, s
+			}
+		}
+
+		used[best] = true
+		picked = append(picked, best)
+		for i := 0; i < n; i++ {
+			if !used[i] {
+				maxSim[i] = math.Max(maxSim[i], sim(i, best))
+			}
+		}
+	}
+
+	return picked
+}
+
+// collapseBy keeps the first of n ranked candidates for each key. Candidates
+// without a key are all kept.
+func collapseBy(n int, key func(i int) (interface{}, bool)) []int {
+	seen := make(map[interface{}]bool)
+	kept := make([]int, 0, n)
+
+	for i := 0; i < n; i++ {
+		k, ok := key(i)
+		if ok {
+			// Metadata values from JSON are comparable except for maps
+			// and slices, which are never collapsed
+			switch k.(type) {
+			case map[string]interface{}, []interface{}:
+				ok = false
+			}
+		}
+		if ok {
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+		}
+		kept = append(kept, i)
+	}
+
+	return kept
+}
+
+// scaleScores min-max scales scores to [0, 1]
+func scaleScores(scores []float64) []float64 {
+	scaled := make([]float64, len(scores))
+	if len(scores) == 0 {
+		return scaled
+	}
+
+	lo, hi := scores[0], scores[0]
+	for _, s := range scores {
+		lo = math.Min(lo, s)
+		hi = math.Max(hi, s)
+	}
+	for i, s := range scores {
+		if hi == lo {
+			scaled[i] = 1
+		} else {
+			scaled[i] = (s - lo) / (hi - lo)
+		}
+	}
+	return scaled
+}
+
+// cosine returns the cosine similarity of two vectors, or 0 if they cannot
+// be compared
+func cosine(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	return float64(score(DistanceMetricCosine, a, b))
+}
+
+// ============================================================================
+// Collection Search
+// ============================================================================
+
+// diversify applies the request's collapsing and MMR to ranked candidates
+// and returns the best k
+func (c *Collection) diversify(txn *embedded.Transaction, request SearchRequest, candidates []SearchResult, k int) ([]SearchResult, error) {
+	metadata := make([]map[string]interface{}, len(candidates))
+	vectors := make([][]float32, len(candidates))
+	for i, candidate := range candidates {
+		data, err := c.readVector(txn, candidate.ID)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		metadata[i] = data.Metadata
+		vectors[i] = data.Vector
+
+		if request.VectorName != "" {
+			if vectors[i], err = c.readNamedMean(txn, request.VectorName, candidate.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+
+	if request.CollapseField != "" {
+		order = collapseBy(len(candidates), func(i int) (interface{}, bool) {
+			v, ok := metadata[i][request.CollapseField]
+			return v, ok
+		})
+	}
+
+	if request.MMR {
+		lambda := float64(request.MMRLambda)
+		if lambda <= 0 || lambda > 1 {
+			lambda = defaultMMRLambda
+		}
+
+		scores := make([]float64, len(order))
+		for i, j := range order {
+			scores[i] = float64(candidates[j].Score)
+		}
+		picked := mmrSelect(scaleScores(scores), func(a, b int) float64 {
+			return cosine(vectors[order[a]], vectors[order[b]])
+		}, lambda, k)
+
+		reordered := make([]int, len(picked))
+		for i, p := range picked {
+			reordered[i] = order[p]
+		}
+		order = reordered
+	}
+
+	if len(order) > k {
+		order = order[:k]
+	}
+
+	results := make([]SearchResult, len(order))
+	for i, j := range order {
+		results[i] = candidates[j]
+	}
+	return results, nil
+}
+
+// readNamedMean loads a named vector, averaging multi-vector fields
+func (c *Collection) readNamedMean(txn *embedded.Transaction, name, id string) ([]float32, error) {
+	value, err := txn.Get(c.namedKey(name, id))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	if !c.config.Vectors[name].MultiVector {
+		vector, _, err := decodeVector(value)
+		return vector, err
+	}
+
+	vectors, err := decodeMultiVector(value)
+	if err != nil || len(vectors) == 0 {
+		return nil, err
+	}
+	mean := make([]float32, len(vectors[0]))
+	for _, v := range vectors {
+		for i := range mean {
+			if i < len(v) {
+				mean[i] += v[i] / float32(len(vectors))
+			}
+		}
+	}
+	return mean, nil
+}
@@ -0,0 +1,63 @@
+package sochdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCollectionDiversity tests MMR reranking and result collapsing
+func TestCollectionDiversity(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "chunks", Dimension: 2})
+	require.NoError(t, err)
+
+	_, err = docs.InsertBatch([]InsertItem{
+		{ID: "a1", Vector: []float32{1, 0}, Metadata: map[string]interface{}{"doc_id": "a"}},
+		{ID: "a2", Vector: []float32{0.99, 0.01}, Metadata: map[string]interface{}{"doc_id": "a"}},
+		{ID: "b1", Vector: []float32{0.7, 0.7}, Metadata: map[string]interface{}{"doc_id": "b"}},
+	}, BatchOptions{})
+	require.NoError(t, err)
+
+	ids := func(results []SearchResult) []string {
+		out := make([]string, len(results))
+		for i, r := range results {
+			out[i] = r.ID
+		}
+		return out
+	}
+
+	results, err := docs.Search(SearchRequest{QueryVector: []float32{1, 0}, K: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a1", "a2"}, ids(results))
+
+	results, err = docs.Search(SearchRequest{QueryVector: []float32{1, 0}, K: 2, CollapseField: "doc_id", IncludeMetadata: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a1", "b1"}, ids(results))
+	assert.Equal(t, "b", results[1].Metadata["doc_id"])
+
+	results, err = docs.Search(SearchRequest{QueryVector: []float32{1, 0}, K: 2, MMR: true, MMRLambda: 0.1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a1", "b1"}, ids(results))
+
+	// Pure relevance keeps the plain ranking
+	results, err = docs.Search(SearchRequest{QueryVector: []float32{1, 0}, K: 2, MMR: true, MMRLambda: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a1", "a2"}, ids(results))
+
+	// Retrievers collapse on document fields
+	retriever := NewHybridRetriever(db, "kb", &RetrievalConfig{Limit: 2, LexicalWeight: 0.3, SemanticWeight: 0.7, RRFConstant: 60, CollapseField: "source"})
+	require.NoError(t, retriever.IndexDocuments(map[string]map[string]interface{}{
+		"d1": {"id": "d1", "text": "go database tutorial", "source": "s1"},
+		"d2": {"id": "d2", "text": "go database tutorial part two", "source": "s1"},
+		"d3": {"id": "d3", "text": "database internals", "source": "s2"},
+	}))
+	retrieved, err := retriever.Retrieve("go database", NewAllAllowedSet())
+	require.NoError(t, err)
+	require.Len(t, retrieved, 2)
+	assert.Equal(t, "s1", retrieved[0]["source"])
+	assert.Equal(t, "s2", retrieved[1]["source"])
+}
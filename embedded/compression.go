@@ -0,0 +1,304 @@
+package embedded
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Compressed value layout:
+//
+//	magic "SDBZ" | algorithm (1 byte) | original length (uvarint) | payload
+//
+// Values without the magic were written before compression was enabled and
+// are returned as stored; raw values that start with the magic are framed as
+// CompressionNone. Compression runs before encryption.
+var compressedMagic = []byte("SDBZ")
+
+// ErrDecompressionFailed is returned when a value carrying the compression
+// magic has a header or payload that does not decode
+var ErrDecompressionFailed = errors.New("failed to decompress value")
+
+// defaultCompressionMinSize is the smallest value worth compressing
+const defaultCompressionMinSize = 128
+
+// Bounds on the size a compressed header may claim
+const (
+	maxDecompressedSize = 1 << 30 // 1 GiB
+	maxSnappyRatio      = 22
+	maxLZ4Ratio         = 256
+)
+
+// CompressionType selects a value compression algorithm
+type CompressionType uint8
+
+const (
+	CompressionNone   CompressionType = 0 // Store values as written
+	CompressionZstd   CompressionType = 1 // Best ratio; Level 1-22
+	CompressionSnappy CompressionType = 2 // Fastest; Level ignored
+	CompressionLZ4    CompressionType = 3 // Fast; Level > 0 selects LZ4-HC
+)
+
+// String returns the algorithm name
+func (c CompressionType) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionLZ4:
+		return "lz4"
+	default:
+		return fmt.Sprintf("CompressionType(%d)", uint8(c))
+	}
+}
+
+// CompressionRule sets the compression for values under a key prefix
+type CompressionRule struct {
+	Prefix []byte
+	Type   CompressionType
+	Level  int
+}
+
+// CompressionConfig configures transparent value compression
+//
+// Each value is compressed with the rule whose Prefix is the longest match
+// for its key, or with Default when no rule matches. Use a CompressionNone
+// rule to exclude a prefix from a compressed default.
+type CompressionConfig struct {
+	Default CompressionType
+	Level   int
+	Rules   []CompressionRule
+
+	// MinSize is the smallest value that gets compressed (default 128 bytes)
+	MinSize int
+}
+
+// compressor compresses values and keeps in-process ratio counters
+type compressor struct {
+	rules   []CompressionRule
+	minSize int
+
+	// Bytes handed to and produced by compress, for Stats
+	rawBytes    atomic.Uint64
+	storedBytes atomic.Uint64
+}
+
+func newCompressor(config *CompressionConfig) (*compressor, error) {
+	c := &compressor{
+		rules:   []CompressionRule{{Type: config.Default, Level: config.Level}},
+		minSize: config.MinSize,
+	}
+	if c.minSize <= 0 {
+		c.minSize = defaultCompressionMinSize
+	}
+
+	for _, rule := range config.Rules {
+		rule.Prefix = append([]byte(nil), rule.Prefix...)
+		c.rules = append(c.rules, rule)
+	}
+
+	for _, rule := range c.rules {
+		if rule.Type > CompressionLZ4 {
+			return nil, fmt.Errorf("unsupported compression type %s", rule.Type)
+		}
+	}
+	return c, nil
+}
+
+// rule returns the longest-prefix rule for key; the default rule has an
+// empty prefix and always matches
+func (c *compressor) rule(key []byte) CompressionRule {
+	best := c.rules[0]
+	for _, rule := range c.rules[1:] {
+		if len(rule.Prefix) >= len(best.Prefix) && bytes.HasPrefix(key, rule.Prefix) {
+			best = rule
+		}
+	}
+	return best
+}
+
+func (c *compressor) compress(key, value []byte) ([]byte, error) {
+	rule := c.rule(key)
+
+	var payload []byte
+	algorithm := CompressionNone
+	if rule.Type != CompressionNone && len(value) >= c.minSize {
+		compressed, err := compressPayload(rule.Type, rule.Level, value)
+		if err != nil {
+			return nil, err
+		}
+		// Keep the original when compression does not pay off
+		if compressed != nil && len(compressed)+compressedHeaderLen(len(value)) < len(value) {
+			payload, algorithm = compressed, rule.Type
+		}
+	}
+
+	out := value
+	if algorithm != CompressionNone {
+		out = appendCompressedHeader(nil, algorithm, len(value))
+		out = append(out, payload...)
+	} else if bytes.HasPrefix(value, compressedMagic) {
+		// Frame raw values that look compressed so reads stay unambiguous
+		out = appendCompressedHeader(nil, CompressionNone, len(value))
+		out = append(out, value...)
+	}
+
+	c.rawBytes.Add(uint64(len(value)))
+	c.storedBytes.Add(uint64(len(out)))
+	return out, nil
+}
+
+func (c *compressor) decompress(value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, compressedMagic) {
+		return value, nil
+	}
+	if len(value) < len(compressedMagic)+2 {
+		return nil, fmt.Errorf("%w: truncated header", ErrDecompressionFailed)
+	}
+
+	algorithm := CompressionType(value[len(compressedMagic)])
+	size, n := binary.Uvarint(value[len(compressedMagic)+1:])
+	if algorithm > CompressionLZ4 || n <= 0 {
+		return nil, fmt.Errorf("%w: invalid header", ErrDecompressionFailed)
+	}
+	payload := value[len(compressedMagic)+1+n:]
+	if !plausibleSize(algorithm, len(payload), size) {
+		return nil, fmt.Errorf("%w: %s payload of %d bytes cannot hold %d bytes", ErrDecompressionFailed, algorithm, len(payload), size)
+	}
+
+	out, err := decompressPayload(algorithm, payload, int(size))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecompressionFailed, err)
+	}
+	if len(out) != int(size) {
+		return nil, fmt.Errorf("%w: expected %d bytes, got %d", ErrDecompressionFailed, size, len(out))
+	}
+	return out, nil
+}
+
+// plausibleSize reports whether a payload of n bytes can decompress to size
+// bytes, so a corrupt header cannot force a huge allocation
+func plausibleSize(algorithm CompressionType, n int, size uint64) bool {
+	if size > maxDecompressedSize {
+		return false
+	}
+	switch algorithm {
+	case CompressionNone:
+		return size == uint64(n)
+	case CompressionSnappy:
+		// A 3-byte copy element expands to at most 64 bytes
+		return size <= uint64(n)*maxSnappyRatio
+	case CompressionLZ4:
+		// Each extra length byte of a match adds at most 255 bytes
+		return size <= uint64(n)*maxLZ4Ratio
+	default:
+		// zstd has no useful bound; its output is not preallocated
+		return n > 0
+	}
+}
+
+// ratio returns uncompressed over stored bytes, or 1 before any writes
+func (c *compressor) ratio() (raw, stored uint64, ratio float64) {
+	raw, stored = c.rawBytes.Load(), c.storedBytes.Load()
+	if raw == 0 || stored == 0 {
+		return raw, stored, 1
+	}
+	return raw, stored, float64(raw) / float64(stored)
+}
+
+func compressedHeaderLen(size int) int {
+	return len(compressedMagic) + 1 + binary.PutUvarint(make([]byte, binary.MaxVarintLen64), uint64(size))
+}
+
+func appendCompressedHeader(dst []byte, algorithm CompressionType, size int) []byte {
+	dst = append(dst, compressedMagic...)
+	dst = append(dst, byte(algorithm))
+	return binary.AppendUvarint(dst, uint64(size))
+}
+
+// compressPayload returns nil when the algorithm cannot shrink value
+func compressPayload(algorithm CompressionType, level int, value []byte) ([]byte, error) {
+	switch algorithm {
+	case CompressionZstd:
+		enc, err := zstdEncoder(level)
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(value, nil), nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, value), nil
+	case CompressionLZ4:
+		dst := make([]byte, lz4.CompressBlockBound(len(value)))
+		var n int
+		var err error
+		if level > 0 {
+			if level > 9 {
+				level = 9
+			}
+			n, err = lz4.CompressBlockHC(value, dst, lz4.CompressionLevel(1<<(8+level)), nil, nil)
+		} else {
+			n, err = lz4.CompressBlock(value, dst, nil)
+		}
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		return dst[:n], nil
+	default:
+		return nil, fmt.Errorf("unsupported compression type %s", algorithm)
+	}
+}
+
+func decompressPayload(algorithm CompressionType, payload []byte, size int) ([]byte, error) {
+	switch algorithm {
+	case CompressionNone:
+		return payload, nil
+	case CompressionZstd:
+		return zstdDecoder.DecodeAll(payload, nil)
+	case CompressionSnappy:
+		return snappy.Decode(make([]byte, size), payload)
+	case CompressionLZ4:
+		out := make([]byte, size)
+		n, err := lz4.UncompressBlock(payload, out)
+		return out[:n], err
+	default:
+		return nil, fmt.Errorf("unknown compression type %s", algorithm)
+	}
+}
+
+// zstd encoders and decoders are safe for concurrent EncodeAll/DecodeAll
+// calls, so one per level is shared by all databases
+var (
+	zstdEncodersMu sync.Mutex
+	zstdEncoders   = map[zstd.EncoderLevel]*zstd.Encoder{}
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxDecompressedSize))
+)
+
+func zstdEncoder(level int) (*zstd.Encoder, error) {
+	encLevel := zstd.SpeedDefault
+	if level > 0 {
+		encLevel = zstd.EncoderLevelFromZstd(level)
+	}
+
+	zstdEncodersMu.Lock()
+	defer zstdEncodersMu.Unlock()
+
+	if enc, ok := zstdEncoders[encLevel]; ok {
+		return enc, nil
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(encLevel))
+	if err != nil {
+		return nil, err
+	}
+	zstdEncoders[encLevel] = enc
+	return enc, nil
+}
@@ -0,0 +1,141 @@
+package embedded_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sochdb/sochdb-go/embedded"
+)
+
+func TestCompressionCodecs(t *testing.T) {
+	dir := t.TempDir()
+
+	// Plaintext written before compression was enabled
+	plain, err := embedded.Open(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	legacyValues := map[string][]byte{
+		"legacy":       []byte(strings.Repeat("written before compression ", 10)),
+		"legacy/short": []byte("SDB"),
+	}
+	// Values carrying the magic whose header or payload does not decode
+	corruptValues := map[string][]byte{
+		"corrupt/magic":     []byte("SDBZ"),
+		"corrupt/header":    []byte("SDBZ looks compressed but is not"),
+		"corrupt/huge":      append([]byte("SDBZ\x01"), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 'x'),
+		"corrupt/payload":   append([]byte("SDBZ\x02\x10"), bytes.Repeat([]byte{0xee}, 12)...),
+		"corrupt/truncated": []byte("SDBZ\x00\x09short"),
+	}
+	for key, v := range legacyValues {
+		plain.Put([]byte(key), v)
+	}
+	for key, v := range corruptValues {
+		plain.Put([]byte(key), v)
+	}
+	plain.Close()
+
+	db, err := embedded.OpenWithOptions(filepath.Join(dir, "db"), embedded.Options{
+		Compression: &embedded.CompressionConfig{
+			Default: embedded.CompressionSnappy,
+			Rules: []embedded.CompressionRule{
+				{Prefix: []byte("zstd/"), Type: embedded.CompressionZstd, Level: 3},
+				{Prefix: []byte("lz4/"), Type: embedded.CompressionLZ4},
+				{Prefix: []byte("lz4/hc/"), Type: embedded.CompressionLZ4, Level: 9},
+				{Prefix: []byte("raw/"), Type: embedded.CompressionNone},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer db.Close()
+
+	value := []byte(strings.Repeat("[0.125, 0.25, 0.5, 1.0], ", 200))
+	random := make([]byte, 4096)
+	rand.Read(random)
+
+	cases := map[string][]byte{
+		"snappy/a":   value,
+		"zstd/a":     value,
+		"lz4/a":      value,
+		"lz4/hc/a":   value,
+		"raw/a":      value,
+		"zstd/rand":  random,
+		"zstd/small": []byte("tiny"),
+		"zstd/magic": []byte("SDBZ"),
+	}
+	for key, v := range cases {
+		if err := db.Put([]byte(key), v); err != nil {
+			t.Fatalf("Put %s failed: %v", key, err)
+		}
+	}
+
+	for key, want := range cases {
+		got, err := db.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", key, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Value mismatch for %s", key)
+		}
+	}
+
+	for key, want := range legacyValues {
+		got, err := db.Get([]byte(key))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("Expected %s unchanged, got %q (%v)", key, got, err)
+		}
+	}
+	for key := range corruptValues {
+		if got, err := db.Get([]byte(key)); !errors.Is(err, embedded.ErrDecompressionFailed) {
+			t.Errorf("Expected ErrDecompressionFailed for %s, got %q (%v)", key, got, err)
+		}
+	}
+
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.CompressionRatio <= 1 {
+		t.Errorf("Expected compression ratio above 1, got %+v", stats)
+	}
+}
+
+func TestCompressionWithEncryption(t *testing.T) {
+	dir := t.TempDir()
+
+	keys, err := embedded.CreateKeyfile(filepath.Join(dir, "keys.json"))
+	if err != nil {
+		t.Fatalf("CreateKeyfile failed: %v", err)
+	}
+
+	db, err := embedded.OpenWithOptions(filepath.Join(dir, "db"), embedded.Options{
+		Encryption:  &embedded.EncryptionConfig{Provider: keys},
+		Compression: &embedded.CompressionConfig{Default: embedded.CompressionZstd},
+	})
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer db.Close()
+
+	value := []byte(strings.Repeat("cached llm response ", 100))
+	if err := db.Put([]byte("cache/1"), value); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	txn := db.Begin()
+	defer txn.Abort()
+
+	iter := txn.ScanPrefix([]byte("cache/"))
+	defer iter.Close()
+
+	_, got, ok := iter.Next()
+	if !ok || !bytes.Equal(got, value) {
+		t.Errorf("Expected scanned value to round trip (err %v)", iter.Err())
+	}
+}
@@ -91,9 +91,11 @@ import (
 
 // Database represents an embedded SochDB instance with direct FFI access
 type Database struct {
-	ptr        C.DatabasePtr
-	path       string
-	concurrent bool
+	ptr         C.DatabasePtr
+	path        string
+	concurrent  bool
+	encryption  *encryptor
+	compression *compressor
 }
 
 // Open opens a SochDB database at the specified path
@@ -280,13 +282,19 @@ func (db *Database) Checkpoint() (uint64, error) {
 func (db *Database) Stats() (*Stats, error) {
 	cstats := C.sochdb_stats(db.ptr)
 
-	return &Stats{
+	stats := &Stats{
 		MemtableSizeBytes:  uint64(cstats.memtable_size_bytes),
 		WalSizeBytes:       uint64(cstats.wal_size_bytes),
 		ActiveTransactions: uint(cstats.active_transactions),
 		MinActiveSnapshot:  uint64(cstats.min_active_snapshot),
 		LastCheckpointLsn:  uint64(cstats.last_checkpoint_lsn),
-	}, nil
+		CompressionRatio:   1,
+	}
+	if db.compression != nil {
+		stats.UncompressedBytes, stats.CompressedBytes, stats.CompressionRatio = db.compression.ratio()
+	}
+
+	return stats, nil
 }
 
 // SetTableIndexPolicy sets the index policy for a table
@@ -322,6 +330,11 @@ type Stats struct {
 	ActiveTransactions uint
 	MinActiveSnapshot  uint64
 	LastCheckpointLsn  uint64
+
+	// Value compression counters for writes made through this handle
+	UncompressedBytes uint64
+	CompressedBytes   uint64
+	CompressionRatio  float64 // UncompressedBytes / CompressedBytes
 }
 
 // IndexPolicy represents the indexing strategy for a table
@@ -354,6 +367,15 @@ func (txn *Transaction) SnapshotTS() uint64 {
 
 // Put stores a key-value pair within the transaction
 func (txn *Transaction) Put(key, value []byte) error {
+	value, err := txn.db.encodeValue(key, value)
+	if err != nil {
+		return err
+	}
+	return txn.putRaw(key, value)
+}
+
+// putRaw stores value exactly as given, bypassing value encoding
+func (txn *Transaction) putRaw(key, value []byte) error {
 	if err := txn.ensureActive(); err != nil {
 		return err
 	}
@@ -384,6 +406,15 @@ func (txn *Transaction) Put(key, value []byte) error {
 
 // Get retrieves a value by key within the transaction
 func (txn *Transaction) Get(key []byte) ([]byte, error) {
+	value, err := txn.getRaw(key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return txn.db.decodeValue(key, value)
+}
+
+// getRaw retrieves a value as stored, without decoding it
+func (txn *Transaction) getRaw(key []byte) ([]byte, error) {
 	if err := txn.ensureActive(); err != nil {
 		return nil, err
 	}
@@ -456,6 +487,11 @@ func (txn *Transaction) PutPath(path string, value []byte) error {
 		return err
 	}
 
+	value, err := txn.db.encodeValue([]byte(path), value)
+	if err != nil {
+		return err
+	}
+
 	cpath := C.CString(path)
 	defer C.free(unsafe.Pointer(cpath))
 
@@ -512,11 +548,18 @@ func (txn *Transaction) GetPath(path string) ([]byte, error) {
 	value := C.GoBytes(unsafe.Pointer(valOut), C.int(lenOut))
 	C.sochdb_free_bytes(valOut, lenOut)
 
-	return value, nil
+	return txn.db.decodeValue([]byte(path), value)
 }
 
 // ScanPrefix returns an iterator for keys with the given prefix
 func (txn *Transaction) ScanPrefix(prefix []byte) *ScanIterator {
+	iter := txn.scanRaw(prefix)
+	iter.decode = txn.db.decodeValue
+	return iter
+}
+
+// scanRaw returns an iterator that yields values as stored
+func (txn *Transaction) scanRaw(prefix []byte) *ScanIterator {
 	if err := txn.ensureActive(); err != nil {
 		return &ScanIterator{err: err}
 	}
@@ -543,6 +586,11 @@ func (txn *Transaction) ScanPrefix(prefix []byte) *ScanIterator {
 	}
 }
 
+// ErrConflict is returned by Commit when the transaction conflicts with a
+// concurrent one under serializable snapshot isolation. The transaction can
+// be retried.
+var ErrConflict = errors.New("SSI conflict: transaction aborted due to serialization failure")
+
 // Commit commits the transaction
 func (txn *Transaction) Commit() error {
 	if err := txn.ensureActive(); err != nil {
@@ -553,7 +601,7 @@ func (txn *Transaction) Commit() error {
 
 	if result.error_code != 0 {
 		if result.error_code == -2 {
-			return errors.New("SSI conflict: transaction aborted due to serialization failure")
+			return ErrConflict
 		}
 		return errors.New("failed to commit transaction")
 	}
@@ -585,8 +633,9 @@ func (txn *Transaction) ensureActive() error {
 
 // ScanIterator iterates over scan results
 type ScanIterator struct {
-	ptr C.ScanIteratorPtr
-	err error
+	ptr    C.ScanIteratorPtr
+	err    error
+	decode func(key, value []byte) ([]byte, error)
 }
 
 // Next returns the next key-value pair, or false if done
@@ -614,6 +663,15 @@ func (iter *ScanIterator) Next() ([]byte, []byte, bool) {
 	C.sochdb_free_bytes(keyOut, keyLen)
 	C.sochdb_free_bytes(valOut, valLen)
 
+	if iter.decode != nil {
+		decoded, err := iter.decode(key, value)
+		if err != nil {
+			iter.err = err
+			return nil, nil, false
+		}
+		value = decoded
+	}
+
 	return key, value, true
 }
 
@@ -0,0 +1,210 @@
+package embedded
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Encrypted value layout:
+//
+//	magic "SDBE" | version (1 byte) | key ID (uint32 BE) | nonce (12 bytes) | AES-GCM ciphertext
+//
+// The storage key is used as additional authenticated data, so a ciphertext
+// copied under another key fails to decrypt. Values without the magic are
+// plaintext written before encryption was enabled.
+var encryptedMagic = []byte("SDBE")
+
+const (
+	encryptedVersion   = 1
+	encryptedNonceSize = 12
+	encryptedHeaderLen = 4 + 1 + 4 + encryptedNonceSize
+)
+
+var (
+	// ErrEncryptionDisabled is returned by encryption operations on a
+	// database opened without an EncryptionConfig
+	ErrEncryptionDisabled = errors.New("encryption is not enabled for this database")
+
+	// ErrDecryptionFailed is returned when a value cannot be authenticated
+	// with its key
+	ErrDecryptionFailed = errors.New("failed to decrypt value")
+)
+
+// KeyProvider supplies AES keys by ID
+//
+// Keys must be 16, 24 or 32 bytes long (AES-128, AES-192 or AES-256).
+// Implementations must be safe for concurrent use.
+type KeyProvider interface {
+	// CurrentKey returns the key used for new writes and its ID
+	CurrentKey() (id uint32, key []byte, err error)
+
+	// Key returns the key with the given ID, for reading older values
+	Key(id uint32) ([]byte, error)
+}
+
+// EncryptionConfig configures transparent value encryption
+type EncryptionConfig struct {
+	// Provider supplies encryption keys (required)
+	Provider KeyProvider
+
+	// Prefixes limits encryption to values whose key starts with one of
+	// these prefixes. Empty encrypts every value. Encrypted values are
+	// always decrypted on read, whatever their key.
+	Prefixes [][]byte
+}
+
+// encryptor seals and opens values with AES-GCM
+type encryptor struct {
+	provider KeyProvider
+	prefixes [][]byte
+
+	mu    sync.RWMutex
+	aeads map[uint32]cipher.AEAD
+}
+
+func newEncryptor(config *EncryptionConfig) (*encryptor, error) {
+	if config.Provider == nil {
+		return nil, errors.New("encryption config requires a key provider")
+	}
+
+	e := &encryptor{
+		provider: config.Provider,
+		aeads:    make(map[uint32]cipher.AEAD),
+	}
+	for _, prefix := range config.Prefixes {
+		e.prefixes = append(e.prefixes, append([]byte(nil), prefix...))
+	}
+
+	// Fail at open time rather than on the first write
+	if _, _, err := e.currentAEAD(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// covers reports whether values under key should be encrypted
+func (e *encryptor) covers(key []byte) bool {
+	if len(e.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range e.prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *encryptor) seal(key, value []byte) ([]byte, error) {
+	if !e.covers(key) {
+		return value, nil
+	}
+
+	id, aead, err := e.currentAEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, encryptedHeaderLen, encryptedHeaderLen+len(value)+aead.Overhead())
+	copy(out, encryptedMagic)
+	out[4] = encryptedVersion
+	binary.BigEndian.PutUint32(out[5:9], id)
+
+	nonce := out[9:encryptedHeaderLen]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return aead.Seal(out, nonce, value, key), nil
+}
+
+func (e *encryptor) open(key, value []byte) ([]byte, error) {
+	id, ok := encryptedKeyID(value)
+	if !ok {
+		return value, nil
+	}
+
+	aead, err := e.aead(id)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := value[9:encryptedHeaderLen]
+	plain, err := aead.Open(nil, nonce, value[encryptedHeaderLen:], key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: key %d", ErrDecryptionFailed, id)
+	}
+	return plain, nil
+}
+
+// needsRewrite reports whether a stored value should be re-encrypted under
+// the key with ID current
+func (e *encryptor) needsRewrite(key, value []byte, current uint32) bool {
+	if !e.covers(key) {
+		return false
+	}
+	id, ok := encryptedKeyID(value)
+	return !ok || id != current
+}
+
+func (e *encryptor) currentAEAD() (uint32, cipher.AEAD, error) {
+	id, key, err := e.provider.CurrentKey()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	e.mu.RLock()
+	aead, ok := e.aeads[id]
+	e.mu.RUnlock()
+	if ok {
+		return id, aead, nil
+	}
+
+	aead, err = e.cache(id, key)
+	return id, aead, err
+}
+
+func (e *encryptor) aead(id uint32) (cipher.AEAD, error) {
+	e.mu.RLock()
+	aead, ok := e.aeads[id]
+	e.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	key, err := e.provider.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	return e.cache(id, key)
+}
+
+func (e *encryptor) cache(id uint32, key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key %d: %w", id, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.aeads[id] = aead
+	e.mu.Unlock()
+	return aead, nil
+}
+
+// encryptedKeyID parses the header of an encrypted value
+func encryptedKeyID(value []byte) (uint32, bool) {
+	if len(value) < encryptedHeaderLen || !bytes.HasPrefix(value, encryptedMagic) || value[4] != encryptedVersion {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(value[5:9]), true
+}
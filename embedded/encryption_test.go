@@ -0,0 +1,175 @@
+package embedded_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/sochdb/sochdb-go/embedded"
+)
+
+func TestEncryptionAtRest(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db")
+
+	keys, err := embedded.CreateKeyfile(filepath.Join(dir, "keys.json"))
+	if err != nil {
+		t.Fatalf("CreateKeyfile failed: %v", err)
+	}
+
+	db, err := embedded.OpenWithOptions(dbPath, embedded.Options{
+		Encryption: &embedded.EncryptionConfig{
+			Provider: keys,
+			Prefixes: [][]byte{[]byte("secret/")},
+		},
+	})
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+
+	if err := db.Put([]byte("secret/a"), []byte("memory of alice")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := db.Put([]byte("public/a"), []byte("hello")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	value, err := db.Get([]byte("secret/a"))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if string(value) != "memory of alice" {
+		t.Errorf("Expected decrypted value, got %q", value)
+	}
+
+	txn := db.Begin()
+	iter := txn.ScanPrefix([]byte("secret/"))
+	_, scanned, ok := iter.Next()
+	if !ok || string(scanned) != "memory of alice" {
+		t.Errorf("Expected scan to decrypt, got %q (err %v)", scanned, iter.Err())
+	}
+	iter.Close()
+	txn.Abort()
+	db.Close()
+
+	// Without the key the stored bytes are ciphertext
+	raw, err := embedded.Open(dbPath)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer raw.Close()
+
+	stored, err := raw.Get([]byte("secret/a"))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if bytes.Contains(stored, []byte("alice")) {
+		t.Errorf("Value stored in plaintext: %q", stored)
+	}
+
+	public, _ := raw.Get([]byte("public/a"))
+	if string(public) != "hello" {
+		t.Errorf("Expected value outside prefixes to stay plaintext, got %q", public)
+	}
+}
+
+func TestKeyRotationAndReEncrypt(t *testing.T) {
+	dir := t.TempDir()
+
+	keys, err := embedded.CreateKeyfile(filepath.Join(dir, "keys.json"))
+	if err != nil {
+		t.Fatalf("CreateKeyfile failed: %v", err)
+	}
+
+	db, err := embedded.Open(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// Plaintext written before encryption was enabled
+	for i := 0; i < 10; i++ {
+		db.Put([]byte(fmt.Sprintf("doc/%d", i)), []byte(fmt.Sprintf("value %d", i)))
+	}
+	db.Close()
+
+	db, err = embedded.OpenWithOptions(filepath.Join(dir, "db"), embedded.Options{
+		Encryption: &embedded.EncryptionConfig{Provider: keys},
+	})
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+
+	// Legacy plaintext stays readable
+	value, _ := db.Get([]byte("doc/3"))
+	if string(value) != "value 3" {
+		t.Errorf("Expected legacy value, got %q", value)
+	}
+
+	job, err := db.ReEncrypt(context.Background(), []byte("doc/"))
+	if err != nil {
+		t.Fatalf("ReEncrypt failed: %v", err)
+	}
+	if err := job.Wait(); err != nil {
+		t.Fatalf("ReEncrypt job failed: %v", err)
+	}
+	if p := job.Progress(); p.Rewritten != 10 || !p.Done {
+		t.Errorf("Expected 10 values rewritten, got %+v", p)
+	}
+
+	// Rotate, move everything to the new key and retire the old one
+	oldID, _, _ := keys.CurrentKey()
+	if _, err := keys.Rotate(); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+
+	job, err = db.ReEncrypt(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("ReEncrypt failed: %v", err)
+	}
+	if err := job.Wait(); err != nil {
+		t.Fatalf("ReEncrypt job failed: %v", err)
+	}
+	if p := job.Progress(); p.Rewritten != 10 {
+		t.Errorf("Expected 10 values rewritten after rotation, got %+v", p)
+	}
+
+	if err := keys.RemoveKey(oldID); err != nil {
+		t.Fatalf("RemoveKey failed: %v", err)
+	}
+
+	reloaded, err := embedded.OpenKeyfile(filepath.Join(dir, "keys.json"))
+	if err != nil {
+		t.Fatalf("OpenKeyfile failed: %v", err)
+	}
+	if _, err := reloaded.Key(oldID); err == nil {
+		t.Errorf("Expected key %d to be removed", oldID)
+	}
+
+	value, err = db.Get([]byte("doc/7"))
+	if err != nil {
+		t.Fatalf("Get after rotation failed: %v", err)
+	}
+	if string(value) != "value 7" {
+		t.Errorf("Expected 'value 7', got %q", value)
+	}
+
+	if err := keys.RemoveKey(oldID + 1); err == nil {
+		t.Error("Expected removing the current key to fail")
+	}
+}
+
+func TestReEncryptRequiresEncryption(t *testing.T) {
+	db, err := embedded.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.ReEncrypt(context.Background(), nil); !errors.Is(err, embedded.ErrEncryptionDisabled) {
+		t.Errorf("Expected ErrEncryptionDisabled, got %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package embedded
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// keyfileData is the on-disk format of a keyfile
+//
+//	{"current_key_id": 2, "keys": {"1": "<base64>", "2": "<base64>"}}
+type keyfileData struct {
+	CurrentKeyID uint32            `json:"current_key_id"`
+	Keys         map[string]string `json:"keys"`
+}
+
+// KeyfileProvider is a KeyProvider backed by a local JSON keyfile
+//
+// The keyfile holds every key still needed to read existing data. Rotate
+// adds a new AES-256 key and makes it current; once ReEncrypt has moved all
+// values to the new key, old keys can be dropped with RemoveKey.
+type KeyfileProvider struct {
+	path string
+
+	mu      sync.RWMutex
+	current uint32
+	keys    map[uint32][]byte
+}
+
+// CreateKeyfile creates a keyfile at path holding a single new key
+// Returns an error if the file already exists.
+func CreateKeyfile(path string) (*KeyfileProvider, error) {
+	key, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &KeyfileProvider{
+		path:    path,
+		current: 1,
+		keys:    map[uint32][]byte{1: key},
+	}
+
+	data, err := p.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// OpenKeyfile loads an existing keyfile
+func OpenKeyfile(path string) (*KeyfileProvider, error) {
+	p := &KeyfileProvider{path: path}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// CurrentKey returns the key used for new writes
+func (p *KeyfileProvider) CurrentKey() (uint32, []byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	key, ok := p.keys[p.current]
+	if !ok {
+		return 0, nil, fmt.Errorf("current encryption key %d not found in %s", p.current, p.path)
+	}
+	return p.current, key, nil
+}
+
+// Key returns the key with the given ID
+func (p *KeyfileProvider) Key(id uint32) ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("encryption key %d not found in %s", id, p.path)
+	}
+	return key, nil
+}
+
+// Rotate generates a new key, makes it current and saves the keyfile
+// Returns the ID of the new key.
+func (p *KeyfileProvider) Rotate() (uint32, error) {
+	key, err := generateKey()
+	if err != nil {
+		return 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	next := p.current
+	for id := range p.keys {
+		if id > next {
+			next = id
+		}
+	}
+	next++
+
+	p.keys[next] = key
+	prev := p.current
+	p.current = next
+
+	if err := p.save(); err != nil {
+		delete(p.keys, next)
+		p.current = prev
+		return 0, err
+	}
+	return next, nil
+}
+
+// RemoveKey drops a retired key from the keyfile
+// Values still encrypted with it become unreadable, so run ReEncrypt first.
+func (p *KeyfileProvider) RemoveKey(id uint32) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if id == p.current {
+		return errors.New("cannot remove the current encryption key")
+	}
+	key, ok := p.keys[id]
+	if !ok {
+		return nil
+	}
+
+	delete(p.keys, id)
+	if err := p.save(); err != nil {
+		p.keys[id] = key
+		return err
+	}
+	return nil
+}
+
+// Reload re-reads the keyfile, picking up keys rotated by another process
+func (p *KeyfileProvider) Reload() error {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+
+	var file keyfileData
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid keyfile %s: %w", p.path, err)
+	}
+
+	keys := make(map[uint32][]byte, len(file.Keys))
+	for idStr, encoded := range file.Keys {
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid key ID %q in %s", idStr, p.path)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("invalid key %d in %s: %w", id, p.path, err)
+		}
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return fmt.Errorf("invalid key %d in %s: length must be 16, 24 or 32 bytes", id, p.path)
+		}
+		keys[uint32(id)] = key
+	}
+
+	if _, ok := keys[file.CurrentKeyID]; !ok {
+		return fmt.Errorf("current key %d not found in %s", file.CurrentKeyID, p.path)
+	}
+
+	p.mu.Lock()
+	p.current = file.CurrentKeyID
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+// Helper methods
+func (p *KeyfileProvider) marshal() ([]byte, error) {
+	file := keyfileData{
+		CurrentKeyID: p.current,
+		Keys:         make(map[string]string, len(p.keys)),
+	}
+	for id, key := range p.keys {
+		file.Keys[strconv.FormatUint(uint64(id), 10)] = base64.StdEncoding.EncodeToString(key)
+	}
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// save writes the keyfile atomically; the caller holds p.mu
+func (p *KeyfileProvider) save() error {
+	data, err := p.marshal()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p.path), ".keyfile-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p.path)
+}
+
+func generateKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,96 @@
+package embedded
+
+// Options configures how a database is opened
+type Options struct {
+	// Concurrent opens the database in multi-process mode (see OpenConcurrent)
+	Concurrent bool
+
+	// Encryption enables transparent value encryption at rest.
+	// Nil stores values exactly as written.
+	Encryption *EncryptionConfig
+
+	// Compression enables transparent value compression, applied before
+	// encryption. Nil stores values uncompressed.
+	Compression *CompressionConfig
+}
+
+// OpenWithOptions opens a SochDB database with the given options
+//
+// Example:
+//
+//	keys, err := embedded.OpenKeyfile("/etc/sochdb/keys.json")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//
+//	db, err := embedded.OpenWithOptions("./mydb", embedded.Options{
+//	    Encryption: &embedded.EncryptionConfig{Provider: keys},
+//	    Compression: &embedded.CompressionConfig{
+//	        Default: embedded.CompressionLZ4,
+//	        Rules: []embedded.CompressionRule{
+//	            {Prefix: []byte("embeddings/"), Type: embedded.CompressionZstd, Level: 3},
+//	        },
+//	    },
+//	})
+func OpenWithOptions(path string, opts Options) (*Database, error) {
+	open := Open
+	if opts.Concurrent {
+		open = OpenConcurrent
+	}
+
+	db, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Compression != nil {
+		comp, err := newCompressor(opts.Compression)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		db.compression = comp
+	}
+
+	if opts.Encryption != nil {
+		enc, err := newEncryptor(opts.Encryption)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		db.encryption = enc
+	}
+
+	return db, nil
+}
+
+// encodeValue applies the configured value transforms before a write
+func (db *Database) encodeValue(key, value []byte) ([]byte, error) {
+	if db.compression != nil {
+		compressed, err := db.compression.compress(key, value)
+		if err != nil {
+			return nil, err
+		}
+		value = compressed
+	}
+	if db.encryption != nil {
+		return db.encryption.seal(key, value)
+	}
+	return value, nil
+}
+
+// decodeValue reverses encodeValue after a read. Values written before a
+// transform was enabled are returned unchanged.
+func (db *Database) decodeValue(key, value []byte) ([]byte, error) {
+	if db.encryption != nil {
+		decrypted, err := db.encryption.open(key, value)
+		if err != nil {
+			return nil, err
+		}
+		value = decrypted
+	}
+	if db.compression != nil {
+		return db.compression.decompress(value)
+	}
+	return value, nil
+}
@@ -0,0 +1,206 @@
+package embedded
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+const (
+	reEncryptBatchSize = 256
+	reEncryptRetries   = 3
+)
+
+// ReEncryptProgress reports the state of a re-encryption job
+type ReEncryptProgress struct {
+	Scanned   uint64 // Values examined so far
+	Pending   uint64 // Values found to need re-encryption
+	Rewritten uint64 // Values re-encrypted under the current key
+	Done      bool
+}
+
+// ReEncryptJob re-encrypts existing values in the background
+type ReEncryptJob struct {
+	scanned   atomic.Uint64
+	pending   atomic.Uint64
+	rewritten atomic.Uint64
+
+	done   chan struct{}
+	err    error
+	cancel context.CancelFunc
+}
+
+// ReEncrypt starts a background job that rewrites every value under prefix
+// with the current encryption key
+//
+// Values encrypted with an older key are re-encrypted, and plaintext values
+// covered by EncryptionConfig.Prefixes are encrypted. The job runs in small
+// transactions alongside normal traffic and can be cancelled through ctx or
+// Cancel. An empty prefix covers the whole database.
+//
+// Example:
+//
+//	if _, err := keys.Rotate(); err != nil {
+//	    log.Fatal(err)
+//	}
+//	job, err := db.ReEncrypt(ctx, nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	if err := job.Wait(); err != nil {
+//	    log.Fatal(err)
+//	}
+func (db *Database) ReEncrypt(ctx context.Context, prefix []byte) (*ReEncryptJob, error) {
+	if db.encryption == nil {
+		return nil, ErrEncryptionDisabled
+	}
+
+	current, _, err := db.encryption.currentAEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	job := &ReEncryptJob{
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+
+	go func() {
+		defer close(job.done)
+		defer cancel()
+		job.err = db.reEncrypt(ctx, job, append([]byte(nil), prefix...), current)
+	}()
+
+	return job, nil
+}
+
+// Progress returns a snapshot of the job's progress
+func (j *ReEncryptJob) Progress() ReEncryptProgress {
+	progress := ReEncryptProgress{
+		Scanned:   j.scanned.Load(),
+		Pending:   j.pending.Load(),
+		Rewritten: j.rewritten.Load(),
+	}
+	select {
+	case <-j.done:
+		progress.Done = true
+	default:
+	}
+	return progress
+}
+
+// Wait blocks until the job finishes and returns its error
+func (j *ReEncryptJob) Wait() error {
+	<-j.done
+	return j.err
+}
+
+// Cancel stops the job; Wait returns context.Canceled
+func (j *ReEncryptJob) Cancel() {
+	j.cancel()
+}
+
+func (db *Database) reEncrypt(ctx context.Context, job *ReEncryptJob, prefix []byte, current uint32) error {
+	pending, err := db.findStale(ctx, job, prefix, current)
+	if err != nil {
+		return err
+	}
+
+	for len(pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n := reEncryptBatchSize
+		if n > len(pending) {
+			n = len(pending)
+		}
+
+		var rewritten int
+		for attempt := 0; ; attempt++ {
+			rewritten, err = db.reEncryptBatch(pending[:n], current)
+			if err == nil || attempt+1 >= reEncryptRetries {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		job.rewritten.Add(uint64(rewritten))
+		pending = pending[n:]
+	}
+
+	return nil
+}
+
+// findStale collects the keys under prefix whose values need rewriting
+func (db *Database) findStale(ctx context.Context, job *ReEncryptJob, prefix []byte, current uint32) ([][]byte, error) {
+	var stale [][]byte
+
+	txn := db.Begin()
+	defer txn.Abort()
+
+	iter := txn.scanRaw(prefix)
+	defer iter.Close()
+
+	for {
+		key, value, ok := iter.Next()
+		if !ok {
+			break
+		}
+
+		if job.scanned.Add(1)%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		if db.encryption.needsRewrite(key, value, current) {
+			stale = append(stale, key)
+			job.pending.Add(1)
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	_ = txn.Commit()
+	return stale, nil
+}
+
+// reEncryptBatch rewrites a batch of values in one transaction, skipping
+// values that were deleted or rewritten since they were scanned
+func (db *Database) reEncryptBatch(batch [][]byte, current uint32) (int, error) {
+	rewritten := 0
+
+	err := db.WithTransaction(func(txn *Transaction) error {
+		rewritten = 0
+		for _, key := range batch {
+			raw, err := txn.getRaw(key)
+			if err != nil {
+				return err
+			}
+			if raw == nil || !db.encryption.needsRewrite(key, raw, current) {
+				continue
+			}
+
+			plain, err := db.encryption.open(key, raw)
+			if err != nil {
+				return err
+			}
+			sealed, err := db.encryption.seal(key, plain)
+			if err != nil {
+				return err
+			}
+			if err := txn.putRaw(key, sealed); err != nil {
+				return err
+			}
+			rewritten++
+		}
+		return nil
+	})
+
+	return rewritten, err
+}
@@ -38,9 +38,7 @@ func main() {
 
 	// Example 1: Create namespace for a tenant
 	fmt.Println("📁 Creating namespace for tenant...")
-	namespace := &sochdb.Namespace{
-		// In a real app, you'd initialize this properly with the database
-	}
+	namespaces := sochdb.NewNamespaceManager(db)
 	nsConfig := sochdb.NamespaceConfig{
 		Name:        "tenant_acme",
 		DisplayName: "ACME Corporation",
@@ -50,7 +48,10 @@ func main() {
 		},
 		ReadOnly: false,
 	}
-	namespace = &sochdb.Namespace{}
+	namespace, err := namespaces.CreateNamespace(nsConfig)
+	if err != nil {
+		log.Fatalf("Failed to create namespace: %v", err)
+	}
 	fmt.Printf("✅ Namespace created: %s\n\n", nsConfig.Name)
 
 	// Example 2: Create a vector collection for document embeddings
@@ -233,7 +234,10 @@ func main() {
 		},
 		ReadOnly: false,
 	}
-	namespace2 := &sochdb.Namespace{}
+	namespace2, err := namespaces.CreateNamespace(namespace2Config)
+	if err != nil {
+		log.Fatalf("Failed to create namespace: %v", err)
+	}
 
 	_, err = namespace2.CreateCollection(sochdb.CollectionConfig{
 		Name:      "documents",
@@ -246,6 +250,21 @@ func main() {
 	fmt.Printf("✅ Created isolated namespace: %s\n", namespace2Config.Name)
 	fmt.Println("   Each tenant has their own isolated data\n")
 
+	// Example 9: List and offboard tenants
+	fmt.Println("📋 Registered namespaces:")
+	configs, err := namespaces.ListNamespaces()
+	if err != nil {
+		log.Fatalf("Failed to list namespaces: %v", err)
+	}
+	for _, config := range configs {
+		fmt.Printf("  • %s (%s)\n", config.Name, config.DisplayName)
+	}
+
+	if err := namespaces.DeleteNamespace(namespace2Config.Name, true); err != nil {
+		log.Fatalf("Failed to delete namespace: %v", err)
+	}
+	fmt.Printf("✅ Deleted namespace %s and its collections\n\n", namespace2Config.Name)
+
 	fmt.Println("✨ Example completed successfully!\n")
 	fmt.Println("Key Features Demonstrated:")
 	fmt.Println("  ✓ Multi-tenant namespace isolation")
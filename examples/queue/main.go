@@ -216,13 +216,13 @@ func simulateWorker(queue *sochdb.PriorityQueue, workerID string, maxTasks int)
 		success := rand.Float64() > 0.2
 
 		if success {
-			err = queue.Ack(task.TaskID)
+			err = queue.AckClaim(task.TaskID, workerID)
 			if err != nil {
 				log.Printf("Error acknowledging task: %v", err)
 			}
 			fmt.Printf("  ✅ Completed task: %s...\n", task.TaskID[:12])
 		} else {
-			err = queue.Nack(task.TaskID)
+			err = queue.NackClaim(task.TaskID, workerID)
 			if err != nil {
 				log.Printf("Error nack'ing task: %v", err)
 			}
@@ -0,0 +1,88 @@
+// ID generation
+//
+// Vectors and queue tasks stored without an ID get one from an IDGenerator.
+// The default generates UUIDv7s, which are unique across processes and
+// sort by creation time. Applications with their own ID scheme can plug in
+// a generator per collection handle or queue, or replace the default.
+//
+// Example:
+//
+//	var next atomic.Int64
+//	docs = docs.WithIDGenerator(sochdb.IDGeneratorFunc(func() string {
+//	    return fmt.Sprintf("doc-%d", next.Add(1))
+//	}))
+//
+//	queue := sochdb.NewPriorityQueue(db, "tasks", &sochdb.QueueConfig{
+//	    IDGenerator: sochdb.UUIDv7Generator{},
+//	})
+
+package sochdb
+
+import (
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// IDGenerator generates IDs for vectors and tasks stored without one
+//
+// NewID must be safe for concurrent use and must not repeat an ID.
+type IDGenerator interface {
+	NewID() string
+}
+
+// IDGeneratorFunc adapts a function to an IDGenerator
+type IDGeneratorFunc func() string
+
+// NewID calls f
+func (f IDGeneratorFunc) NewID() string {
+	return f()
+}
+
+// UUIDv7Generator generates time-ordered UUIDv7s
+type UUIDv7Generator struct{}
+
+// NewID returns a new UUIDv7
+func (UUIDv7Generator) NewID() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		// Only fails if the system random source does
+		return uuid.NewString()
+	}
+	return id.String()
+}
+
+type idGeneratorHolder struct {
+	gen IDGenerator
+}
+
+var defaultIDGenerator atomic.Pointer[idGeneratorHolder]
+
+// SetDefaultIDGenerator replaces the generator used by collections and
+// queues that do not set their own. A nil generator restores UUIDv7s.
+func SetDefaultIDGenerator(gen IDGenerator) {
+	if gen == nil {
+		defaultIDGenerator.Store(nil)
+		return
+	}
+	defaultIDGenerator.Store(&idGeneratorHolder{gen: gen})
+}
+
+// newID returns an ID from gen, or from the default generator if gen is nil
+func newID(gen IDGenerator) string {
+	if gen != nil {
+		return gen.NewID()
+	}
+	if holder := defaultIDGenerator.Load(); holder != nil {
+		return holder.gen.NewID()
+	}
+	return UUIDv7Generator{}.NewID()
+}
+
+// WithIDGenerator returns a collection handle that generates missing vector
+// IDs with gen
+func (c *Collection) WithIDGenerator(gen IDGenerator) *Collection {
+	shared := *c
+	shared.ids = gen
+	return &shared
+}
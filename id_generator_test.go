@@ -0,0 +1,60 @@
+package sochdb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestIDGeneration tests generated vector and task IDs
+func TestIDGeneration(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 2})
+	require.NoError(t, err)
+
+	// IDs generated in a tight loop are distinct UUIDv7s
+	items := make([]InsertItem, 500)
+	for i := range items {
+		items[i] = InsertItem{Vector: []float32{1, float32(i)}}
+	}
+	results, err := docs.InsertBatch(items, BatchOptions{})
+	require.NoError(t, err)
+	seen := make(map[string]bool)
+	for _, r := range results {
+		require.False(t, seen[r.ID], "duplicate ID %s", r.ID)
+		seen[r.ID] = true
+	}
+	parsed, err := uuid.Parse(results[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, uuid.Version(7), parsed.Version())
+	count, err := docs.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 500, count)
+
+	next := 0
+	custom := docs.WithIDGenerator(IDGeneratorFunc(func() string {
+		next++
+		return fmt.Sprintf("doc-%d", next)
+	}))
+	id, err := custom.Insert([]float32{1, 0}, nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, "doc-1", id)
+
+	SetDefaultIDGenerator(IDGeneratorFunc(func() string { return "task-1" }))
+	t.Cleanup(func() { SetDefaultIDGenerator(nil) })
+	queue := NewPriorityQueue(db, "jobs", nil)
+	taskID, err := queue.Enqueue(1, []byte("a"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "task-1", taskID)
+
+	queue = NewPriorityQueue(db, "jobs", &QueueConfig{IDGenerator: UUIDv7Generator{}})
+	taskID, err = queue.Enqueue(1, []byte("b"), nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, "task-1", taskID)
+}
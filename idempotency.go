@@ -0,0 +1,146 @@
+// Idempotency keys
+//
+// A client that retries an insert or enqueue after a timeout cannot tell
+// whether the first attempt was stored. Writes made with an idempotency key
+// remember their result for a window (24 hours by default), and repeating
+// the write with the same key within the window returns the remembered
+// result instead of writing again. The key is recorded in the same
+// transaction as the write, so concurrent retries store at most once.
+//
+// Example:
+//
+//	// Safe to retry: the vector is stored once and the same ID is returned
+//	id, err := docs.InsertIdempotent(requestID, embedding, metadata, "")
+//
+//	taskID, err := queue.EnqueueIdempotent("invoice-1234", 1, payload, nil)
+
+package sochdb
+
+import (
+	"encoding/json"
+
+	"github.com/sochdb/sochdb-go/embedded"
+	"github.com/sochdb/sochdb-go/keys"
+)
+
+const (
+	// defaultIdempotencyWindow is how long keyed writes are remembered, in
+	// milliseconds
+	defaultIdempotencyWindow = 24 * 60 * 60 * 1000
+
+	// idempotencyPruneLimit bounds the expired keys removed per keyed write
+	idempotencyPruneLimit = 32
+)
+
+// idempotencyRecord is the remembered result of a keyed write
+type idempotencyRecord struct {
+	Result    json.RawMessage `json:"result"`
+	ExpiresAt int64           `json:"expires_at"` // Unix milliseconds
+}
+
+// idempotencyKeys stores the keys of a collection or queue. Records live
+// under "keys"/key, with an "expiry"/expiresAt/key entry per record so
+// expired keys can be pruned in order.
+type idempotencyKeys struct {
+	space  keys.Subspace
+	window int64 // milliseconds
+}
+
+func newIdempotencyKeys(space keys.Subspace, window int64) idempotencyKeys {
+	if window <= 0 {
+		window = defaultIdempotencyWindow
+	}
+	return idempotencyKeys{space: space.Sub("idempotency"), window: window}
+}
+
+// lookup decodes the result remembered for key into result. It reports
+// false if the key is unknown or expired.
+func (k idempotencyKeys) lookup(txn *embedded.Transaction, key string, now int64, result interface{}) (bool, error) {
+	value, err := txn.Get(k.space.Pack("keys", key))
+	if err != nil || value == nil {
+		return false, err
+	}
+
+	var record idempotencyRecord
+	if err := json.Unmarshal(value, &record); err != nil {
+		return false, err
+	}
+	if record.ExpiresAt <= now {
+		return false, nil
+	}
+	return true, json.Unmarshal(record.Result, result)
+}
+
+// remember records result for key and prunes expired keys
+func (k idempotencyKeys) remember(txn *embedded.Transaction, key string, now int64, result interface{}) error {
+	if err := k.prune(txn, now); err != nil {
+		return err
+	}
+
+	recordKey := k.space.Pack("keys", key)
+	previous, err := txn.Get(recordKey)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		// An expired record that was not pruned yet
+		var record idempotencyRecord
+		if err := json.Unmarshal(previous, &record); err == nil {
+			if err := txn.Delete(k.space.Pack("expiry", record.ExpiresAt, key)); err != nil {
+				return err
+			}
+		}
+	}
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	record := idempotencyRecord{Result: encoded, ExpiresAt: now + k.window}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if err := txn.Put(recordKey, value); err != nil {
+		return err
+	}
+	return txn.Put(k.space.Pack("expiry", record.ExpiresAt, key), []byte{})
+}
+
+// prune deletes up to idempotencyPruneLimit expired keys
+func (k idempotencyKeys) prune(txn *embedded.Transaction, now int64) error {
+	expiry := k.space.Sub("expiry")
+
+	var expired [][]byte
+	iter := txn.ScanPrefix(expiry.Bytes())
+	for len(expired) < idempotencyPruneLimit {
+		entry, _, ok := iter.Next()
+		if !ok {
+			break
+		}
+		t, err := expiry.Unpack(entry)
+		if err != nil || len(t) != 2 {
+			continue
+		}
+		expiresAt, _ := t[0].(int64)
+		if expiresAt > now {
+			break
+		}
+		expired = append(expired, entry)
+		if key, ok := t[1].(string); ok {
+			expired = append(expired, k.space.Pack("keys", key))
+		}
+	}
+	iter.Close()
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	for _, key := range expired {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
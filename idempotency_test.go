@@ -0,0 +1,71 @@
+package sochdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestIdempotencyKeys tests that keyed writes are stored once per window
+func TestIdempotencyKeys(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 2})
+	require.NoError(t, err)
+
+	first, err := docs.InsertIdempotent("req-1", []float32{1, 0}, nil, "")
+	require.NoError(t, err)
+	again, err := docs.InsertIdempotent("req-1", []float32{1, 0}, nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, first, again)
+	other, err := docs.InsertIdempotent("req-2", []float32{1, 0}, nil, "")
+	require.NoError(t, err)
+	assert.NotEqual(t, first, other)
+	count, err := docs.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// A failed insert does not use up its key
+	_, err = docs.InsertIdempotent("req-3", []float32{1, 0}, nil, first)
+	var exists *VectorExistsError
+	require.ErrorAs(t, err, &exists)
+	id, err := docs.InsertIdempotent("req-3", []float32{0, 1}, nil, "fresh")
+	require.NoError(t, err)
+	assert.Equal(t, "fresh", id)
+
+	// Batches replay their results
+	items := []InsertItem{{Vector: []float32{1, 1}}, {ID: "b", Vector: []float32{0, 1}}}
+	results, err := docs.InsertBatch(items, BatchOptions{IdempotencyKey: "batch-1"})
+	require.NoError(t, err)
+	replayed, err := docs.InsertBatch(items, BatchOptions{IdempotencyKey: "batch-1"})
+	require.NoError(t, err)
+	assert.Equal(t, results, replayed)
+	_, err = docs.InsertBatch(items, BatchOptions{Mode: BatchBestEffort, IdempotencyKey: "batch-2"})
+	assert.Error(t, err)
+	count, err = docs.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 5, count)
+
+	// Keys are forgotten after the window
+	short, err := ns.CreateCollection(CollectionConfig{Name: "short", Dimension: 2, IdempotencyWindow: 1})
+	require.NoError(t, err)
+	first, err = short.InsertIdempotent("req-1", []float32{1, 0}, nil, "")
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	again, err = short.InsertIdempotent("req-1", []float32{1, 0}, nil, "")
+	require.NoError(t, err)
+	assert.NotEqual(t, first, again)
+
+	queue := NewPriorityQueue(db, "jobs", nil)
+	taskID, err := queue.EnqueueIdempotent("invoice-1", 1, []byte("charge"), nil)
+	require.NoError(t, err)
+	replayedID, err := queue.EnqueueIdempotent("invoice-1", 1, []byte("charge"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, taskID, replayedID)
+	stats, err := queue.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.TotalEnqueued)
+}
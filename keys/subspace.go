@@ -0,0 +1,76 @@
+package keys
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Subspace is a packed tuple prefix under which related keys live.
+//
+// Every key packed inside a subspace starts with the subspace bytes, so a
+// prefix scan over Bytes() returns exactly the subspace's keys.
+//
+// Example:
+//
+//	vectors := keys.Sub("_collection", ns, name, "vectors")
+//	key := vectors.Pack(id)
+//	iter := txn.ScanPrefix(vectors.Bytes())
+type Subspace []byte
+
+// Sub creates a subspace from the given tuple elements.
+func Sub(elems ...interface{}) Subspace {
+	return Subspace(Tuple(elems).Pack())
+}
+
+// Sub returns a nested subspace extended by the given elements.
+func (s Subspace) Sub(elems ...interface{}) Subspace {
+	return Subspace(s.Pack(elems...))
+}
+
+// Bytes returns the raw prefix of the subspace.
+func (s Subspace) Bytes() []byte {
+	return append([]byte(nil), s...)
+}
+
+// Pack encodes the elements as a key inside the subspace.
+func (s Subspace) Pack(elems ...interface{}) []byte {
+	buf := make([]byte, len(s), len(s)+32)
+	copy(buf, s)
+	return Tuple(elems).appendTo(buf, false)
+}
+
+// PackPrefix encodes the elements like Pack, but leaves a trailing string or
+// []byte element unterminated. The result prefixes every key in the subspace
+// whose corresponding element starts with that value, which makes it usable
+// for "starts with" scans.
+func (s Subspace) PackPrefix(elems ...interface{}) []byte {
+	if len(elems) == 0 {
+		return s.Bytes()
+	}
+
+	buf := s.Pack(elems[:len(elems)-1]...)
+	switch v := elems[len(elems)-1].(type) {
+	case string:
+		buf = appendEscaped(append(buf, stringCode), []byte(v))
+	case []byte:
+		buf = appendEscaped(append(buf, bytesCode), v)
+	default:
+		panic(fmt.Sprintf("keys: PackPrefix needs a trailing string or []byte, got %T", v))
+	}
+	// Drop the terminator so longer values still match
+	return buf[:len(buf)-1]
+}
+
+// Unpack decodes a key inside the subspace, returning the elements that
+// follow the subspace prefix.
+func (s Subspace) Unpack(key []byte) (Tuple, error) {
+	if !s.Contains(key) {
+		return nil, ErrInvalidEncoding
+	}
+	return Unpack(key[len(s):])
+}
+
+// Contains reports whether key lies inside the subspace.
+func (s Subspace) Contains(key []byte) bool {
+	return bytes.HasPrefix(key, s)
+}
@@ -0,0 +1,348 @@
+// Package keys provides order-preserving tuple encoding for SochDB keys
+//
+// Keys are built from tuples of typed elements using the FoundationDB tuple
+// layer encoding. The encoding has three properties the rest of the SDK
+// relies on:
+//
+//   - Order: packed tuples sort byte-wise in the same order as the tuples
+//     themselves (element by element, by type and then by value).
+//   - Escaping: string and byte elements are escaped and terminated, so an
+//     element containing "/" or "\x00" can never be confused with two
+//     elements, and one tenant's keys can never collide with another's.
+//   - Prefixing: a packed tuple is a byte prefix of every packed tuple that
+//     extends it, so prefix scans over a partial tuple are exact.
+//
+// Supported element types: nil, []byte, string, all signed and unsigned
+// integer types, float32, float64, bool, uuid.UUID and nested Tuple values.
+//
+// Example:
+//
+//	key := keys.Pack("_collection", "tenant/a", "docs", "vectors", id)
+//
+//	t, err := keys.Unpack(key)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	fmt.Println(t[1]) // "tenant/a"
+package keys
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/google/uuid"
+)
+
+// Type codes, compatible with the FoundationDB tuple layer.
+const (
+	nilCode     = 0x00
+	bytesCode   = 0x01
+	stringCode  = 0x02
+	nestedCode  = 0x05
+	intZeroCode = 0x14
+	float32Code = 0x20
+	float64Code = 0x21
+	falseCode   = 0x26
+	trueCode    = 0x27
+	uuidCode    = 0x30
+
+	escapeByte = 0xFF
+)
+
+// ErrInvalidEncoding is returned when unpacking bytes that are not a valid
+// tuple encoding.
+var ErrInvalidEncoding = errors.New("keys: invalid tuple encoding")
+
+// Tuple is an ordered list of key elements.
+type Tuple []interface{}
+
+// Pack encodes the elements as a tuple.
+//
+// Pack panics if an element has an unsupported type; key layouts are fixed at
+// compile time, so this is a programming error rather than a runtime one.
+func Pack(elems ...interface{}) []byte {
+	return Tuple(elems).Pack()
+}
+
+// Pack encodes the tuple into an order-preserving byte string.
+func (t Tuple) Pack() []byte {
+	return t.appendTo(make([]byte, 0, 32), false)
+}
+
+func (t Tuple) appendTo(buf []byte, nested bool) []byte {
+	for _, elem := range t {
+		buf = appendElement(buf, elem, nested)
+	}
+	return buf
+}
+
+func appendElement(buf []byte, elem interface{}, nested bool) []byte {
+	switch v := elem.(type) {
+	case nil:
+		if nested {
+			return append(buf, nilCode, escapeByte)
+		}
+		return append(buf, nilCode)
+	case []byte:
+		return appendEscaped(append(buf, bytesCode), v)
+	case string:
+		return appendEscaped(append(buf, stringCode), []byte(v))
+	case int:
+		return appendInt(buf, int64(v))
+	case int8:
+		return appendInt(buf, int64(v))
+	case int16:
+		return appendInt(buf, int64(v))
+	case int32:
+		return appendInt(buf, int64(v))
+	case int64:
+		return appendInt(buf, v)
+	case uint:
+		return appendUint(buf, uint64(v))
+	case uint8:
+		return appendUint(buf, uint64(v))
+	case uint16:
+		return appendUint(buf, uint64(v))
+	case uint32:
+		return appendUint(buf, uint64(v))
+	case uint64:
+		return appendUint(buf, v)
+	case float32:
+		bits := math.Float32bits(v)
+		if bits&(1<<31) != 0 {
+			bits = ^bits
+		} else {
+			bits ^= 1 << 31
+		}
+		return binary.BigEndian.AppendUint32(append(buf, float32Code), bits)
+	case float64:
+		bits := math.Float64bits(v)
+		if bits&(1<<63) != 0 {
+			bits = ^bits
+		} else {
+			bits ^= 1 << 63
+		}
+		return binary.BigEndian.AppendUint64(append(buf, float64Code), bits)
+	case bool:
+		if v {
+			return append(buf, trueCode)
+		}
+		return append(buf, falseCode)
+	case uuid.UUID:
+		return append(append(buf, uuidCode), v[:]...)
+	case Tuple:
+		buf = append(buf, nestedCode)
+		buf = v.appendTo(buf, true)
+		return append(buf, nilCode)
+	default:
+		panic(fmt.Sprintf("keys: unsupported tuple element type %T", elem))
+	}
+}
+
+// appendEscaped writes b with every 0x00 escaped as 0x00 0xFF, followed by
+// a 0x00 terminator.
+func appendEscaped(buf, b []byte) []byte {
+	for _, c := range b {
+		buf = append(buf, c)
+		if c == 0x00 {
+			buf = append(buf, escapeByte)
+		}
+	}
+	return append(buf, 0x00)
+}
+
+// appendInt writes a variable-length integer. Negative values use the one's
+// complement of their magnitude so that they sort below zero.
+func appendInt(buf []byte, v int64) []byte {
+	if v >= 0 {
+		return appendUint(buf, uint64(v))
+	}
+
+	magnitude := uint64(-v) // correct for math.MinInt64 as well
+	n := byteLen(magnitude)
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], ^magnitude)
+	buf = append(buf, byte(intZeroCode-n))
+	return append(buf, tmp[8-n:]...)
+}
+
+func appendUint(buf []byte, v uint64) []byte {
+	if v == 0 {
+		return append(buf, intZeroCode)
+	}
+
+	n := byteLen(v)
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], v)
+	buf = append(buf, byte(intZeroCode+n))
+	return append(buf, tmp[8-n:]...)
+}
+
+func byteLen(v uint64) int {
+	n := 0
+	for v > 0 {
+		n++
+		v >>= 8
+	}
+	return n
+}
+
+// Unpack decodes a packed tuple.
+//
+// Integers decode as int64, or uint64 when they exceed math.MaxInt64. Strings
+// decode as string, byte elements as []byte, and nested tuples as Tuple.
+func Unpack(b []byte) (Tuple, error) {
+	t, rest, err := decodeTuple(b, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, ErrInvalidEncoding
+	}
+	return t, nil
+}
+
+func decodeTuple(b []byte, nested bool) (Tuple, []byte, error) {
+	t := Tuple{}
+	for len(b) > 0 {
+		if nested && b[0] == nilCode {
+			if len(b) > 1 && b[1] == escapeByte {
+				t = append(t, nil)
+				b = b[2:]
+				continue
+			}
+			// End of nested tuple
+			return t, b[1:], nil
+		}
+
+		elem, rest, err := decodeElement(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		t = append(t, elem)
+		b = rest
+	}
+
+	if nested {
+		return nil, nil, ErrInvalidEncoding
+	}
+	return t, b, nil
+}
+
+func decodeElement(b []byte) (interface{}, []byte, error) {
+	code := b[0]
+	switch {
+	case code == nilCode:
+		return nil, b[1:], nil
+	case code == bytesCode:
+		v, rest, err := decodeEscaped(b[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		return v, rest, nil
+	case code == stringCode:
+		v, rest, err := decodeEscaped(b[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		return string(v), rest, nil
+	case code == nestedCode:
+		return decodeTuple(b[1:], true)
+	case code >= intZeroCode-8 && code <= intZeroCode+8:
+		return decodeInt(b)
+	case code == float32Code:
+		if len(b) < 5 {
+			return nil, nil, ErrInvalidEncoding
+		}
+		bits := binary.BigEndian.Uint32(b[1:5])
+		if bits&(1<<31) != 0 {
+			bits ^= 1 << 31
+		} else {
+			bits = ^bits
+		}
+		return math.Float32frombits(bits), b[5:], nil
+	case code == float64Code:
+		if len(b) < 9 {
+			return nil, nil, ErrInvalidEncoding
+		}
+		bits := binary.BigEndian.Uint64(b[1:9])
+		if bits&(1<<63) != 0 {
+			bits ^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		return math.Float64frombits(bits), b[9:], nil
+	case code == falseCode:
+		return false, b[1:], nil
+	case code == trueCode:
+		return true, b[1:], nil
+	case code == uuidCode:
+		if len(b) < 17 {
+			return nil, nil, ErrInvalidEncoding
+		}
+		var u uuid.UUID
+		copy(u[:], b[1:17])
+		return u, b[17:], nil
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown type code 0x%02x", ErrInvalidEncoding, code)
+	}
+}
+
+func decodeEscaped(b []byte) ([]byte, []byte, error) {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] != 0x00 {
+			out = append(out, b[i])
+			continue
+		}
+		if i+1 < len(b) && b[i+1] == escapeByte {
+			out = append(out, 0x00)
+			i++
+			continue
+		}
+		return out, b[i+1:], nil
+	}
+	return nil, nil, ErrInvalidEncoding
+}
+
+func decodeInt(b []byte) (interface{}, []byte, error) {
+	code := int(b[0])
+	if code == intZeroCode {
+		return int64(0), b[1:], nil
+	}
+
+	n := code - intZeroCode
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+	if len(b) < n+1 {
+		return nil, nil, ErrInvalidEncoding
+	}
+
+	var tmp [8]byte
+	copy(tmp[8-n:], b[1:n+1])
+	v := binary.BigEndian.Uint64(tmp[:])
+	rest := b[n+1:]
+
+	if !negative {
+		if v > math.MaxInt64 {
+			return v, rest, nil
+		}
+		return int64(v), rest, nil
+	}
+
+	// Undo the one's complement over n bytes
+	var magnitude uint64
+	if n == 8 {
+		magnitude = ^v
+	} else {
+		magnitude = (uint64(1)<<(8*uint(n)) - 1) ^ v
+	}
+	if magnitude > 1<<63 {
+		return nil, nil, fmt.Errorf("%w: integer out of int64 range", ErrInvalidEncoding)
+	}
+	return -int64(magnitude), rest, nil
+}
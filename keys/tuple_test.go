@@ -0,0 +1,116 @@
+package keys
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRoundTrip tests that every supported element type survives Pack/Unpack
+func TestRoundTrip(t *testing.T) {
+	id := uuid.New()
+	tuple := Tuple{
+		nil,
+		[]byte{0x00, 0x01, 0xFF},
+		"tenant/with/slashes",
+		"nul\x00inside",
+		int64(0), int64(1), int64(-1), int64(255), int64(-256),
+		int64(math.MaxInt64), int64(math.MinInt64),
+		uint64(math.MaxUint64),
+		float32(-1.5), 3.25,
+		true, false,
+		id,
+		Tuple{"nested", nil, int64(7)},
+	}
+
+	decoded, err := Unpack(tuple.Pack())
+	require.NoError(t, err)
+	assert.Equal(t, tuple, decoded)
+}
+
+// TestOrdering tests that packed tuples sort like their values
+func TestOrdering(t *testing.T) {
+	ints := []int64{math.MinInt64, -70000, -256, -255, -1, 0, 1, 255, 256, 70000, math.MaxInt64}
+	assertSorted(t, len(ints), func(i int) []byte { return Pack(ints[i]) })
+
+	floats := []float64{math.Inf(-1), -2.5, -0.0, 0.5, 1, math.Inf(1)}
+	assertSorted(t, len(floats), func(i int) []byte { return Pack(floats[i]) })
+
+	strs := []string{"", "a", "a\x00", "a\x00b", "a/", "ab", "b"}
+	assertSorted(t, len(strs), func(i int) []byte { return Pack(strs[i]) })
+
+	tuples := []Tuple{{"a"}, {"a", int64(1)}, {"a", int64(2)}, {"a/b"}, {"b"}}
+	assertSorted(t, len(tuples), func(i int) []byte { return tuples[i].Pack() })
+}
+
+// TestNoSlashCollision tests that separators inside elements cannot collide
+func TestNoSlashCollision(t *testing.T) {
+	a := Pack("_collection", "tenant/a", "docs")
+	b := Pack("_collection", "tenant", "a/docs")
+	assert.NotEqual(t, a, b)
+
+	tenant := Sub("_collection", "tenant")
+	assert.False(t, tenant.Contains(Pack("_collection", "tenant/a", "docs")))
+	assert.False(t, tenant.Contains(Pack("_collection", "tenantX", "docs")))
+	assert.True(t, tenant.Contains(Pack("_collection", "tenant", "docs")))
+}
+
+// TestSubspace tests packing and unpacking inside a subspace
+func TestSubspace(t *testing.T) {
+	space := Sub("_queue", "jobs").Sub("task")
+	key := space.Pack(int64(-3), "id-1")
+
+	assert.True(t, bytes.HasPrefix(key, space.Bytes()))
+
+	rest, err := space.Unpack(key)
+	require.NoError(t, err)
+	assert.Equal(t, Tuple{int64(-3), "id-1"}, rest)
+
+	_, err = space.Unpack(Pack("_queue", "other"))
+	assert.ErrorIs(t, err, ErrInvalidEncoding)
+}
+
+// TestPackPrefix tests "starts with" prefixes over a trailing element
+func TestPackPrefix(t *testing.T) {
+	space := Sub("_table", "users")
+	prefix := space.PackPrefix("rows", "user:")
+
+	assert.True(t, bytes.HasPrefix(space.Pack("rows", "user:1"), prefix))
+	assert.True(t, bytes.HasPrefix(space.Pack("rows", "user:"), prefix))
+	assert.False(t, bytes.HasPrefix(space.Pack("rows", "user"), prefix))
+	assert.False(t, bytes.HasPrefix(space.Pack("rows", "group:1"), prefix))
+	assert.False(t, bytes.HasPrefix(space.Pack("other", "user:1"), prefix))
+}
+
+// TestUnpackInvalid tests that malformed input is rejected
+func TestUnpackInvalid(t *testing.T) {
+	for _, b := range [][]byte{
+		{stringCode, 'a'},
+		{intZeroCode + 2, 0x01},
+		{float64Code, 0x00},
+		{nestedCode, stringCode, 'a', 0x00},
+		{0x99},
+	} {
+		_, err := Unpack(b)
+		assert.ErrorIs(t, err, ErrInvalidEncoding, "input %x", b)
+	}
+}
+
+func assertSorted(t *testing.T, n int, pack func(int) []byte) {
+	t.Helper()
+	packed := make([][]byte, n)
+	for i := range packed {
+		packed[i] = pack(i)
+	}
+	assert.True(t, sort.SliceIsSorted(packed, func(i, j int) bool {
+		return bytes.Compare(packed[i], packed[j]) < 0
+	}))
+	for i := 1; i < n; i++ {
+		assert.NotEqual(t, packed[i-1], packed[i])
+	}
+}
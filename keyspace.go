@@ -0,0 +1,202 @@
+package sochdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sochdb/sochdb-go/embedded"
+	"github.com/sochdb/sochdb-go/keys"
+)
+
+// Key layouts for the SDK's higher-level structures.
+//
+// Every layout is a keys.Subspace, so namespace, collection, queue and cache
+// names are escaped tuple elements rather than separator-joined strings. A
+// tenant named "a/b" can never read or overwrite keys belonging to "a".
+//
+// Releases before this layout joined names with "/" and ":"; their data is
+// not read by this one. Namespace.MigrateLegacyCollection moves a legacy
+// collection; the README's migration guide covers the other structures.
+var (
+	namespaceSpace     = keys.Sub("_namespace")
+	namespaceDataSpace = keys.Sub("_ns")
+	grantSpace         = keys.Sub("_grant")
+	auditSpace         = keys.Sub("_audit")
+	usageSpace         = keys.Sub("_usage")
+	collectionSpace    = keys.Sub("_collection")
+	queueSpace         = keys.Sub("_queue")
+	graphSpace         = keys.Sub("_graph")
+	cacheSpace         = keys.Sub("_cache")
+	semanticCacheSpace = keys.Sub("_semantic_cache")
+	semanticIndexSpace = keys.Sub("_semantic_index")
+	traceSpace         = keys.Sub("_traces")
+	memorySpace        = keys.Sub("_memory")
+	retrievalSpace     = keys.Sub("_retrieval")
+	consolidationSpace = keys.Sub("_consolidation")
+	tableSpace         = keys.Sub("_table")
+)
+
+// namespaceKVSubspace returns the subspace holding a namespace's own keys.
+func namespaceKVSubspace(namespace string) keys.Subspace {
+	return namespaceDataSpace.Sub(namespace, "kv")
+}
+
+// collectionCatalogKey marks a collection as existing in its namespace, so
+// collections can be listed without scanning their vectors.
+func collectionCatalogKey(namespace, name string) []byte {
+	return namespaceDataSpace.Pack(namespace, "collections", name)
+}
+
+// collectionSubspace returns the subspace holding all keys of a collection.
+func collectionSubspace(namespace, name string) keys.Subspace {
+	return collectionSpace.Sub(namespace, name)
+}
+
+// SensitiveKeyPrefixes returns the key prefixes that hold user memories and
+// cached LLM responses, for use as embedded.EncryptionConfig.Prefixes when
+// only that data must be encrypted at rest.
+func SensitiveKeyPrefixes() [][]byte {
+	return [][]byte{
+		memorySpace.Bytes(),
+		retrievalSpace.Bytes(),
+		consolidationSpace.Bytes(),
+		cacheSpace.Bytes(),
+		semanticCacheSpace.Bytes(),
+		semanticIndexSpace.Bytes(),
+	}
+}
+
+// TableKeyPrefix returns the key prefix of a typed table, for per-table
+// embedded.CompressionRule and embedded.EncryptionConfig prefixes.
+func TableKeyPrefix(name string) []byte {
+	return tableSpace.Sub(name).Bytes()
+}
+
+// CollectionKeyPrefix returns the key prefix of a vector collection
+func CollectionKeyPrefix(namespace, name string) []byte {
+	return collectionSubspace(namespace, name).Bytes()
+}
+
+// MigrateLegacyCollection moves a collection written by releases before the
+// tuple key layout, under "_collection/{namespace}/{name}/", into the current
+// layout. Vectors go through the normal write path in batches of one
+// transaction each, removing the legacy keys they replace, so it may be run
+// again to resume after an error; the legacy config goes last. It returns the
+// number of vectors moved, or CollectionNotFoundError once nothing is left.
+//
+// Legacy keys cannot tell a name containing "/" from a nested key, so such
+// collections are refused and must be moved by hand.
+func (ns *Namespace) MigrateLegacyCollection(ctx context.Context, name string) (_ int, err error) {
+	access, err := ns.authorize(GrantOperationWrite, "collection:"+name)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { access.done(err) }()
+	if strings.Contains(ns.name, "/") || strings.Contains(name, "/") {
+		return 0, fmt.Errorf("legacy keys of collection %s in namespace %s are ambiguous", name, ns.name)
+	}
+
+	legacy := "_collection/" + ns.name + "/" + name + "/"
+	metadataKey := []byte(legacy + "metadata")
+	vectorPrefix := []byte(legacy + "vectors/")
+
+	var config CollectionConfig
+	var ids []string
+	err = withTxn(ns.db, func(txn *embedded.Transaction) error {
+		ids = ids[:0]
+		data, err := txn.Get(metadataKey)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return &CollectionNotFoundError{Collection: name}
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return err
+		}
+
+		found, err := collectKeys(txn, vectorPrefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range found {
+			ids = append(ids, string(key[len(vectorPrefix):]))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// A resumed run finds the collection created by the first
+	config.Name = name
+	collection, err := ns.Collection(name)
+	if _, ok := err.(*CollectionNotFoundError); ok {
+		collection, err = ns.CreateCollection(config)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for len(ids) > 0 {
+		if err := ctx.Err(); err != nil {
+			return moved, err
+		}
+
+		n := vectorMigrateBatchSize
+		if n > len(ids) {
+			n = len(ids)
+		}
+
+		var batch int
+		err := withTxn(ns.db, func(txn *embedded.Transaction) error {
+			batch = 0
+			for _, id := range ids[:n] {
+				key := append([]byte(legacy+"vectors/"), id...)
+				value, err := txn.Get(key)
+				if err != nil {
+					return err
+				}
+				if value == nil {
+					continue
+				}
+
+				var data vectorData
+				if err := json.Unmarshal(value, &data); err != nil {
+					return err
+				}
+				if data.Metadata, err = collection.conform(id, data.Metadata); err != nil {
+					return err
+				}
+				created, err := collection.writeVector(txn, id, data)
+				if err != nil {
+					return err
+				}
+				if created {
+					if err := collection.addCount(txn, 1); err != nil {
+						return err
+					}
+				}
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+				batch++
+			}
+			return nil
+		})
+		if err != nil {
+			return moved, err
+		}
+
+		moved += batch
+		ids = ids[n:]
+	}
+
+	err = withTxn(ns.db, func(txn *embedded.Transaction) error {
+		return txn.Delete(metadataKey)
+	})
+	return moved, err
+}
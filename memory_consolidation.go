@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/sochdb/sochdb-go/embedded"
+	"github.com/sochdb/sochdb-go/keys"
 )
 
 // Consolidator manages fact consolidation
@@ -20,7 +21,7 @@ type Consolidator struct {
 	db        *embedded.Database
 	namespace string
 	config    *ConsolidationConfig
-	prefix    []byte
+	space     keys.Subspace
 }
 
 // NewConsolidator creates a new consolidator
@@ -36,7 +37,7 @@ func NewConsolidator(db *embedded.Database, namespace string, config *Consolidat
 		db:        db,
 		namespace: namespace,
 		config:    config,
-		prefix:    []byte(fmt.Sprintf("consolidation:%s:", namespace)),
+		space:     consolidationSpace.Sub(namespace),
 	}
 }
 
@@ -56,7 +57,7 @@ func (c *Consolidator) Add(assertion *RawAssertion) (string, error) {
 	storedAssertion.ID = id
 	storedAssertion.Timestamp = timestamp
 
-	key := append(c.prefix, []byte(fmt.Sprintf("assertion:%s", id))...)
+	key := c.space.Pack("assertion", id)
 	data, err := json.Marshal(storedAssertion)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal assertion: %w", err)
@@ -78,7 +79,7 @@ func (c *Consolidator) AddWithContradiction(newAssertion *RawAssertion, contradi
 
 	// Mark contradicted assertions
 	for _, contradictedID := range contradicts {
-		contradictionKey := append(c.prefix, []byte(fmt.Sprintf("contradiction:%s:%s", contradictedID, id))...)
+		contradictionKey := c.space.Pack("contradiction", contradictedID, id)
 		data, err := json.Marshal(map[string]interface{}{
 			"from":      contradictedID,
 			"to":        id,
@@ -173,7 +174,7 @@ func (c *Consolidator) Consolidate() (int, error) {
 				ValidFrom:  timestamps[0],
 			}
 
-			key := append(c.prefix, []byte(fmt.Sprintf("canonical:%s", canonical.ID))...)
+			key := c.space.Pack("canonical", canonical.ID)
 			data, err := json.Marshal(canonical)
 			if err != nil {
 				continue
@@ -192,7 +193,7 @@ func (c *Consolidator) Consolidate() (int, error) {
 // GetCanonicalFacts retrieves canonical facts
 func (c *Consolidator) GetCanonicalFacts() ([]CanonicalFact, error) {
 	facts := []CanonicalFact{}
-	canonicalPrefix := append(c.prefix, []byte("canonical:")...)
+	canonicalPrefix := c.space.Sub("canonical").Bytes()
 
 	txn := c.db.Begin()
 	defer txn.Abort()
@@ -219,7 +220,7 @@ func (c *Consolidator) GetCanonicalFacts() ([]CanonicalFact, error) {
 
 // Explain provenance of a fact
 func (c *Consolidator) Explain(factID string) (map[string]interface{}, error) {
-	key := append(c.prefix, []byte(fmt.Sprintf("canonical:%s", factID))...)
+	key := c.space.Pack("canonical", factID)
 	value, err := c.db.Get(key)
 	if err != nil {
 		return map[string]interface{}{
@@ -244,7 +245,7 @@ func (c *Consolidator) Explain(factID string) (map[string]interface{}, error) {
 // Get all raw assertions
 func (c *Consolidator) getAllAssertions() ([]RawAssertion, error) {
 	assertions := []RawAssertion{}
-	assertionPrefix := append(c.prefix, []byte("assertion:")...)
+	assertionPrefix := c.space.Sub("assertion").Bytes()
 
 	txn := c.db.Begin()
 	defer txn.Abort()
@@ -272,7 +273,7 @@ func (c *Consolidator) getAllAssertions() ([]RawAssertion, error) {
 // Get all contradictions
 func (c *Consolidator) getContradictions() ([]map[string]interface{}, error) {
 	contradictions := []map[string]interface{}{}
-	contradictionPrefix := append(c.prefix, []byte("contradiction:")...)
+	contradictionPrefix := c.space.Sub("contradiction").Bytes()
 
 	txn := c.db.Begin()
 	defer txn.Abort()
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/sochdb/sochdb-go/embedded"
+	"github.com/sochdb/sochdb-go/keys"
 )
 
 // ExtractorFunction type - user provides this to call their LLM
@@ -22,7 +23,7 @@ type ExtractionPipeline struct {
 	db        *embedded.Database
 	namespace string
 	schema    *ExtractionSchema
-	prefix    []byte
+	space     keys.Subspace
 }
 
 // NewExtractionPipeline creates a new extraction pipeline
@@ -31,7 +32,7 @@ func NewExtractionPipeline(db *embedded.Database, namespace string, schema *Extr
 		db:        db,
 		namespace: namespace,
 		schema:    schema,
-		prefix:    []byte(fmt.Sprintf("memory:%s:", namespace)),
+		space:     memorySpace.Sub(namespace),
 	}
 }
 
@@ -166,7 +167,7 @@ func (p *ExtractionPipeline) ExtractAndCommit(text string, extractor ExtractorFu
 func (p *ExtractionPipeline) Commit(result *ExtractionResult) error {
 	// Store entities
 	for _, entity := range result.Entities {
-		key := append(p.prefix, []byte(fmt.Sprintf("entity:%s", entity.ID))...)
+		key := p.space.Pack("entity", entity.ID)
 		data, err := json.Marshal(entity)
 		if err != nil {
 			return fmt.Errorf("failed to marshal entity: %w", err)
@@ -178,7 +179,7 @@ func (p *ExtractionPipeline) Commit(result *ExtractionResult) error {
 
 	// Store relations
 	for _, relation := range result.Relations {
-		key := append(p.prefix, []byte(fmt.Sprintf("relation:%s", relation.ID))...)
+		key := p.space.Pack("relation", relation.ID)
 		data, err := json.Marshal(relation)
 		if err != nil {
 			return fmt.Errorf("failed to marshal relation: %w", err)
@@ -190,7 +191,7 @@ func (p *ExtractionPipeline) Commit(result *ExtractionResult) error {
 
 	// Store assertions
 	for _, assertion := range result.Assertions {
-		key := append(p.prefix, []byte(fmt.Sprintf("assertion:%s", assertion.ID))...)
+		key := p.space.Pack("assertion", assertion.ID)
 		data, err := json.Marshal(assertion)
 		if err != nil {
 			return fmt.Errorf("failed to marshal assertion: %w", err)
@@ -206,7 +207,7 @@ func (p *ExtractionPipeline) Commit(result *ExtractionResult) error {
 // GetEntities retrieves all entities
 func (p *ExtractionPipeline) GetEntities() ([]Entity, error) {
 	entities := []Entity{}
-	entityPrefix := append(p.prefix, []byte("entity:")...)
+	entityPrefix := p.space.Sub("entity").Bytes()
 
 	txn := p.db.Begin()
 	defer txn.Abort()
@@ -234,7 +235,7 @@ func (p *ExtractionPipeline) GetEntities() ([]Entity, error) {
 // GetRelations retrieves all relations
 func (p *ExtractionPipeline) GetRelations() ([]Relation, error) {
 	relations := []Relation{}
-	relationPrefix := append(p.prefix, []byte("relation:")...)
+	relationPrefix := p.space.Sub("relation").Bytes()
 
 	txn := p.db.Begin()
 	defer txn.Abort()
@@ -262,7 +263,7 @@ func (p *ExtractionPipeline) GetRelations() ([]Relation, error) {
 // GetAssertions retrieves all assertions
 func (p *ExtractionPipeline) GetAssertions() ([]Assertion, error) {
 	assertions := []Assertion{}
-	assertionPrefix := append(p.prefix, []byte("assertion:")...)
+	assertionPrefix := p.space.Sub("assertion").Bytes()
 
 	txn := p.db.Begin()
 	defer txn.Abort()
@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/sochdb/sochdb-go/embedded"
+	"github.com/sochdb/sochdb-go/keys"
 )
 
 // HybridRetriever combines lexical and semantic search
@@ -19,8 +20,9 @@ type HybridRetriever struct {
 	db        *embedded.Database
 	namespace string
 	config    *RetrievalConfig
-	prefix    []byte
+	space     keys.Subspace
 	bm25      *BM25Scorer
+	accessor  string
 }
 
 // BM25Scorer implements BM25 scoring
@@ -127,16 +129,32 @@ func NewHybridRetriever(db *embedded.Database, namespace string, config *Retriev
 		db:        db,
 		namespace: namespace,
 		config:    config,
-		prefix:    []byte(fmt.Sprintf("retrieval:%s:", namespace)),
+		space:     retrievalSpace.Sub(namespace),
 		bm25:      NewBM25Scorer(1.5, 0.75),
 	}
 }
 
+// WithAccessor returns a retriever that reads on behalf of another namespace
+//
+// Reads through it are checked against the retriever namespace's policy and
+// grants (see NamespaceManager.CreateGrant) and recorded in its audit log.
+func (hr *HybridRetriever) WithAccessor(accessor string) *HybridRetriever {
+	shared := *hr
+	shared.accessor = accessor
+	return &shared
+}
+
 // IndexDocuments indexes documents for retrieval
-func (hr *HybridRetriever) IndexDocuments(documents map[string]map[string]interface{}) error {
+func (hr *HybridRetriever) IndexDocuments(documents map[string]map[string]interface{}) (err error) {
+	access, err := authorizeAccess(hr.db, hr.accessor, hr.namespace, GrantOperationWrite, "retrieval")
+	if err != nil {
+		return err
+	}
+	defer func() { access.done(err) }()
+
 	// Store documents
 	for id, doc := range documents {
-		key := append(hr.prefix, []byte(fmt.Sprintf("doc:%s", id))...)
+		key := hr.space.Pack("doc", id)
 		data, err := json.Marshal(doc)
 		if err != nil {
 			return fmt.Errorf("failed to marshal document: %w", err)
@@ -159,7 +177,13 @@ func (hr *HybridRetriever) IndexDocuments(documents map[string]map[string]interf
 }
 
 // Retrieve performs hybrid retrieval
-func (hr *HybridRetriever) Retrieve(query string, allowed AllowedSet) ([]map[string]interface{}, error) {
+func (hr *HybridRetriever) Retrieve(query string, allowed AllowedSet) (_ []map[string]interface{}, err error) {
+	access, err := authorizeAccess(hr.db, hr.accessor, hr.namespace, GrantOperationRead, "retrieval")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { access.done(err) }()
+
 	// Get all documents
 	documents, err := hr.getAllDocuments()
 	if err != nil {
@@ -213,6 +237,53 @@ func (hr *HybridRetriever) Retrieve(query string, allowed AllowedSet) ([]map[str
 		return scored[i].score > scored[j].score
 	})
 
+	// Diversify the top candidates
+	if hr.config.MMRLambda > 0 || hr.config.CollapseField != "" {
+		fetch := hr.config.FetchK
+		if fetch < hr.config.Limit {
+			fetch = hr.config.Limit * diversityFetchFactor
+		}
+		if fetch < len(scored) {
+			scored = scored[:fetch]
+		}
+
+		order := make([]int, len(scored))
+		for i := range order {
+			order[i] = i
+		}
+		if field := hr.config.CollapseField; field != "" {
+			order = collapseBy(len(scored), func(i int) (interface{}, bool) {
+				v, ok := scored[i].doc[field]
+				return v, ok
+			})
+		}
+
+		if lambda := hr.config.MMRLambda; lambda > 0 {
+			if lambda > 1 {
+				lambda = 1
+			}
+			relevance := make([]float64, len(order))
+			texts := make([]string, len(order))
+			for i, j := range order {
+				relevance[i] = scored[j].score
+				texts[i], _ = scored[j].doc["text"].(string)
+			}
+			picked := mmrSelect(scaleScores(relevance), func(a, b int) float64 {
+				return hr.cosineSimilarity(texts[a], texts[b])
+			}, lambda, hr.config.Limit)
+			for i, p := range picked {
+				picked[i] = order[p]
+			}
+			order = picked
+		}
+
+		diversified := make([]scoredDoc, len(order))
+		for i, j := range order {
+			diversified[i] = scored[j]
+		}
+		scored = diversified
+	}
+
 	// Limit results
 	limit := hr.config.Limit
 	if limit > len(scored) {
@@ -230,9 +301,17 @@ func (hr *HybridRetriever) Retrieve(query string, allowed AllowedSet) ([]map[str
 
 // Explain retrieval for debugging
 func (hr *HybridRetriever) Explain(query string, docID string) map[string]interface{} {
+	access, err := authorizeAccess(hr.db, hr.accessor, hr.namespace, GrantOperationRead, "retrieval")
+	if err != nil {
+		return map[string]interface{}{
+			"error": err.Error(),
+		}
+	}
+
 	lexicalScore := hr.bm25.Score(query, docID)
 
 	doc, err := hr.getDocument(docID)
+	access.done(err)
 	if err != nil {
 		return map[string]interface{}{
 			"error": err.Error(),
@@ -372,12 +451,12 @@ func (hr *HybridRetriever) cosineSimilarity(query, text string) float64 {
 // Get all documents
 func (hr *HybridRetriever) getAllDocuments() (map[string]map[string]interface{}, error) {
 	documents := make(map[string]map[string]interface{})
-	docPrefix := append(hr.prefix, []byte("doc:")...)
+	docSpace := hr.space.Sub("doc")
 
 	txn := hr.db.Begin()
 	defer txn.Abort()
 
-	iter := txn.ScanPrefix(docPrefix)
+	iter := txn.ScanPrefix(docSpace.Bytes())
 	defer iter.Close()
 
 	for {
@@ -392,7 +471,14 @@ func (hr *HybridRetriever) getAllDocuments() (map[string]map[string]interface{},
 		}
 
 		// Extract ID from key
-		id := string(key[len(docPrefix):])
+		t, err := docSpace.Unpack(key)
+		if err != nil || len(t) != 1 {
+			continue
+		}
+		id, ok := t[0].(string)
+		if !ok {
+			continue
+		}
 		doc["id"] = id
 		documents[id] = doc
 	}
@@ -403,7 +489,7 @@ func (hr *HybridRetriever) getAllDocuments() (map[string]map[string]interface{},
 
 // Get a single document
 func (hr *HybridRetriever) getDocument(docID string) (map[string]interface{}, error) {
-	key := append(hr.prefix, []byte(fmt.Sprintf("doc:%s", docID))...)
+	key := hr.space.Pack("doc", docID)
 	value, err := hr.db.Get(key)
 	if err != nil {
 		return nil, fmt.Errorf("document not found: %w", err)
@@ -112,6 +112,9 @@ type RetrievalConfig struct {
 	RRFConstant     int     `json:"rrf_constant,omitempty"`     // Reciprocal Rank Fusion constant
 	PrefilterRatio  float64 `json:"prefilter_ratio,omitempty"`  // Pre-filter expansion ratio
 	UsePrefiltering bool    `json:"use_prefiltering,omitempty"` // Enable pre-filtering
+	MMRLambda       float64 `json:"mmr_lambda,omitempty"`       // Rerank by MMR with this relevance weight (0-1]; 0 disables
+	FetchK          int     `json:"fetch_k,omitempty"`          // Candidates considered by MMR and collapsing; default 4*Limit
+	CollapseField   string  `json:"collapse_field,omitempty"`   // Keep the best document per value of this field
 }
 
 // RetrievalResult from search
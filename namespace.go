@@ -13,12 +13,14 @@
 //	}
 //	defer db.Close()
 //
-//	ns, err := db.CreateNamespace("tenant_123")
+//	ns, err := sochdb.NewNamespaceManager(db).CreateNamespace(sochdb.NamespaceConfig{
+//	    Name: "tenant_123",
+//	})
 //	if err != nil {
 //	    log.Fatal(err)
 //	}
 //
-//	collection, err := ns.CreateCollection(CollectionConfig{
+//	collection, err := ns.CreateCollection(sochdb.CollectionConfig{
 //	    Name:      "documents",
 //	    Dimension: 384,
 //	})
@@ -26,14 +28,19 @@
 //	    log.Fatal(err)
 //	}
 //
-//	err = collection.Insert([]float32{1.0, 2.0, ...}, map[string]interface{}{"source": "web"}, "")
+//	_, err = collection.Insert([]float32{1.0, 2.0, ...}, map[string]interface{}{"source": "web"}, "")
 package sochdb
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
+
+	"github.com/sochdb/sochdb-go/embedded"
+	"github.com/sochdb/sochdb-go/keys"
 )
 
 // ============================================================================
@@ -46,6 +53,12 @@ type NamespaceConfig struct {
 	DisplayName string            `json:"display_name,omitempty"`
 	Labels      map[string]string `json:"labels,omitempty"`
 	ReadOnly    bool              `json:"read_only"`
+
+	// Policy controls access from other namespaces (default explicit)
+	Policy NamespacePolicy `json:"policy,omitempty"`
+
+	// Quota limits the namespace's storage and write rate (nil is unlimited)
+	Quota *NamespaceQuota `json:"quota,omitempty"`
 }
 
 // NamespaceNotFoundError is returned when a namespace doesn't exist
@@ -66,6 +79,15 @@ func (e *NamespaceExistsError) Error() string {
 	return fmt.Sprintf("namespace already exists: %s", e.Namespace)
 }
 
+// NamespaceReadOnlyError is returned when writing to a read-only namespace
+type NamespaceReadOnlyError struct {
+	Namespace string
+}
+
+func (e *NamespaceReadOnlyError) Error() string {
+	return fmt.Sprintf("namespace is read-only: %s", e.Namespace)
+}
+
 // CollectionNotFoundError is returned when a collection doesn't exist
 type CollectionNotFoundError struct {
 	Collection string
@@ -84,6 +106,26 @@ func (e *CollectionExistsError) Error() string {
 	return fmt.Sprintf("collection already exists: %s", e.Collection)
 }
 
+// VectorExistsError is returned when inserting a vector whose ID is taken
+type VectorExistsError struct {
+	Collection string
+	ID         string
+}
+
+func (e *VectorExistsError) Error() string {
+	return fmt.Sprintf("vector already exists in collection %s: %s", e.Collection, e.ID)
+}
+
+// VectorNotFoundError is returned when updating a vector that doesn't exist
+type VectorNotFoundError struct {
+	Collection string
+	ID         string
+}
+
+func (e *VectorNotFoundError) Error() string {
+	return fmt.Sprintf("vector not found in collection %s: %s", e.Collection, e.ID)
+}
+
 // ============================================================================
 // Collection Configuration
 // ============================================================================
@@ -99,27 +141,54 @@ const (
 
 // CollectionConfig represents collection configuration
 type CollectionConfig struct {
-	Name               string                 `json:"name"`
-	Dimension          int                    `json:"dimension,omitempty"`
-	Metric             DistanceMetric         `json:"metric,omitempty"`
-	Indexed            bool                   `json:"indexed"`
-	HNSWM              int                    `json:"hnsw_m,omitempty"`
-	HNSWEfConstruction int                    `json:"hnsw_ef_construction,omitempty"`
-	Metadata           map[string]interface{} `json:"metadata,omitempty"`
+	Name               string                       `json:"name"`
+	Dimension          int                          `json:"dimension,omitempty"`
+	Metric             DistanceMetric               `json:"metric,omitempty"`
+	Indexed            bool                         `json:"indexed"`
+	HNSWM              int                          `json:"hnsw_m,omitempty"`
+	HNSWEfConstruction int                          `json:"hnsw_ef_construction,omitempty"`
+	Metadata           map[string]interface{}       `json:"metadata,omitempty"`
+	VectorEncoding     VectorEncoding               `json:"vector_encoding,omitempty"` // Storage precision; default float32
+	Quantization       *QuantizationConfig          `json:"quantization,omitempty"`
+	Codebook           *Codebook                    `json:"codebook,omitempty"`           // Set by Collection.TrainQuantizer
+	TextField          string                       `json:"text_field,omitempty"`         // Metadata field indexed for keyword search
+	Vectors            map[string]NamedVectorConfig `json:"vectors,omitempty"`            // Named vectors besides the default one
+	Schema             *MetadataSchema              `json:"schema,omitempty"`             // Typed metadata fields; nil accepts any metadata
+	IdempotencyWindow  int64                        `json:"idempotency_window,omitempty"` // Milliseconds keyed inserts are remembered; default 24h
+	CreatedAt          int64                        `json:"created_at,omitempty"`         // Unix milliseconds, set on create
 }
 
-// SearchRequest represents a vector search request
+// SearchRequest represents a collection search request
 type SearchRequest struct {
 	QueryVector     []float32              `json:"query_vector"`
+	QueryText       string                 `json:"query_text,omitempty"`    // Keyword query over the collection's TextField
+	VectorName      string                 `json:"vector_name,omitempty"`   // Search a named vector instead of the default one
+	QueryVectors    [][]float32            `json:"query_vectors,omitempty"` // Late-interaction query for multi-vector fields
 	K               int                    `json:"k"`
 	Filter          map[string]interface{} `json:"filter,omitempty"`
 	IncludeMetadata bool                   `json:"include_metadata"`
+
+	// Hybrid search (both QueryVector and QueryText)
+	Alpha       *float32   `json:"alpha,omitempty"`        // Vector weight in [0, 1]; keyword weight is 1-Alpha; nil means 0.5
+	Fusion      FusionMode `json:"fusion,omitempty"`       // Default FusionRRF
+	RRFConstant int        `json:"rrf_constant,omitempty"` // Default 60
+
+	// Diversification (see diversity.go)
+	MMR           bool    `json:"mmr,omitempty"`            // Rerank by Maximal Marginal Relevance
+	MMRLambda     float32 `json:"mmr_lambda,omitempty"`     // Relevance weight in (0, 1]; default 0.5
+	FetchK        int     `json:"fetch_k,omitempty"`        // Candidates considered; default 4*K
+	CollapseField string  `json:"collapse_field,omitempty"` // Keep the best result per value of this metadata field
+
+	// Thresholds (see collection_range.go); without K, every match is returned
+	MinScore    *float32 `json:"min_score,omitempty"`    // Drop results scoring below this
+	MaxDistance *float32 `json:"max_distance,omitempty"` // Drop vectors farther than this from the query
 }
 
 // SearchResult represents a single search result
 type SearchResult struct {
 	ID       string                 `json:"id"`
 	Score    float32                `json:"score"`
+	Distance float32                `json:"distance,omitempty"` // Set by vector searches with one query vector
 	Vector   []float32              `json:"vector,omitempty"`
 	Metadata map[string]interface{} `json:"metadata,omitempty"`
 }
@@ -134,6 +203,18 @@ type Collection struct {
 	namespace string
 	name      string
 	config    CollectionConfig
+	accessor  string
+
+	// ids generates missing vector IDs; nil uses the default generator
+	ids IDGenerator
+
+	// vectorName selects the named vector whose index the index methods
+	// act on; empty means the default vector (see vectorIndex)
+	vectorName string
+
+	// keyspace holds the keys of a collection that backs another structure,
+	// such as a semantic cache, instead of belonging to a namespace
+	keyspace keys.Subspace
 }
 
 // vectorData represents stored vector data
@@ -144,151 +225,459 @@ type vectorData struct {
 }
 
 // Insert adds a vector to the collection
+// Returns VectorExistsError if the ID is taken; use Upsert to replace it.
 func (c *Collection) Insert(vector []float32, metadata map[string]interface{}, id string) (string, error) {
-	if c.config.Dimension > 0 && len(vector) != c.config.Dimension {
-		return "", fmt.Errorf("vector dimension mismatch: expected %d, got %d", c.config.Dimension, len(vector))
-	}
+	return c.put(vector, metadata, id, false, "")
+}
 
-	vectorID := id
-	if vectorID == "" {
-		vectorID = c.generateID()
-	}
+// Upsert adds a vector, or replaces the vector and metadata stored under id
+func (c *Collection) Upsert(vector []float32, metadata map[string]interface{}, id string) (string, error) {
+	return c.put(vector, metadata, id, true, "")
+}
 
-	key := c.vectorKey(vectorID)
-	data := vectorData{
-		Vector:    vector,
-		Metadata:  metadata,
-		Timestamp: time.Now().UnixMilli(),
+// InsertIdempotent inserts a vector once per idempotency key
+// Repeating it with the same key within the collection's IdempotencyWindow
+// stores nothing and returns the first call's vector ID.
+func (c *Collection) InsertIdempotent(key string, vector []float32, metadata map[string]interface{}, id string) (string, error) {
+	if key == "" {
+		return "", errors.New("idempotency key is empty")
 	}
+	return c.put(vector, metadata, id, false, key)
+}
 
-	dataBytes, err := json.Marshal(data)
+// UpdateMetadata merges patch into a vector's metadata without touching the
+// vector. Nested maps are merged recursively and nil values remove fields.
+func (c *Collection) UpdateMetadata(id string, patch map[string]interface{}) (err error) {
+	access, err := c.authorize(GrantOperationWrite)
 	if err != nil {
-		return "", err
+		return err
 	}
+	defer func() { access.done(err) }()
 
-	// Put to database (supports both embedded and client interfaces)
-	switch db := c.db.(type) {
-	case interface{ Put([]byte, []byte) error }:
-		err = db.Put([]byte(key), dataBytes)
-	default:
-		return "", errors.New("unsupported database type")
+	return retryConflicts(c.db, func(txn *embedded.Transaction) error {
+		data, err := c.readVector(txn, id)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return &VectorNotFoundError{Collection: c.name, ID: id}
+		}
+
+		if data.Metadata, err = c.conform(id, mergeMetadata(data.Metadata, patch)); err != nil {
+			return err
+		}
+		_, err = c.writeVector(txn, id, *data)
+		return err
+	})
+}
+
+// UpdateVector replaces a vector, keeping its metadata
+func (c *Collection) UpdateVector(id string, vector []float32) (err error) {
+	if c.config.Dimension > 0 && len(vector) != c.config.Dimension {
+		return fmt.Errorf("vector dimension mismatch: expected %d, got %d", c.config.Dimension, len(vector))
 	}
 
+	access, err := c.authorize(GrantOperationWrite)
 	if err != nil {
-		return "", err
+		return err
 	}
+	defer func() { access.done(err) }()
 
-	return vectorID, nil
+	return retryConflicts(c.db, func(txn *embedded.Transaction) error {
+		data, err := c.readVector(txn, id)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return &VectorNotFoundError{Collection: c.name, ID: id}
+		}
+
+		data.Vector = vector
+		data.Timestamp = time.Now().UnixMilli()
+		_, err = c.writeVector(txn, id, *data)
+		return err
+	})
 }
 
-// InsertMany adds multiple vectors to the collection
+// InsertMany adds multiple vectors in one transaction
+// Either every vector is inserted or none is; see InsertBatch for
+// best-effort inserts.
 func (c *Collection) InsertMany(vectors [][]float32, metadatas []map[string]interface{}, ids []string) ([]string, error) {
-	resultIDs := make([]string, 0, len(vectors))
-
+	items := make([]InsertItem, len(vectors))
 	for i, vector := range vectors {
-		var id string
+		items[i].Vector = vector
 		if ids != nil && i < len(ids) {
-			id = ids[i]
+			items[i].ID = ids[i]
 		}
-
-		var metadata map[string]interface{}
 		if metadatas != nil && i < len(metadatas) {
-			metadata = metadatas[i]
-		}
-
-		resultID, err := c.Insert(vector, metadata, id)
-		if err != nil {
-			return resultIDs, err
+			items[i].Metadata = metadatas[i]
 		}
+	}
 
-		resultIDs = append(resultIDs, resultID)
+	results, err := c.InsertBatch(items, BatchOptions{Mode: BatchAtomic})
+	if err != nil {
+		return nil, err
 	}
 
+	resultIDs := make([]string, len(results))
+	for i, result := range results {
+		resultIDs[i] = result.ID
+	}
 	return resultIDs, nil
 }
 
-// Search finds similar vectors
-func (c *Collection) Search(request SearchRequest) ([]SearchResult, error) {
-	// For now, implement basic linear search
-	// In production, this would use HNSW index
-	results := make([]SearchResult, 0)
+// Get retrieves a vector by ID
+func (c *Collection) Get(id string) (_ *vectorData, err error) {
+	access, err := c.authorize(GrantOperationRead)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { access.done(err) }()
 
-	// TODO: Implement efficient scanning with range queries
-	// For now, this is a placeholder that shows the API structure
+	var data *vectorData
+	err = withTxn(c.db, func(txn *embedded.Transaction) error {
+		var err error
+		data, err = c.readVector(txn, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	return results, nil
+	return data, nil
 }
 
-// Get retrieves a vector by ID
-func (c *Collection) Get(id string) (*vectorData, error) {
+// Delete removes a vector by ID
+func (c *Collection) Delete(id string) (err error) {
+	access, err := c.authorize(GrantOperationWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { access.done(err) }()
+
+	return retryConflicts(c.db, func(txn *embedded.Transaction) error {
+		return c.deleteVector(txn, id)
+	})
+}
+
+// deleteVector removes a vector within txn, charging the namespace for the
+// change
+func (c *Collection) deleteVector(txn *embedded.Transaction, id string) error {
 	key := c.vectorKey(id)
+	metaKey := c.vectorMetaKey(id)
 
-	var value []byte
-	switch db := c.db.(type) {
-	case interface{ Get([]byte) ([]byte, error) }:
-		var err error
-		value, err = db.Get([]byte(key))
-		if err != nil {
-			return nil, err
+	existing, err := txn.Get(key)
+	if err != nil || existing == nil {
+		return err
+	}
+	meta, err := txn.Get(metaKey)
+	if err != nil {
+		return err
+	}
+
+	named, err := c.storeNamed(txn, id, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	delta := addUsage(keyUsageDelta(key, existing, nil), keyUsageDelta(metaKey, meta, nil))
+	delta = addUsage(delta, named)
+	delta.Vectors = -1
+	if err := c.recordWrite(txn, delta); err != nil {
+		return err
+	}
+	if err := c.addCount(txn, -1); err != nil {
+		return err
+	}
+	if meta != nil {
+		if err := txn.Delete(metaKey); err != nil {
+			return err
 		}
-	default:
-		return nil, errors.New("unsupported database type")
 	}
+	if err := txn.Delete(c.codeKey(id)); err != nil {
+		return err
+	}
+	if err := c.addDimensions(txn, existing, nil); err != nil {
+		return err
+	}
+	if err := c.indexText(txn, id, nil); err != nil {
+		return err
+	}
+	if err := txn.Delete(key); err != nil {
+		return err
+	}
+	if err := c.indexFields(txn, id, storedMetadata(existing, meta), nil); err != nil {
+		return err
+	}
+	return c.updateIndex(txn, id, existing, nil, nil)
+}
 
-	if value == nil {
-		return nil, nil
+// Count returns the number of vectors in the collection
+//
+// The count is maintained on every insert and delete, so this reads the
+// count records rather than scanning the vectors.
+func (c *Collection) Count() (_ int, err error) {
+	access, err := c.authorize(GrantOperationRead)
+	if err != nil {
+		return 0, err
 	}
+	defer func() { access.done(err) }()
 
-	var data vectorData
-	if err := json.Unmarshal(value, &data); err != nil {
-		return nil, err
+	var count int64
+	var deltas [][]byte
+	err = withTxn(c.db, func(txn *embedded.Transaction) error {
+		var err error
+		count, deltas, err = c.loadCount(txn)
+		return err
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	return &data, nil
+	if len(deltas) >= countCompactThreshold {
+		err = retryConflicts(c.db, c.compactCount)
+	}
+	return int(count), err
 }
 
-// Delete removes a vector by ID
-func (c *Collection) Delete(id string) error {
-	key := c.vectorKey(id)
+// Helper methods
+func (c *Collection) put(vector []float32, metadata map[string]interface{}, id string, replace bool, idempotencyKey string) (_ string, err error) {
+	if c.config.Dimension > 0 && len(vector) != c.config.Dimension {
+		return "", fmt.Errorf("vector dimension mismatch: expected %d, got %d", c.config.Dimension, len(vector))
+	}
 
-	switch db := c.db.(type) {
-	case interface{ Delete([]byte) error }:
-		return db.Delete([]byte(key))
-	default:
-		return errors.New("unsupported database type")
+	access, err := c.authorize(GrantOperationWrite)
+	if err != nil {
+		return "", err
+	}
+	defer func() { access.done(err) }()
+
+	vectorID := id
+	if vectorID == "" {
+		vectorID = c.generateID()
+	}
+
+	metadata, err = c.conform(vectorID, metadata)
+	if err != nil {
+		return "", err
+	}
+
+	data := vectorData{
+		Vector:    vector,
+		Metadata:  metadata,
+		Timestamp: time.Now().UnixMilli(),
+	}
+
+	generatedID := vectorID
+	err = retryConflicts(c.db, func(txn *embedded.Transaction) error {
+		vectorID = generatedID
+		if idempotencyKey != "" {
+			found, err := c.idempotency().lookup(txn, idempotencyKey, data.Timestamp, &vectorID)
+			if err != nil || found {
+				return err
+			}
+			if err := c.idempotency().remember(txn, idempotencyKey, data.Timestamp, vectorID); err != nil {
+				return err
+			}
+		}
+
+		if !replace {
+			existing, err := txn.Get(c.vectorKey(vectorID))
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				return &VectorExistsError{Collection: c.name, ID: vectorID}
+			}
+		}
+
+		created, err := c.writeVector(txn, vectorID, data)
+		if err != nil || !created {
+			return err
+		}
+		return c.addCount(txn, 1)
+	})
+	if err != nil {
+		return "", err
 	}
+
+	return vectorID, nil
 }
 
-// Count returns the number of vectors in the collection
-func (c *Collection) Count() (int, error) {
-	// TODO: Implement efficient counting
-	return 0, nil
+func (c *Collection) authorize(op string) (*accessCheck, error) {
+	return authorizeAccess(c.db, c.accessor, c.namespace, op, "collection:"+c.name)
 }
 
-// Helper methods
-func (c *Collection) vectorKey(id string) string {
-	return fmt.Sprintf("_collection/%s/%s/vectors/%s", c.namespace, c.name, id)
+func (c *Collection) space() keys.Subspace {
+	if c.keyspace != nil {
+		return c.keyspace
+	}
+	return collectionSubspace(c.namespace, c.name)
 }
 
-func (c *Collection) vectorKeyPrefix() string {
-	return fmt.Sprintf("_collection/%s/%s/vectors/", c.namespace, c.name)
+// recordWrite admits a write to the collection's namespace; collections
+// outside a namespace are not charged
+func (c *Collection) recordWrite(txn *embedded.Transaction, delta NamespaceUsage) error {
+	if c.namespace == "" {
+		return nil
+	}
+	return recordNamespaceWrite(txn, c.namespace, delta)
 }
 
-func (c *Collection) metadataKey() string {
-	return fmt.Sprintf("_collection/%s/%s/metadata", c.namespace, c.name)
+func (c *Collection) idempotency() idempotencyKeys {
+	return newIdempotencyKeys(c.space(), c.config.IdempotencyWindow)
 }
 
-func (c *Collection) generateID() string {
-	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), randomString(9))
+func (c *Collection) vectorKey(id string) []byte {
+	return c.space().Pack("vectors", id)
+}
+
+func (c *Collection) vectorMetaKey(id string) []byte {
+	return c.space().Pack("meta", id)
+}
+
+func (c *Collection) vectorKeyPrefix() []byte {
+	return c.space().Sub("vectors").Bytes()
+}
+
+func (c *Collection) metadataKey() []byte {
+	return collectionMetadataKey(c.namespace, c.name)
+}
+
+func (c *Collection) countKey() []byte {
+	return c.space().Pack("count")
+}
+
+// The count is a base record plus one delta record per transaction that
+// changed it, so concurrent writers do not rewrite a shared key. Count folds
+// the deltas back into the base once enough have accumulated.
+
+// countCompactThreshold is the number of count deltas that triggers
+// compaction
+const countCompactThreshold = 64
+
+// loadCount sums the vector count records, returning the keys of the delta
+// records alongside the count. Collections created before counts were
+// maintained have no base record and are counted with a scan.
+func (c *Collection) loadCount(txn *embedded.Transaction) (int64, [][]byte, error) {
+	var count int64
+	var deltas [][]byte
+	hasBase := false
+
+	base := c.countKey()
+	iter := txn.ScanPrefix(base)
+	defer iter.Close()
+
+	for {
+		key, value, ok := iter.Next()
+		if !ok {
+			break
+		}
+
+		var n int64
+		if err := json.Unmarshal(value, &n); err != nil {
+			return 0, nil, err
+		}
+		count += n
+		if bytes.Equal(key, base) {
+			hasBase = true
+		} else {
+			deltas = append(deltas, key)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	// The scan already reflects any deltas
+	if !hasBase {
+		found, err := collectKeys(txn, c.vectorKeyPrefix())
+		return int64(len(found)), deltas, err
+	}
+	return count, deltas, nil
+}
+
+// addCount adds delta to the transaction's own count record
+func (c *Collection) addCount(txn *embedded.Transaction, delta int64) error {
+	key := c.space().Pack("count", txn.ID())
+
+	var count int64
+	data, err := txn.Get(key)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		if err := json.Unmarshal(data, &count); err != nil {
+			return err
+		}
+	}
+
+	if data, err = json.Marshal(count + delta); err != nil {
+		return err
+	}
+	return txn.Put(key, data)
+}
+
+// compactCount replaces the count records, and the per-dimension count
+// records, with a single base record each
+func (c *Collection) compactCount(txn *embedded.Transaction) error {
+	dims, dimDeltas, counted, err := c.loadDimensions(txn)
+	if err != nil {
+		return err
+	}
+	if counted && len(dimDeltas) > 0 {
+		if err := c.putDimensions(txn, dims, dimDeltas); err != nil {
+			return err
+		}
+	}
+
+	count, deltas, err := c.loadCount(txn)
+	if err != nil {
+		return err
+	}
+	for _, key := range deltas {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(count)
+	if err != nil {
+		return err
+	}
+	return txn.Put(c.countKey(), data)
 }
 
-func randomString(n int) string {
-	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letters[time.Now().UnixNano()%int64(len(letters))]
+// mergeMetadata applies a merge patch to metadata, returning a new map
+func mergeMetadata(metadata, patch map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(metadata)+len(patch))
+	for k, v := range metadata {
+		merged[k] = v
 	}
-	return string(b)
+
+	for k, v := range patch {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		if sub, ok := v.(map[string]interface{}); ok {
+			if existing, ok := merged[k].(map[string]interface{}); ok {
+				merged[k] = mergeMetadata(existing, sub)
+				continue
+			}
+		}
+		merged[k] = v
+	}
+
+	return merged
+}
+
+func collectionMetadataKey(namespace, name string) []byte {
+	return collectionSubspace(namespace, name).Pack("metadata")
+}
+
+func (c *Collection) generateID() string {
+	return newID(c.ids)
 }
 
 // ============================================================================
@@ -300,70 +689,98 @@ type Namespace struct {
 	db     interface{}
 	name   string
 	config NamespaceConfig
+
+	// accessor is the namespace using this handle when it was opened
+	// through NamespaceManager.AccessNamespace
+	accessor string
 }
 
 // CreateCollection creates a new collection in this namespace
-func (ns *Namespace) CreateCollection(config CollectionConfig) (*Collection, error) {
-	metadataKey := fmt.Sprintf("_collection/%s/%s/metadata", ns.name, config.Name)
-
-	// Check if collection already exists
-	var existing []byte
-	switch db := ns.db.(type) {
-	case interface{ Get([]byte) ([]byte, error) }:
-		var err error
-		existing, err = db.Get([]byte(metadataKey))
-		if err != nil {
-			return nil, err
-		}
-	default:
-		return nil, errors.New("unsupported database type")
+func (ns *Namespace) CreateCollection(config CollectionConfig) (_ *Collection, err error) {
+	access, err := ns.authorize(GrantOperationWrite, "collection:"+config.Name)
+	if err != nil {
+		return nil, err
 	}
+	defer func() { access.done(err) }()
 
-	if existing != nil {
-		return nil, &CollectionExistsError{Collection: config.Name}
+	if err := validateSchema(config.Name, config.Schema); err != nil {
+		return nil, err
 	}
-
-	// Store collection metadata
-	metadata := map[string]interface{}{
-		"name":      config.Name,
-		"dimension": config.Dimension,
-		"metric":    config.Metric,
-		"indexed":   config.Indexed,
-		"createdAt": time.Now().UnixMilli(),
+	if err := validateNamedVectors(config.Name, config.Vectors); err != nil {
+		return nil, err
 	}
 
-	metadataBytes, err := json.Marshal(metadata)
+	metadataKey := collectionMetadataKey(ns.name, config.Name)
+
+	// Store collection metadata
+	config.CreatedAt = time.Now().UnixMilli()
+	metadataBytes, err := json.Marshal(config)
 	if err != nil {
 		return nil, err
 	}
 
-	switch db := ns.db.(type) {
-	case interface{ Put([]byte, []byte) error }:
-		err = db.Put([]byte(metadataKey), metadataBytes)
-		if err != nil {
-			return nil, err
-		}
-	default:
-		return nil, errors.New("unsupported database type")
-	}
-
-	return &Collection{
+	collection := &Collection{
 		db:        ns.db,
 		namespace: ns.name,
 		name:      config.Name,
 		config:    config,
-	}, nil
+		accessor:  ns.accessor,
+	}
+
+	err = withTxn(ns.db, func(txn *embedded.Transaction) error {
+		// Check if collection already exists
+		existing, err := txn.Get(metadataKey)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return &CollectionExistsError{Collection: config.Name}
+		}
+
+		if err := recordNamespaceWrite(txn, ns.name, keyUsageDelta(metadataKey, nil, metadataBytes)); err != nil {
+			return err
+		}
+		if err := txn.Put(collectionSubspace(ns.name, config.Name).Pack("count"), []byte("0")); err != nil {
+			return err
+		}
+		if err := txn.Put(collection.dimensionsKey(), []byte("{}")); err != nil {
+			return err
+		}
+		if err := txn.Put(collectionCatalogKey(ns.name, config.Name), []byte{}); err != nil {
+			return err
+		}
+		if err := collection.initIndex(txn); err != nil {
+			return err
+		}
+		for name := range config.Vectors {
+			if err := collection.vectorIndex(name).initIndex(txn); err != nil {
+				return err
+			}
+		}
+		return txn.Put(metadataKey, metadataBytes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return collection, nil
 }
 
 // Collection gets an existing collection
-func (ns *Namespace) Collection(name string) (*Collection, error) {
-	metadataKey := fmt.Sprintf("_collection/%s/%s/metadata", ns.name, name)
+func (ns *Namespace) Collection(name string) (_ *Collection, err error) {
+	access, err := ns.authorize(GrantOperationRead, "collection:"+name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { access.done(err) }()
+
+	metadataKey := collectionMetadataKey(ns.name, name)
 
 	var metadata []byte
 	switch db := ns.db.(type) {
 	case interface{ Get([]byte) ([]byte, error) }:
 		var err error
-		metadata, err = db.Get([]byte(metadataKey))
+		metadata, err = db.Get(metadataKey)
 		if err != nil {
 			return nil, err
 		}
@@ -385,6 +802,7 @@ func (ns *Namespace) Collection(name string) (*Collection, error) {
 		namespace: ns.name,
 		name:      name,
 		config:    config,
+		accessor:  ns.accessor,
 	}, nil
 }
 
@@ -400,24 +818,205 @@ func (ns *Namespace) GetOrCreateCollection(config CollectionConfig) (*Collection
 	return collection, nil
 }
 
-// DeleteCollection deletes a collection
-func (ns *Namespace) DeleteCollection(name string) error {
-	metadataKey := fmt.Sprintf("_collection/%s/%s/metadata", ns.name, name)
+// UpdateCollection changes the index settings (Indexed, HNSWM,
+// HNSWEfConstruction) and Metadata of an existing collection; its other
+// fields are fixed at creation
+//
+// Enabling the index or changing its parameters starts a background build,
+// returned as the second result; searches scan until it finishes.
+// Disabling the index drops it.
+func (ns *Namespace) UpdateCollection(ctx context.Context, config CollectionConfig) (_ *Collection, _ *IndexBuild, err error) {
+	access, err := ns.authorize(GrantOperationWrite, "collection:"+config.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { access.done(err) }()
 
-	// TODO: Delete all keys with prefix
+	metadataKey := collectionMetadataKey(ns.name, config.Name)
 
-	switch db := ns.db.(type) {
-	case interface{ Delete([]byte) error }:
-		return db.Delete([]byte(metadataKey))
-	default:
-		return errors.New("unsupported database type")
+	var stored CollectionConfig
+	var rebuild, drop bool
+	err = withTxn(ns.db, func(txn *embedded.Transaction) error {
+		existing, err := txn.Get(metadataKey)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return &CollectionNotFoundError{Collection: config.Name}
+		}
+		if err := json.Unmarshal(existing, &stored); err != nil {
+			return err
+		}
+
+		before := &Collection{config: stored}
+		after := &Collection{config: config}
+		beforeM, beforeEf := before.hnswParams()
+		afterM, afterEf := after.hnswParams()
+		rebuild = config.Indexed && (!stored.Indexed || beforeM != afterM || beforeEf != afterEf)
+		drop = stored.Indexed && !config.Indexed
+
+		stored.Indexed = config.Indexed
+		stored.HNSWM = config.HNSWM
+		stored.HNSWEfConstruction = config.HNSWEfConstruction
+		stored.Metadata = config.Metadata
+
+		data, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		if err := recordNamespaceWrite(txn, ns.name, keyUsageDelta(metadataKey, existing, data)); err != nil {
+			return err
+		}
+		if drop {
+			if err := txn.Delete(collectionSubspace(ns.name, config.Name).Sub("index").Pack("versions")); err != nil {
+				return err
+			}
+		}
+		return txn.Put(metadataKey, data)
+	})
+	if err != nil {
+		return nil, nil, err
 	}
+
+	collection := &Collection{
+		db:        ns.db,
+		namespace: ns.name,
+		name:      config.Name,
+		config:    stored,
+		accessor:  ns.accessor,
+	}
+
+	if drop {
+		if err := collection.dropGraphs(ctx, func(uint32) bool { return true }); err != nil {
+			return nil, nil, err
+		}
+	}
+	if !rebuild {
+		return collection, nil, nil
+	}
+
+	build, err := collection.BuildIndex(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return collection, build, nil
 }
 
-// ListCollections lists all collections in this namespace
-func (ns *Namespace) ListCollections() ([]string, error) {
-	// TODO: Implement efficient listing with range queries
-	return []string{}, nil
+// DeleteCollection deletes a collection and all of its vectors
+//
+// The whole collection is removed in one transaction, so readers see either
+// the complete collection or nothing.
+func (ns *Namespace) DeleteCollection(name string) (err error) {
+	access, err := ns.authorize(GrantOperationWrite, "collection:"+name)
+	if err != nil {
+		return err
+	}
+	defer func() { access.done(err) }()
+
+	space := collectionSubspace(ns.name, name)
+	metadataKey := collectionMetadataKey(ns.name, name)
+	vectors := space.Sub("vectors")
+	metas := space.Sub("meta")
+	named := space.Sub("named")
+
+	return withTxn(ns.db, func(txn *embedded.Transaction) error {
+		existing, err := txn.Get(metadataKey)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return &CollectionNotFoundError{Collection: name}
+		}
+
+		var delta NamespaceUsage
+		var found [][]byte
+
+		iter := txn.ScanPrefix(space.Bytes())
+		for {
+			key, value, ok := iter.Next()
+			if !ok {
+				break
+			}
+			found = append(found, key)
+
+			// Only user data is charged against the namespace
+			if vectors.Contains(key) {
+				d := keyUsageDelta(key, value, nil)
+				delta.Keys += d.Keys
+				delta.Bytes += d.Bytes
+				delta.Vectors--
+			} else if metas.Contains(key) || named.Contains(key) || bytes.Equal(key, metadataKey) {
+				d := keyUsageDelta(key, value, nil)
+				delta.Keys += d.Keys
+				delta.Bytes += d.Bytes
+			}
+		}
+		iter.Close()
+		if err := iter.Err(); err != nil {
+			return err
+		}
+
+		if err := recordNamespaceWrite(txn, ns.name, delta); err != nil {
+			return err
+		}
+
+		for _, key := range found {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		return txn.Delete(collectionCatalogKey(ns.name, name))
+	})
+}
+
+// ListCollections returns the configs of all collections in this
+// namespace, ordered by name
+func (ns *Namespace) ListCollections() (_ []CollectionConfig, err error) {
+	access, err := ns.authorize(GrantOperationRead, "collections")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { access.done(err) }()
+
+	configs := []CollectionConfig{}
+	catalog := namespaceDataSpace.Sub(ns.name, "collections")
+
+	err = withTxn(ns.db, func(txn *embedded.Transaction) error {
+		configs = configs[:0]
+
+		names, err := collectKeys(txn, catalog.Bytes())
+		if err != nil {
+			return err
+		}
+
+		for _, key := range names {
+			tuple, err := catalog.Unpack(key)
+			if err != nil || len(tuple) != 1 {
+				continue
+			}
+			name, _ := tuple[0].(string)
+
+			data, err := txn.Get(collectionMetadataKey(ns.name, name))
+			if err != nil {
+				return err
+			}
+			if data == nil {
+				continue
+			}
+
+			var config CollectionConfig
+			if err := json.Unmarshal(data, &config); err != nil {
+				return err
+			}
+			configs = append(configs, config)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return configs, nil
 }
 
 // GetName returns the namespace name
@@ -429,3 +1028,144 @@ func (ns *Namespace) GetName() string {
 func (ns *Namespace) GetConfig() NamespaceConfig {
 	return ns.config
 }
+
+// ============================================================================
+// Namespace Key-Value
+// ============================================================================
+
+// Put stores a value under key inside the namespace
+// Returns NamespaceReadOnlyError if the namespace is read-only, or
+// QuotaExceededError if the write exceeds the namespace quota.
+func (ns *Namespace) Put(key, value []byte) (err error) {
+	access, err := ns.authorize(GrantOperationWrite, "kv")
+	if err != nil {
+		return err
+	}
+	defer func() { access.done(err) }()
+
+	return retryConflicts(ns.db, func(txn *embedded.Transaction) error {
+		storageKey := ns.kvSpace().Pack(key)
+
+		existing, err := txn.Get(storageKey)
+		if err != nil {
+			return err
+		}
+
+		// A nil value would not count as stored
+		if value == nil {
+			value = []byte{}
+		}
+		if err := recordNamespaceWrite(txn, ns.name, keyUsageDelta(storageKey, existing, value)); err != nil {
+			return err
+		}
+		return txn.Put(storageKey, value)
+	})
+}
+
+// Get retrieves the value stored under key inside the namespace
+// Returns nil if the key does not exist.
+func (ns *Namespace) Get(key []byte) (_ []byte, err error) {
+	access, err := ns.authorize(GrantOperationRead, "kv")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { access.done(err) }()
+
+	var value []byte
+	err = withTxn(ns.db, func(txn *embedded.Transaction) error {
+		var err error
+		value, err = txn.Get(ns.kvSpace().Pack(key))
+		return err
+	})
+	return value, err
+}
+
+// Delete removes key from the namespace
+// Returns NamespaceReadOnlyError if the namespace is read-only.
+func (ns *Namespace) Delete(key []byte) (err error) {
+	access, err := ns.authorize(GrantOperationWrite, "kv")
+	if err != nil {
+		return err
+	}
+	defer func() { access.done(err) }()
+
+	return retryConflicts(ns.db, func(txn *embedded.Transaction) error {
+		storageKey := ns.kvSpace().Pack(key)
+
+		existing, err := txn.Get(storageKey)
+		if err != nil || existing == nil {
+			return err
+		}
+
+		if err := recordNamespaceWrite(txn, ns.name, keyUsageDelta(storageKey, existing, nil)); err != nil {
+			return err
+		}
+		return txn.Delete(storageKey)
+	})
+}
+
+// Scan returns all key-value pairs in the namespace whose key starts with
+// prefix, in key order. Returned keys are relative to the namespace.
+func (ns *Namespace) Scan(prefix []byte) (_ []KeyValue, err error) {
+	access, err := ns.authorize(GrantOperationRead, "kv")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { access.done(err) }()
+
+	results := []KeyValue{}
+	space := ns.kvSpace()
+
+	err = withTxn(ns.db, func(txn *embedded.Transaction) error {
+		results = results[:0]
+
+		iter := txn.ScanPrefix(space.PackPrefix(prefix))
+		defer iter.Close()
+
+		for {
+			key, value, ok := iter.Next()
+			if !ok {
+				break
+			}
+
+			tuple, err := space.Unpack(key)
+			if err != nil || len(tuple) != 1 {
+				continue
+			}
+			relative, ok := tuple[0].([]byte)
+			if !ok {
+				continue
+			}
+			results = append(results, KeyValue{Key: relative, Value: value})
+		}
+
+		return iter.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (ns *Namespace) authorize(op, resource string) (*accessCheck, error) {
+	return authorizeAccess(ns.db, ns.accessor, ns.name, op, resource)
+}
+
+func (ns *Namespace) kvSpace() keys.Subspace {
+	return namespaceKVSubspace(ns.name)
+}
+
+// checkNamespaceWritable rejects writes to read-only or deleted namespaces.
+// It reads the registry inside the write transaction, so a concurrent
+// switch to read-only conflicts with the write instead of racing it.
+func checkNamespaceWritable(txn *embedded.Transaction, name string) (*NamespaceConfig, error) {
+	config, err := getNamespaceConfig(txn, name)
+	if err != nil {
+		return nil, err
+	}
+	if config.ReadOnly {
+		return nil, &NamespaceReadOnlyError{Namespace: name}
+	}
+	return config, nil
+}
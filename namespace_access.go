@@ -0,0 +1,343 @@
+// Cross-namespace access control
+//
+// A namespace's NamespacePolicy decides whether other namespaces may use its
+// data: strict forbids all cross-namespace access, explicit (the default)
+// requires a NamespaceGrant, and permissive allows it. Every cross-namespace
+// access is recorded in the target's audit log: denials when they happen,
+// allowed accesses once the operation finishes, with its error if it failed.
+//
+// Example:
+//
+//	// Let tenant_a read the shared knowledge base for a week
+//	expires := time.Now().Add(7 * 24 * time.Hour).UnixMilli()
+//	_, err := mgr.CreateGrant(sochdb.NamespaceGrant{
+//	    FromNamespace: "tenant_a",
+//	    ToNamespace:   "shared_kb",
+//	    Operations:    []string{sochdb.GrantOperationRead},
+//	    ExpiresAt:     &expires,
+//	})
+//
+//	kb, err := mgr.AccessNamespace("tenant_a", "shared_kb")
+//	docs, err := kb.Collection("articles")
+
+package sochdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sochdb/sochdb-go/embedded"
+)
+
+// Grant operations
+const (
+	GrantOperationRead  = "read"
+	GrantOperationWrite = "write"
+	GrantOperationAll   = "*"
+)
+
+// NamespaceAccessDeniedError is returned when a namespace may not access
+// another namespace's data
+type NamespaceAccessDeniedError struct {
+	Accessor  string
+	Namespace string
+	Operation string
+}
+
+func (e *NamespaceAccessDeniedError) Error() string {
+	return fmt.Sprintf("namespace %s may not %s namespace %s", e.Accessor, e.Operation, e.Namespace)
+}
+
+// AuditEntry records one cross-namespace access
+type AuditEntry struct {
+	Timestamp int64  `json:"timestamp"` // Unix milliseconds
+	Accessor  string `json:"accessor"`
+	Namespace string `json:"namespace"`
+	Operation string `json:"operation"`
+	Resource  string `json:"resource"`
+	Allowed   bool   `json:"allowed"`
+	GrantID   string `json:"grant_id,omitempty"`
+	Error     string `json:"error,omitempty"` // Set if the allowed operation failed
+}
+
+// ============================================================================
+// Grant Store
+// ============================================================================
+
+// CreateGrant stores a grant letting FromNamespace access ToNamespace
+// ExpiresAt is in Unix milliseconds; nil never expires. An empty ID is
+// generated.
+func (m *NamespaceManager) CreateGrant(grant NamespaceGrant) (*NamespaceGrant, error) {
+	if grant.FromNamespace == "" || grant.ToNamespace == "" {
+		return nil, errors.New("grant requires from and to namespaces")
+	}
+	if grant.FromNamespace == grant.ToNamespace {
+		return nil, errors.New("grant must be between different namespaces")
+	}
+	if len(grant.Operations) == 0 {
+		return nil, errors.New("grant requires at least one operation")
+	}
+	for _, op := range grant.Operations {
+		switch op {
+		case GrantOperationRead, GrantOperationWrite, GrantOperationAll:
+		default:
+			return nil, fmt.Errorf("unknown grant operation: %s", op)
+		}
+	}
+	if grant.ID == "" {
+		grant.ID = uuid.NewString()
+	}
+
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return nil, err
+	}
+
+	err = withTxn(m.db, func(txn *embedded.Transaction) error {
+		for _, name := range []string{grant.FromNamespace, grant.ToNamespace} {
+			if _, err := getNamespaceConfig(txn, name); err != nil {
+				return err
+			}
+		}
+		return txn.Put(grantSpace.Pack(grant.ToNamespace, grant.FromNamespace, grant.ID), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &grant, nil
+}
+
+// RevokeGrant deletes a grant by ID
+func (m *NamespaceManager) RevokeGrant(grantID string) error {
+	return withTxn(m.db, func(txn *embedded.Transaction) error {
+		iter := txn.ScanPrefix(grantSpace.Bytes())
+		defer iter.Close()
+
+		var found []byte
+		for {
+			key, _, ok := iter.Next()
+			if !ok {
+				break
+			}
+			tuple, err := grantSpace.Unpack(key)
+			if err == nil && len(tuple) == 3 && tuple[2] == grantID {
+				found = key
+				break
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+		if found == nil {
+			return fmt.Errorf("grant not found: %s", grantID)
+		}
+
+		return txn.Delete(found)
+	})
+}
+
+// ListGrants returns the grants giving other namespaces access to namespace,
+// including expired ones
+func (m *NamespaceManager) ListGrants(namespace string) ([]NamespaceGrant, error) {
+	grants := []NamespaceGrant{}
+
+	err := withTxn(m.db, func(txn *embedded.Transaction) error {
+		var err error
+		grants, err = loadGrants(txn, namespace, "")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+// AccessNamespace returns a handle through which accessor uses target
+//
+// Every operation on the handle, and on collections opened through it, is
+// checked against target's policy and grants and written to the audit log.
+func (m *NamespaceManager) AccessNamespace(accessor, target string) (*Namespace, error) {
+	ns, err := m.Namespace(target)
+	if err != nil {
+		return nil, err
+	}
+	if accessor != target {
+		ns.accessor = accessor
+	}
+	return ns, nil
+}
+
+// AuditLog returns the cross-namespace accesses to namespace in time order
+// A limit of 0 returns every entry.
+func (m *NamespaceManager) AuditLog(namespace string, limit int) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+
+	err := withTxn(m.db, func(txn *embedded.Transaction) error {
+		entries = entries[:0]
+
+		iter := txn.ScanPrefix(auditSpace.Sub(namespace).Bytes())
+		defer iter.Close()
+
+		for limit <= 0 || len(entries) < limit {
+			_, value, ok := iter.Next()
+			if !ok {
+				break
+			}
+
+			var entry AuditEntry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+
+		return iter.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// ============================================================================
+// Enforcement
+// ============================================================================
+
+// auditSequence keeps audit keys unique within a timestamp
+var auditSequence atomic.Uint64
+
+// authorizeAccess checks whether accessor may perform op on target's data.
+// Access within a namespace (an empty accessor or accessor == target) is
+// always allowed and not audited. A denial is recorded in target's audit log
+// at once; an allowed access is recorded by the returned check's done, with
+// the outcome of the operation.
+func authorizeAccess(db interface{}, accessor, target, op, resource string) (*accessCheck, error) {
+	if accessor == "" || accessor == target {
+		return nil, nil
+	}
+
+	entry := AuditEntry{
+		Timestamp: time.Now().UnixMilli(),
+		Accessor:  accessor,
+		Namespace: target,
+		Operation: op,
+		Resource:  resource,
+	}
+
+	err := withTxn(db, func(txn *embedded.Transaction) error {
+		config, err := getNamespaceConfig(txn, target)
+		if err != nil {
+			return err
+		}
+
+		entry.Allowed, entry.GrantID = false, ""
+		switch config.Policy {
+		case NamespacePolicyPermissive:
+			entry.Allowed = true
+		case NamespacePolicyStrict:
+		default:
+			grants, err := loadGrants(txn, target, accessor)
+			if err != nil {
+				return err
+			}
+			for _, grant := range grants {
+				if grantAllows(grant, op, entry.Timestamp) {
+					entry.Allowed = true
+					entry.GrantID = grant.ID
+					break
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !entry.Allowed {
+		// The denial stands even if it cannot be logged
+		_ = writeAuditEntry(db, entry)
+		return nil, &NamespaceAccessDeniedError{Accessor: accessor, Namespace: target, Operation: op}
+	}
+	return &accessCheck{db: db, entry: entry}, nil
+}
+
+// accessCheck is an allowed cross-namespace access awaiting the outcome of
+// the operation it guards
+type accessCheck struct {
+	db    interface{}
+	entry AuditEntry
+}
+
+// done records the access in the audit log with the operation's error, if
+// any. The operation has already run, so a failed audit write is dropped
+// rather than reported as the operation failing. A nil check (access within
+// the namespace) records nothing.
+func (a *accessCheck) done(err error) {
+	if a == nil {
+		return
+	}
+	if err != nil {
+		a.entry.Error = err.Error()
+	}
+	_ = writeAuditEntry(a.db, a.entry)
+}
+
+func writeAuditEntry(db interface{}, entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	key := auditSpace.Pack(entry.Namespace, time.Now().UnixNano(), auditSequence.Add(1))
+	return withTxn(db, func(txn *embedded.Transaction) error {
+		return txn.Put(key, data)
+	})
+}
+
+// loadGrants returns the grants to target, limited to those from accessor
+// unless accessor is empty
+func loadGrants(txn *embedded.Transaction, target, accessor string) ([]NamespaceGrant, error) {
+	grants := []NamespaceGrant{}
+
+	space := grantSpace.Sub(target)
+	if accessor != "" {
+		space = space.Sub(accessor)
+	}
+
+	iter := txn.ScanPrefix(space.Bytes())
+	defer iter.Close()
+
+	for {
+		_, value, ok := iter.Next()
+		if !ok {
+			break
+		}
+
+		var grant NamespaceGrant
+		if err := json.Unmarshal(value, &grant); err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+
+	return grants, iter.Err()
+}
+
+func grantAllows(grant NamespaceGrant, op string, nowMillis int64) bool {
+	if grant.ExpiresAt != nil && *grant.ExpiresAt <= nowMillis {
+		return false
+	}
+	for _, allowed := range grant.Operations {
+		if allowed == op || allowed == GrantOperationAll {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package sochdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNamespaceGrants tests policy enforcement, grants and the audit log
+func TestNamespaceGrants(t *testing.T) {
+	db := openTestDB(t)
+	mgr := NewNamespaceManager(db)
+
+	shared, err := mgr.CreateNamespace(NamespaceConfig{Name: "shared_kb"})
+	require.NoError(t, err)
+	_, err = mgr.CreateNamespace(NamespaceConfig{Name: "tenant_a"})
+	require.NoError(t, err)
+	_, err = mgr.CreateNamespace(NamespaceConfig{Name: "secrets", Policy: NamespacePolicyStrict})
+	require.NoError(t, err)
+
+	require.NoError(t, shared.Put([]byte("faq"), []byte("answer")))
+	_, err = shared.CreateCollection(CollectionConfig{Name: "articles"})
+	require.NoError(t, err)
+
+	// Explicit policy: no grant, no access
+	var denied *NamespaceAccessDeniedError
+	kb, err := mgr.AccessNamespace("tenant_a", "shared_kb")
+	require.NoError(t, err)
+	_, err = kb.Get([]byte("faq"))
+	assert.ErrorAs(t, err, &denied)
+
+	grant, err := mgr.CreateGrant(NamespaceGrant{
+		FromNamespace: "tenant_a",
+		ToNamespace:   "shared_kb",
+		Operations:    []string{GrantOperationRead},
+	})
+	require.NoError(t, err)
+
+	value, err := kb.Get([]byte("faq"))
+	require.NoError(t, err)
+	assert.Equal(t, "answer", string(value))
+	_, err = kb.Collection("articles")
+	require.NoError(t, err)
+	assert.ErrorAs(t, kb.Put([]byte("faq"), []byte("defaced")), &denied)
+
+	// Expired grants do not count
+	past := int64(1)
+	_, err = mgr.CreateGrant(NamespaceGrant{
+		FromNamespace: "tenant_a",
+		ToNamespace:   "shared_kb",
+		Operations:    []string{GrantOperationAll},
+		ExpiresAt:     &past,
+	})
+	require.NoError(t, err)
+	assert.ErrorAs(t, kb.Put([]byte("faq"), []byte("defaced")), &denied)
+
+	require.NoError(t, mgr.RevokeGrant(grant.ID))
+	_, err = kb.Get([]byte("faq"))
+	assert.ErrorAs(t, err, &denied)
+
+	// Strict policy ignores grants
+	_, err = mgr.CreateGrant(NamespaceGrant{FromNamespace: "tenant_a", ToNamespace: "secrets", Operations: []string{GrantOperationRead}})
+	require.NoError(t, err)
+	locked, err := mgr.AccessNamespace("tenant_a", "secrets")
+	require.NoError(t, err)
+	_, err = locked.Get([]byte("k"))
+	assert.ErrorAs(t, err, &denied)
+
+	// Retrievers enforce the same rules
+	retriever := NewHybridRetriever(db, "shared_kb", nil).WithAccessor("tenant_a")
+	_, err = retriever.Retrieve("question", NewAllAllowedSet())
+	assert.ErrorAs(t, err, &denied)
+
+	entries, err := mgr.AuditLog("shared_kb", 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 7)
+	assert.False(t, entries[0].Allowed)
+	assert.True(t, entries[1].Allowed)
+	assert.Equal(t, grant.ID, entries[1].GrantID)
+	assert.Equal(t, "collection:articles", entries[2].Resource)
+	assert.Equal(t, "retrieval", entries[6].Resource)
+}
+
+// TestRetrieverGrants tests that retrievers check the grant for each operation
+func TestRetrieverGrants(t *testing.T) {
+	db := openTestDB(t)
+	mgr := NewNamespaceManager(db)
+
+	_, err := mgr.CreateNamespace(NamespaceConfig{Name: "shared_kb"})
+	require.NoError(t, err)
+	_, err = mgr.CreateNamespace(NamespaceConfig{Name: "tenant_a"})
+	require.NoError(t, err)
+
+	owner := NewHybridRetriever(db, "shared_kb", nil)
+	require.NoError(t, owner.IndexDocuments(map[string]map[string]interface{}{
+		"doc1": {"id": "doc1", "text": "original answer"},
+	}))
+
+	_, err = mgr.CreateGrant(NamespaceGrant{
+		FromNamespace: "tenant_a",
+		ToNamespace:   "shared_kb",
+		Operations:    []string{GrantOperationRead},
+	})
+	require.NoError(t, err)
+
+	// A read-only grant can retrieve but not index
+	var denied *NamespaceAccessDeniedError
+	reader := owner.WithAccessor("tenant_a")
+	err = reader.IndexDocuments(map[string]map[string]interface{}{
+		"doc1": {"id": "doc1", "text": "defaced"},
+	})
+	assert.ErrorAs(t, err, &denied)
+
+	docs, err := reader.Retrieve("answer", NewAllAllowedSet())
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	assert.Equal(t, "original answer", docs[0]["text"])
+
+	entries, err := mgr.AuditLog("shared_kb", 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, GrantOperationWrite, entries[0].Operation)
+	assert.False(t, entries[0].Allowed)
+	assert.True(t, entries[1].Allowed)
+}
+
+// TestAuditOutcome tests that allowed accesses are logged with the outcome
+// of the operation
+func TestAuditOutcome(t *testing.T) {
+	db := openTestDB(t)
+	mgr := NewNamespaceManager(db)
+
+	shared, err := mgr.CreateNamespace(NamespaceConfig{Name: "shared_kb"})
+	require.NoError(t, err)
+	_, err = mgr.CreateNamespace(NamespaceConfig{Name: "tenant_a"})
+	require.NoError(t, err)
+	_, err = shared.CreateCollection(CollectionConfig{Name: "articles", Dimension: 2})
+	require.NoError(t, err)
+	_, err = mgr.CreateGrant(NamespaceGrant{
+		FromNamespace: "tenant_a",
+		ToNamespace:   "shared_kb",
+		Operations:    []string{GrantOperationAll},
+	})
+	require.NoError(t, err)
+
+	kb, err := mgr.AccessNamespace("tenant_a", "shared_kb")
+	require.NoError(t, err)
+	articles, err := kb.Collection("articles")
+	require.NoError(t, err)
+	_, err = articles.Insert([]float32{1, 0}, nil, "a")
+	require.NoError(t, err)
+	_, err = articles.Insert([]float32{0, 1}, nil, "a")
+	var exists *VectorExistsError
+	require.ErrorAs(t, err, &exists)
+	_, err = kb.Collection("missing")
+	require.Error(t, err)
+
+	entries, err := mgr.AuditLog("shared_kb", 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	for _, entry := range entries {
+		assert.True(t, entry.Allowed)
+	}
+	assert.Empty(t, entries[0].Error)
+	assert.Empty(t, entries[1].Error)
+	assert.Equal(t, exists.Error(), entries[2].Error)
+	assert.Equal(t, "collection:missing", entries[3].Resource)
+	assert.NotEmpty(t, entries[3].Error)
+}
@@ -0,0 +1,287 @@
+// Namespace registry
+//
+// NamespaceManager persists NamespaceConfig values and hands out Namespace
+// handles, so tenants can be onboarded and offboarded at runtime.
+//
+// Example:
+//
+//	mgr := sochdb.NewNamespaceManager(db)
+//
+//	ns, err := mgr.CreateNamespace(sochdb.NamespaceConfig{
+//	    Name:        "tenant_123",
+//	    DisplayName: "ACME Corporation",
+//	    Labels:      map[string]string{"plan": "enterprise"},
+//	})
+//
+//	configs, err := mgr.ListNamespaces()
+//	err = mgr.DeleteNamespace("tenant_123", true)
+
+package sochdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/sochdb/sochdb-go/embedded"
+	"github.com/sochdb/sochdb-go/keys"
+)
+
+// NamespaceNotEmptyError is returned when deleting a namespace that still
+// holds data without cascade
+type NamespaceNotEmptyError struct {
+	Namespace string
+}
+
+func (e *NamespaceNotEmptyError) Error() string {
+	return fmt.Sprintf("namespace not empty: %s", e.Namespace)
+}
+
+// NamespaceManager manages the namespace registry
+type NamespaceManager struct {
+	db interface{}
+}
+
+// NewNamespaceManager creates a namespace manager
+// The database must support transactions (e.g. *embedded.Database).
+func NewNamespaceManager(db interface{}) *NamespaceManager {
+	return &NamespaceManager{db: db}
+}
+
+// CreateNamespace registers a new namespace
+// Returns NamespaceExistsError if the name is taken.
+func (m *NamespaceManager) CreateNamespace(config NamespaceConfig) (*Namespace, error) {
+	if config.Name == "" {
+		return nil, errors.New("namespace name is required")
+	}
+
+	err := withTxn(m.db, func(txn *embedded.Transaction) error {
+		existing, err := txn.Get(namespaceKey(config.Name))
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return &NamespaceExistsError{Namespace: config.Name}
+		}
+		return putNamespaceConfig(txn, config)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m.handle(config), nil
+}
+
+// Namespace returns a handle to an existing namespace
+// Returns NamespaceNotFoundError if it is not registered.
+func (m *NamespaceManager) Namespace(name string) (*Namespace, error) {
+	var config *NamespaceConfig
+	err := withTxn(m.db, func(txn *embedded.Transaction) error {
+		var err error
+		config, err = getNamespaceConfig(txn, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m.handle(*config), nil
+}
+
+// GetOrCreateNamespace returns an existing namespace or creates it
+func (m *NamespaceManager) GetOrCreateNamespace(config NamespaceConfig) (*Namespace, error) {
+	ns, err := m.Namespace(config.Name)
+	if err != nil {
+		var notFound *NamespaceNotFoundError
+		if errors.As(err, &notFound) {
+			return m.CreateNamespace(config)
+		}
+		return nil, err
+	}
+	return ns, nil
+}
+
+// ListNamespaces returns the configs of all namespaces, ordered by name
+func (m *NamespaceManager) ListNamespaces() ([]NamespaceConfig, error) {
+	configs := []NamespaceConfig{}
+
+	err := withTxn(m.db, func(txn *embedded.Transaction) error {
+		configs = configs[:0]
+
+		iter := txn.ScanPrefix(namespaceSpace.Bytes())
+		defer iter.Close()
+
+		for {
+			_, value, ok := iter.Next()
+			if !ok {
+				break
+			}
+
+			var config NamespaceConfig
+			if err := json.Unmarshal(value, &config); err != nil {
+				return err
+			}
+			configs = append(configs, config)
+		}
+
+		return iter.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return configs, nil
+}
+
+// UpdateNamespace replaces the stored config of an existing namespace
+// Returns NamespaceNotFoundError if it is not registered.
+func (m *NamespaceManager) UpdateNamespace(config NamespaceConfig) (*Namespace, error) {
+	err := withTxn(m.db, func(txn *embedded.Transaction) error {
+		if _, err := getNamespaceConfig(txn, config.Name); err != nil {
+			return err
+		}
+		return putNamespaceConfig(txn, config)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m.handle(config), nil
+}
+
+// DeleteNamespace removes a namespace from the registry
+//
+// With cascade, every key stored in the namespace is deleted in the same
+// transaction. Without it, NamespaceNotEmptyError is returned if the
+// namespace still holds data.
+func (m *NamespaceManager) DeleteNamespace(name string, cascade bool) error {
+	return withTxn(m.db, func(txn *embedded.Transaction) error {
+		if _, err := getNamespaceConfig(txn, name); err != nil {
+			return err
+		}
+
+		for _, space := range namespaceDataSpaces(name) {
+			found, err := collectKeys(txn, space.Bytes())
+			if err != nil {
+				return err
+			}
+			if len(found) > 0 && !cascade {
+				return &NamespaceNotEmptyError{Namespace: name}
+			}
+			for _, key := range found {
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := deleteGrantsOf(txn, name); err != nil {
+			return err
+		}
+
+		usage, err := collectKeys(txn, usageSpace.Pack(name))
+		if err != nil {
+			return err
+		}
+		for _, key := range usage {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		// The audit log is kept for compliance
+		return txn.Delete(namespaceKey(name))
+	})
+}
+
+// Helper methods
+func (m *NamespaceManager) handle(config NamespaceConfig) *Namespace {
+	return &Namespace{
+		db:     m.db,
+		name:   config.Name,
+		config: config,
+	}
+}
+
+func namespaceKey(name string) []byte {
+	return namespaceSpace.Pack(name)
+}
+
+// namespaceDataSpaces lists the subspaces holding a namespace's data
+func namespaceDataSpaces(name string) []keys.Subspace {
+	return []keys.Subspace{
+		collectionSpace.Sub(name),
+		namespaceDataSpace.Sub(name),
+	}
+}
+
+// deleteGrantsOf removes every grant to or from a namespace
+func deleteGrantsOf(txn *embedded.Transaction, name string) error {
+	all, err := collectKeys(txn, grantSpace.Bytes())
+	if err != nil {
+		return err
+	}
+	for _, key := range all {
+		tuple, err := grantSpace.Unpack(key)
+		if err != nil || len(tuple) != 3 {
+			continue
+		}
+		if tuple[0] == name || tuple[1] == name {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func getNamespaceConfig(txn *embedded.Transaction, name string) (*NamespaceConfig, error) {
+	data, err := txn.Get(namespaceKey(name))
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, &NamespaceNotFoundError{Namespace: name}
+	}
+
+	var config NamespaceConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func putNamespaceConfig(txn *embedded.Transaction, config NamespaceConfig) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return txn.Put(namespaceKey(config.Name), data)
+}
+
+// withTxn runs fn in a transaction on databases that support them, charging
+// the write rate of the namespaces it wrote once fn succeeds
+func withTxn(db interface{}, fn func(*embedded.Transaction) error) error {
+	switch handle := db.(type) {
+	case interface {
+		WithTransaction(func(*embedded.Transaction) error) error
+	}:
+		limiter := writeLimiterFor(db)
+		var charged []string
+		err := handle.WithTransaction(func(txn *embedded.Transaction) error {
+			defer pendingWrites.Delete(txn)
+			if err := fn(txn); err != nil {
+				return err
+			}
+			var err error
+			charged, err = limiter.chargeWrites(txn)
+			return err
+		})
+		if err != nil {
+			limiter.refund(charged)
+		}
+		return err
+	default:
+		return errors.New("unsupported database type")
+	}
+}
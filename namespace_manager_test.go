@@ -0,0 +1,56 @@
+package sochdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNamespaceManager tests the namespace registry lifecycle
+func TestNamespaceManager(t *testing.T) {
+	db := openTestDB(t)
+	mgr := NewNamespaceManager(db)
+
+	ns, err := mgr.CreateNamespace(NamespaceConfig{Name: "tenant_b", DisplayName: "Tenant B"})
+	require.NoError(t, err)
+	assert.Equal(t, "tenant_b", ns.GetName())
+
+	_, err = mgr.CreateNamespace(NamespaceConfig{Name: "tenant_a"})
+	require.NoError(t, err)
+
+	var exists *NamespaceExistsError
+	_, err = mgr.CreateNamespace(NamespaceConfig{Name: "tenant_b"})
+	assert.ErrorAs(t, err, &exists)
+
+	configs, err := mgr.ListNamespaces()
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+	assert.Equal(t, "tenant_a", configs[0].Name)
+	assert.Equal(t, "Tenant B", configs[1].DisplayName)
+
+	_, err = mgr.UpdateNamespace(NamespaceConfig{Name: "tenant_b", Labels: map[string]string{"plan": "pro"}})
+	require.NoError(t, err)
+	ns, err = mgr.Namespace("tenant_b")
+	require.NoError(t, err)
+	assert.Equal(t, "pro", ns.GetConfig().Labels["plan"])
+
+	_, err = ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 2})
+	require.NoError(t, err)
+
+	var notEmpty *NamespaceNotEmptyError
+	assert.ErrorAs(t, mgr.DeleteNamespace("tenant_b", false), &notEmpty)
+	require.NoError(t, mgr.DeleteNamespace("tenant_b", true))
+
+	var notFound *NamespaceNotFoundError
+	_, err = mgr.Namespace("tenant_b")
+	assert.ErrorAs(t, err, &notFound)
+	assert.ErrorAs(t, mgr.DeleteNamespace("tenant_b", true), &notFound)
+
+	// Recreating the namespace starts from a clean slate
+	ns, err = mgr.CreateNamespace(NamespaceConfig{Name: "tenant_b"})
+	require.NoError(t, err)
+	_, err = ns.Collection("docs")
+	var missing *CollectionNotFoundError
+	assert.ErrorAs(t, err, &missing)
+}
@@ -0,0 +1,366 @@
+// Namespace quotas and usage accounting
+//
+// Every write made through a Namespace, its collections, or a queue bound to
+// it updates the namespace's usage counters in the same transaction, so the
+// counters never drift from the data. Writes that would push a counter over
+// the namespace's quota are rejected with QuotaExceededError.
+//
+// Usage is stored as a base record plus one delta record per transaction, so
+// concurrent writers do not rewrite a shared counter. Readers sum the
+// records; Usage and quota checks fold the deltas back into the base once
+// enough have accumulated.
+//
+// Example:
+//
+//	ns, err := mgr.CreateNamespace(sochdb.NamespaceConfig{
+//	    Name: "tenant_123",
+//	    Quota: &sochdb.NamespaceQuota{
+//	        MaxBytes:           1 << 30,
+//	        MaxVectors:         1_000_000,
+//	        MaxWritesPerSecond: 500,
+//	    },
+//	})
+//
+//	usage, err := ns.Usage()
+//	fmt.Printf("%d vectors, %d bytes\n", usage.Vectors, usage.Bytes)
+
+package sochdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sochdb/sochdb-go/embedded"
+)
+
+// NamespaceQuota limits what a namespace may store. Zero means unlimited.
+type NamespaceQuota struct {
+	MaxKeys            int64   `json:"max_keys,omitempty"`
+	MaxBytes           int64   `json:"max_bytes,omitempty"`
+	MaxVectors         int64   `json:"max_vectors,omitempty"`
+	MaxQueueDepth      int64   `json:"max_queue_depth,omitempty"`
+	MaxWritesPerSecond float64 `json:"max_writes_per_second,omitempty"`
+}
+
+// NamespaceUsage reports what a namespace currently stores
+type NamespaceUsage struct {
+	Keys       int64 `json:"keys"`        // Keys stored through the namespace KV API and collections
+	Bytes      int64 `json:"bytes"`       // Key and value bytes of those keys
+	Vectors    int64 `json:"vectors"`     // Vectors across all collections
+	QueueDepth int64 `json:"queue_depth"` // Unfinished tasks in queues bound to the namespace
+}
+
+// Quota resources reported by QuotaExceededError
+const (
+	QuotaResourceKeys            = "keys"
+	QuotaResourceBytes           = "bytes"
+	QuotaResourceVectors         = "vectors"
+	QuotaResourceQueueDepth      = "queue_depth"
+	QuotaResourceWritesPerSecond = "writes_per_second"
+)
+
+// QuotaExceededError is returned when a write would exceed a namespace quota
+type QuotaExceededError struct {
+	Namespace string
+	Resource  string
+	Limit     float64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("namespace %s exceeded %s quota (limit %g)", e.Namespace, e.Resource, e.Limit)
+}
+
+// Usage returns the namespace's current usage
+func (ns *Namespace) Usage() (_ *NamespaceUsage, err error) {
+	access, err := ns.authorize(GrantOperationRead, "usage")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { access.done(err) }()
+
+	var usage *NamespaceUsage
+	var deltas [][]byte
+	err = withTxn(ns.db, func(txn *embedded.Transaction) error {
+		var err error
+		usage, deltas, err = loadNamespaceUsage(txn, ns.name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(deltas) >= usageCompactThreshold {
+		err = retryConflicts(ns.db, func(txn *embedded.Transaction) error {
+			usage, deltas, err := loadNamespaceUsage(txn, ns.name)
+			if err != nil {
+				return err
+			}
+			return compactNamespaceUsage(txn, ns.name, usage, deltas)
+		})
+	}
+	return usage, err
+}
+
+// usageCompactThreshold is the number of delta records that triggers
+// compaction
+const usageCompactThreshold = 64
+
+// recordNamespaceWrite admits a write to a namespace and applies its usage
+// delta within txn. The write is rejected if the namespace is read-only or
+// would exceed a quota; shrinking a counter is always allowed. The write rate
+// is charged by withTxn once txn is done.
+//
+// The delta is added to the transaction's own delta record. Only writes that
+// grow a limited counter read the other records.
+func recordNamespaceWrite(txn *embedded.Transaction, name string, delta NamespaceUsage) error {
+	config, err := checkNamespaceWritable(txn, name)
+	if err != nil {
+		return err
+	}
+
+	quota := config.Quota
+	if quota != nil && quota.MaxWritesPerSecond > 0 {
+		markRateLimitedWrite(txn, name, quota.MaxWritesPerSecond)
+	}
+
+	if delta == (NamespaceUsage{}) {
+		return nil
+	}
+
+	deltaKey := usageSpace.Pack(name, txn.ID())
+	own, err := readUsage(txn, deltaKey)
+	if err != nil {
+		return err
+	}
+	*own = addUsage(*own, delta)
+
+	if !growsLimitedCounter(quota, delta) {
+		return putUsage(txn, deltaKey, *own)
+	}
+
+	usage, deltas, err := loadNamespaceUsage(txn, name)
+	if err != nil {
+		return err
+	}
+	*usage = addUsage(*usage, delta)
+
+	for _, check := range []struct {
+		resource string
+		grew     bool
+		value    int64
+		limit    int64
+	}{
+		{QuotaResourceKeys, delta.Keys > 0, usage.Keys, quota.MaxKeys},
+		{QuotaResourceBytes, delta.Bytes > 0, usage.Bytes, quota.MaxBytes},
+		{QuotaResourceVectors, delta.Vectors > 0, usage.Vectors, quota.MaxVectors},
+		{QuotaResourceQueueDepth, delta.QueueDepth > 0, usage.QueueDepth, quota.MaxQueueDepth},
+	} {
+		if check.grew && check.limit > 0 && check.value > check.limit {
+			return &QuotaExceededError{Namespace: name, Resource: check.resource, Limit: float64(check.limit)}
+		}
+	}
+
+	if len(deltas) >= usageCompactThreshold {
+		return compactNamespaceUsage(txn, name, usage, deltas)
+	}
+	return putUsage(txn, deltaKey, *own)
+}
+
+// growsLimitedCounter reports whether delta grows a counter that quota limits
+func growsLimitedCounter(quota *NamespaceQuota, delta NamespaceUsage) bool {
+	return quota != nil &&
+		(delta.Keys > 0 && quota.MaxKeys > 0 ||
+			delta.Bytes > 0 && quota.MaxBytes > 0 ||
+			delta.Vectors > 0 && quota.MaxVectors > 0 ||
+			delta.QueueDepth > 0 && quota.MaxQueueDepth > 0)
+}
+
+// keyUsageDelta returns the usage change of replacing oldValue with
+// newValue under key; a nil value means the key is absent
+func keyUsageDelta(key, oldValue, newValue []byte) NamespaceUsage {
+	var delta NamespaceUsage
+	if oldValue != nil {
+		delta.Keys--
+		delta.Bytes -= int64(len(key) + len(oldValue))
+	}
+	if newValue != nil {
+		delta.Keys++
+		delta.Bytes += int64(len(key) + len(newValue))
+	}
+	return delta
+}
+
+// loadNamespaceUsage sums the namespace's usage records, returning the keys
+// of the delta records alongside the total
+func loadNamespaceUsage(txn *embedded.Transaction, name string) (*NamespaceUsage, [][]byte, error) {
+	usage := &NamespaceUsage{}
+	var deltas [][]byte
+
+	base := usageSpace.Pack(name)
+	iter := txn.ScanPrefix(base)
+	defer iter.Close()
+
+	for {
+		key, value, ok := iter.Next()
+		if !ok {
+			break
+		}
+
+		var record NamespaceUsage
+		if err := json.Unmarshal(value, &record); err != nil {
+			return nil, nil, err
+		}
+		*usage = addUsage(*usage, record)
+		if !bytes.Equal(key, base) {
+			deltas = append(deltas, key)
+		}
+	}
+
+	return usage, deltas, iter.Err()
+}
+
+// compactNamespaceUsage replaces the namespace's usage records with a single
+// base record holding usage
+func compactNamespaceUsage(txn *embedded.Transaction, name string, usage *NamespaceUsage, deltas [][]byte) error {
+	for _, key := range deltas {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return putUsage(txn, usageSpace.Pack(name), *usage)
+}
+
+func readUsage(txn *embedded.Transaction, key []byte) (*NamespaceUsage, error) {
+	usage := &NamespaceUsage{}
+
+	data, err := txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		if err := json.Unmarshal(data, usage); err != nil {
+			return nil, err
+		}
+	}
+	return usage, nil
+}
+
+func putUsage(txn *embedded.Transaction, key []byte, usage NamespaceUsage) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	return txn.Put(key, data)
+}
+
+// ============================================================================
+// Write Rate Limiting
+// ============================================================================
+
+// Each transaction that writes to a rate-limited namespace counts as one
+// write, however many keys it touches. recordNamespaceWrite notes the
+// namespace; withTxn charges it once the transaction's function succeeds and
+// refunds it if the commit fails, so aborted and retried attempts are free.
+
+// writeLimiters holds one rateLimiter per database handle
+var writeLimiters sync.Map // db handle -> *rateLimiter
+
+// pendingWrites holds the rate-limited namespaces written by each open
+// transaction, with their rates
+var pendingWrites sync.Map // *embedded.Transaction -> map[string]float64
+
+// writeLimiterFor returns the rate limiter of a database handle
+func writeLimiterFor(db interface{}) *rateLimiter {
+	if limiter, ok := writeLimiters.Load(db); ok {
+		return limiter.(*rateLimiter)
+	}
+	limiter, _ := writeLimiters.LoadOrStore(db, newRateLimiter())
+	return limiter.(*rateLimiter)
+}
+
+func markRateLimitedWrite(txn *embedded.Transaction, name string, rate float64) {
+	pending, _ := pendingWrites.LoadOrStore(txn, map[string]float64{})
+	pending.(map[string]float64)[name] = rate
+}
+
+// chargeWrites takes one token for each namespace txn wrote, returning the
+// namespaces charged. Nothing is charged if any namespace is over its rate.
+func (l *rateLimiter) chargeWrites(txn *embedded.Transaction) ([]string, error) {
+	pending, ok := pendingWrites.Load(txn)
+	if !ok {
+		return nil, nil
+	}
+
+	var charged []string
+	for name, rate := range pending.(map[string]float64) {
+		if !l.allow(name, rate) {
+			l.refund(charged)
+			return nil, &QuotaExceededError{Namespace: name, Resource: QuotaResourceWritesPerSecond, Limit: rate}
+		}
+		charged = append(charged, name)
+	}
+	return charged, nil
+}
+
+// rateLimiter holds one token bucket per namespace
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+// tokenBucket refills at rate tokens per second, up to one second's worth
+type tokenBucket struct {
+	tokens float64
+	burst  float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket), now: time.Now}
+}
+
+func (l *rateLimiter) allow(name string, rate float64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+
+	bucket, ok := l.buckets[name]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		l.buckets[name] = bucket
+	}
+	bucket.burst = burst
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * rate
+	if bucket.tokens > burst {
+		bucket.tokens = burst
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// refund returns the tokens taken for writes that did not commit
+func (l *rateLimiter) refund(names []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, name := range names {
+		if bucket, ok := l.buckets[name]; ok && bucket.tokens+1 <= bucket.burst {
+			bucket.tokens++
+		}
+	}
+}
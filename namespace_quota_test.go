@@ -0,0 +1,153 @@
+package sochdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sochdb/sochdb-go/embedded"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNamespaceQuotas tests usage accounting and quota enforcement
+func TestNamespaceQuotas(t *testing.T) {
+	db := openTestDB(t)
+	mgr := NewNamespaceManager(db)
+
+	ns, err := mgr.CreateNamespace(NamespaceConfig{
+		Name:  "tenant",
+		Quota: &NamespaceQuota{MaxKeys: 4, MaxVectors: 1, MaxQueueDepth: 1},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, ns.Put([]byte("a"), []byte("1")))
+	require.NoError(t, ns.Put([]byte("a"), []byte("22")))
+	usage, err := ns.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), usage.Keys)
+
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "docs"})
+	require.NoError(t, err)
+	_, err = docs.Insert([]float32{1, 0}, nil, "v1")
+	require.NoError(t, err)
+
+	var quotaErr *QuotaExceededError
+	_, err = docs.Insert([]float32{0, 1}, nil, "v2")
+	require.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, QuotaResourceVectors, quotaErr.Resource)
+
+	// Overwriting an existing vector does not add one
+	_, err = docs.Upsert([]float32{0, 1}, nil, "v1")
+	require.NoError(t, err)
+
+	// Keys: "a", the collection metadata, "v1" and "b"
+	require.NoError(t, ns.Put([]byte("b"), []byte("1")))
+	err = ns.Put([]byte("c"), []byte("1"))
+	require.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, QuotaResourceKeys, quotaErr.Resource)
+
+	// Freeing space makes room again
+	require.NoError(t, ns.Delete([]byte("a")))
+	require.NoError(t, ns.Put([]byte("c"), []byte("1")))
+
+	usage, err = ns.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), usage.Keys)
+	assert.Equal(t, int64(1), usage.Vectors)
+	assert.Positive(t, usage.Bytes)
+
+	queue := NewPriorityQueue(db, "jobs", &QueueConfig{Namespace: "tenant"})
+	_, err = queue.Enqueue(1, []byte("first"), nil)
+	require.NoError(t, err)
+	_, err = queue.Enqueue(1, []byte("second"), nil)
+	require.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, QuotaResourceQueueDepth, quotaErr.Resource)
+}
+
+// TestNamespaceUsageConcurrency tests that writers do not conflict on usage
+// and that usage deltas are compacted
+func TestNamespaceUsageConcurrency(t *testing.T) {
+	db := openTestDB(t)
+	mgr := NewNamespaceManager(db)
+
+	ns, err := mgr.CreateNamespace(NamespaceConfig{Name: "busy"})
+	require.NoError(t, err)
+
+	// Overlapping transactions each add their own delta
+	first, second := db.Begin(), db.Begin()
+	require.NoError(t, recordNamespaceWrite(first, "busy", NamespaceUsage{Keys: 1, Bytes: 10}))
+	require.NoError(t, recordNamespaceWrite(second, "busy", NamespaceUsage{Keys: 2, Bytes: 20}))
+	require.NoError(t, recordNamespaceWrite(second, "busy", NamespaceUsage{Keys: -1, Bytes: -5}))
+	require.NoError(t, first.Commit())
+	require.NoError(t, second.Commit())
+
+	usage, err := ns.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), usage.Keys)
+	assert.Equal(t, int64(25), usage.Bytes)
+
+	for i := 0; i < usageCompactThreshold; i++ {
+		require.NoError(t, ns.Put([]byte(fmt.Sprint(i)), []byte("x")))
+	}
+	usage, err = ns.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2+usageCompactThreshold), usage.Keys)
+
+	var deltas [][]byte
+	require.NoError(t, withTxn(db, func(txn *embedded.Transaction) error {
+		var compacted *NamespaceUsage
+		compacted, deltas, err = loadNamespaceUsage(txn, "busy")
+		assert.Equal(t, usage, compacted)
+		return err
+	}))
+	assert.Empty(t, deltas)
+}
+
+// TestNamespaceWriteRate tests the writes-per-second limit
+func TestNamespaceWriteRate(t *testing.T) {
+	db := openTestDB(t)
+	mgr := NewNamespaceManager(db)
+
+	now := time.Unix(1700000000, 0)
+	writeLimiterFor(db).now = func() time.Time { return now }
+
+	ns, err := mgr.CreateNamespace(NamespaceConfig{
+		Name:  "bursty",
+		Quota: &NamespaceQuota{MaxWritesPerSecond: 2},
+	})
+	require.NoError(t, err)
+
+	// A batch is one write however many items it holds
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 2})
+	require.NoError(t, err)
+	items := make([]InsertItem, 50)
+	for i := range items {
+		items[i] = InsertItem{ID: fmt.Sprint(i), Vector: []float32{1, float32(i)}}
+	}
+	_, err = docs.InsertBatch(items, BatchOptions{})
+	require.NoError(t, err)
+
+	// Failed writes are not charged
+	_, err = docs.Insert([]float32{1, 0}, nil, "0")
+	var exists *VectorExistsError
+	require.ErrorAs(t, err, &exists)
+
+	var quotaErr *QuotaExceededError
+	require.ErrorAs(t, ns.Put([]byte("1"), []byte("x")), &quotaErr)
+	assert.Equal(t, QuotaResourceWritesPerSecond, quotaErr.Resource)
+
+	now = now.Add(500 * time.Millisecond)
+	require.NoError(t, ns.Put([]byte("1"), []byte("x")))
+	require.ErrorAs(t, ns.Put([]byte("2"), []byte("x")), &quotaErr)
+
+	// Other databases have their own limits
+	other := NewNamespaceManager(openTestDB(t))
+	otherNS, err := other.CreateNamespace(NamespaceConfig{
+		Name:  "bursty",
+		Quota: &NamespaceQuota{MaxWritesPerSecond: 2},
+	})
+	require.NoError(t, err)
+	require.NoError(t, otherNS.Put([]byte("1"), []byte("x")))
+}
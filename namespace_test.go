@@ -0,0 +1,219 @@
+package sochdb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/sochdb/sochdb-go/embedded"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNamespaceKVIsolation tests namespace-scoped keys and read-only enforcement
+func TestNamespaceKVIsolation(t *testing.T) {
+	db := openTestDB(t)
+	mgr := NewNamespaceManager(db)
+
+	a, err := mgr.CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+	b, err := mgr.CreateNamespace(NamespaceConfig{Name: "tenant/other"})
+	require.NoError(t, err)
+
+	require.NoError(t, a.Put([]byte("user:1"), []byte("alice")))
+	require.NoError(t, a.Put([]byte("user:2"), []byte("bob")))
+	require.NoError(t, b.Put([]byte("user:1"), []byte("mallory")))
+
+	// Keys that look like paths or prefixes never reach another namespace
+	require.NoError(t, a.Put([]byte("other/user:1"), []byte("x")))
+	value, err := b.Get([]byte("user:1"))
+	require.NoError(t, err)
+	assert.Equal(t, "mallory", string(value))
+
+	results, err := a.Scan([]byte("user:"))
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "user:1", string(results[0].Key))
+	assert.Equal(t, "bob", string(results[1].Value))
+
+	require.NoError(t, a.Delete([]byte("user:2")))
+	value, err = a.Get([]byte("user:2"))
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	// Read-only applies to handles obtained before the switch
+	_, err = mgr.UpdateNamespace(NamespaceConfig{Name: "tenant", ReadOnly: true})
+	require.NoError(t, err)
+
+	var readOnly *NamespaceReadOnlyError
+	assert.ErrorAs(t, a.Put([]byte("user:3"), []byte("carol")), &readOnly)
+	assert.ErrorAs(t, a.Delete([]byte("user:1")), &readOnly)
+	_, err = a.CreateCollection(CollectionConfig{Name: "docs"})
+	assert.ErrorAs(t, err, &readOnly)
+
+	value, err = a.Get([]byte("user:1"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", string(value))
+}
+
+// TestCollectionAdmin tests listing, counting and cascading collection deletes
+func TestCollectionAdmin(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 2, Metric: DistanceMetricEuclidean, HNSWM: 32})
+	require.NoError(t, err)
+	_, err = ns.CreateCollection(CollectionConfig{Name: "docs2", Dimension: 3})
+	require.NoError(t, err)
+
+	configs, err := ns.ListCollections()
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+	assert.Equal(t, "docs", configs[0].Name)
+	assert.Equal(t, 32, configs[0].HNSWM)
+	assert.Equal(t, DistanceMetricEuclidean, configs[0].Metric)
+	assert.Positive(t, configs[0].CreatedAt)
+
+	for _, id := range []string{"a", "b", "c"} {
+		_, err := docs.Insert([]float32{1, 2}, nil, id)
+		require.NoError(t, err)
+	}
+	_, err = docs.Upsert([]float32{3, 4}, nil, "a")
+	require.NoError(t, err)
+	require.NoError(t, docs.Delete("b"))
+	require.NoError(t, docs.Delete("missing"))
+
+	count, err := docs.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	usage, err := ns.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), usage.Vectors)
+
+	require.NoError(t, ns.DeleteCollection("docs"))
+
+	var notFound *CollectionNotFoundError
+	assert.ErrorAs(t, ns.DeleteCollection("docs"), &notFound)
+
+	configs, err = ns.ListCollections()
+	require.NoError(t, err)
+	require.Len(t, configs, 1)
+	assert.Equal(t, "docs2", configs[0].Name)
+
+	// Nothing of the deleted collection is left behind
+	docs, err = ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 2})
+	require.NoError(t, err)
+	vector, err := docs.Get("a")
+	require.NoError(t, err)
+	assert.Nil(t, vector)
+	count, err = docs.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	usage, err = ns.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), usage.Vectors)
+	assert.Equal(t, int64(2), usage.Keys)
+}
+
+// TestCollectionUpsert tests duplicate inserts, upserts and partial updates
+func TestCollectionUpsert(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 2})
+	require.NoError(t, err)
+
+	_, err = docs.Insert([]float32{1, 0}, map[string]interface{}{"title": "a", "tags": map[string]interface{}{"x": 1, "y": 2}}, "doc")
+	require.NoError(t, err)
+
+	var exists *VectorExistsError
+	_, err = docs.Insert([]float32{0, 1}, nil, "doc")
+	require.ErrorAs(t, err, &exists)
+	assert.Equal(t, "doc", exists.ID)
+
+	require.NoError(t, docs.UpdateMetadata("doc", map[string]interface{}{
+		"title":  nil,
+		"source": "web",
+		"tags":   map[string]interface{}{"y": nil, "z": 3},
+	}))
+	got, err := docs.Get("doc")
+	require.NoError(t, err)
+	assert.Equal(t, []float32{1, 0}, got.Vector)
+	assert.Equal(t, map[string]interface{}{
+		"source": "web",
+		"tags":   map[string]interface{}{"x": float64(1), "z": float64(3)},
+	}, got.Metadata)
+
+	require.NoError(t, docs.UpdateVector("doc", []float32{0, 1}))
+	got, err = docs.Get("doc")
+	require.NoError(t, err)
+	assert.Equal(t, []float32{0, 1}, got.Vector)
+	assert.Equal(t, "web", got.Metadata["source"])
+
+	_, err = docs.Upsert([]float32{1, 1}, map[string]interface{}{"v": 2}, "doc")
+	require.NoError(t, err)
+	_, err = docs.Upsert([]float32{1, 1}, nil, "other")
+	require.NoError(t, err)
+	got, err = docs.Get("doc")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"v": float64(2)}, got.Metadata)
+
+	count, err := docs.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	var notFound *VectorNotFoundError
+	assert.ErrorAs(t, docs.UpdateMetadata("missing", map[string]interface{}{"a": 1}), &notFound)
+	assert.ErrorAs(t, docs.UpdateVector("missing", []float32{1, 1}), &notFound)
+	assert.Error(t, docs.UpdateVector("doc", []float32{1}))
+}
+
+// TestCollectionCountConcurrency tests that writers do not conflict on the
+// vector count and that count deltas are compacted
+func TestCollectionCountConcurrency(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+	docs, err := ns.CreateCollection(CollectionConfig{Name: "docs", Dimension: 2})
+	require.NoError(t, err)
+
+	// Overlapping transactions each add their own delta
+	first, second := db.Begin(), db.Begin()
+	require.NoError(t, docs.addCount(first, 2))
+	require.NoError(t, docs.addCount(second, 3))
+	require.NoError(t, docs.addCount(second, -1))
+	require.NoError(t, first.Commit())
+	require.NoError(t, second.Commit())
+
+	count, err := docs.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < countCompactThreshold/4; i++ {
+				_, err := docs.Insert([]float32{1, 0}, nil, fmt.Sprintf("%d-%d", w, i))
+				assert.NoError(t, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	count, err = docs.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 4+countCompactThreshold, count)
+
+	require.NoError(t, withTxn(db, func(txn *embedded.Transaction) error {
+		compacted, deltas, err := docs.loadCount(txn)
+		assert.Equal(t, int64(count), compacted)
+		assert.Empty(t, deltas)
+		return err
+	}))
+}
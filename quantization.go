@@ -0,0 +1,664 @@
+// Vector quantization
+//
+// A quantized collection keeps a compact code per vector next to the full
+// vector. Search streams the codes from the database in one prefix scan,
+// keeping only the best candidates, and re-scores those with the full
+// vectors, so most vectors are never read at full precision.
+//
+// Scalar quantization stores one byte per element, scaled between the
+// per-dimension minimum and maximum of the training sample (4x smaller than
+// float32). Product quantization splits vectors into subvectors and stores
+// the index of the nearest trained centroid for each (one byte per
+// subvector).
+//
+// Example:
+//
+//	docs, err := ns.CreateCollection(sochdb.CollectionConfig{
+//	    Name:      "documents",
+//	    Dimension: 768,
+//	    Quantization: &sochdb.QuantizationConfig{
+//	        Type:       sochdb.QuantizationProduct,
+//	        Subvectors: 96,
+//	    },
+//	})
+//
+//	// After loading data, train the codebook from a sample
+//	err = docs.TrainQuantizer(ctx)
+
+package sochdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/sochdb/sochdb-go/embedded"
+)
+
+// QuantizationType selects a vector quantization scheme
+type QuantizationType string
+
+const (
+	QuantizationScalar  QuantizationType = "scalar"  // One byte per element
+	QuantizationProduct QuantizationType = "product" // One byte per subvector
+)
+
+const (
+	defaultQuantizationSample = 10000
+	defaultQuantizationRerank = 4
+	defaultPQCentroids        = 256
+	pqIterations              = 20
+	quantizeBatchSize         = 256
+)
+
+// QuantizationConfig configures vector quantization for a collection
+type QuantizationConfig struct {
+	Type       QuantizationType `json:"type"`
+	Subvectors int              `json:"subvectors,omitempty"`  // Product: subvectors per vector; must divide the dimension
+	Centroids  int              `json:"centroids,omitempty"`   // Product: centroids per subvector, at most 256; default 256
+	SampleSize int              `json:"sample_size,omitempty"` // Vectors sampled for training; default 10000
+	Rerank     int              `json:"rerank,omitempty"`      // Candidates re-scored per result; default 4
+}
+
+// Codebook is a trained quantizer, stored with the collection config
+type Codebook struct {
+	Version   uint32           `json:"version"`
+	Type      QuantizationType `json:"type"`
+	Dimension int              `json:"dimension"`
+
+	// Scalar: per-dimension range
+	Min []float32 `json:"min,omitempty"`
+	Max []float32 `json:"max,omitempty"`
+
+	// Product: Centroids[s][c] is centroid c of subvector s
+	Centroids [][][]float32 `json:"centroids,omitempty"`
+}
+
+// TrainQuantizer trains a codebook from a random sample of the collection's
+// vectors, stores it with the collection config, and encodes every vector.
+// Training again replaces the codebook; until all codes are rewritten,
+// searches fall back to an exact scan.
+func (c *Collection) TrainQuantizer(ctx context.Context) (err error) {
+	access, err := c.authorize(GrantOperationWrite)
+	if err != nil {
+		return err
+	}
+	defer func() { access.done(err) }()
+
+	qc := c.config.Quantization
+	if qc == nil {
+		return errors.New("collection has no quantization config")
+	}
+
+	sampleSize := qc.SampleSize
+	if sampleSize <= 0 {
+		sampleSize = defaultQuantizationSample
+	}
+
+	// The scan also recounts the vectors of each dimension, which starts the
+	// counts of collections created before they were kept
+	var sample [][]float32
+	var ids []string
+	err = retryConflicts(c.db, func(txn *embedded.Transaction) error {
+		sample, ids = sample[:0], ids[:0]
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		seen := 0
+		counts := map[int]int64{}
+
+		err := c.scanVectors(txn, func(id string, vector []float32, _ func() (map[string]interface{}, error)) error {
+			// Documents may hold only named vectors
+			if len(vector) == 0 {
+				return nil
+			}
+			ids = append(ids, id)
+			counts[len(vector)]++
+
+			// Reservoir sampling
+			seen++
+			if len(sample) < sampleSize {
+				sample = append(sample, vector)
+			} else if j := rng.Intn(seen); j < sampleSize {
+				sample[j] = vector
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		_, deltas, _, err := c.loadDimensions(txn)
+		if err != nil {
+			return err
+		}
+		return c.putDimensions(txn, counts, deltas)
+	})
+	if err != nil {
+		return err
+	}
+	if len(sample) == 0 {
+		return errors.New("cannot train a quantizer on an empty collection")
+	}
+
+	codebook, err := trainCodebook(qc, sample)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	metadataKey := c.metadataKey()
+	err = withTxn(c.db, func(txn *embedded.Transaction) error {
+		existing, err := txn.Get(metadataKey)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return &CollectionNotFoundError{Collection: c.name}
+		}
+
+		var config CollectionConfig
+		if err := json.Unmarshal(existing, &config); err != nil {
+			return err
+		}
+		if config.Codebook != nil {
+			codebook.Version = config.Codebook.Version + 1
+		}
+		config.Codebook = codebook
+
+		data, err := json.Marshal(config)
+		if err != nil {
+			return err
+		}
+		if err := c.recordWrite(txn, keyUsageDelta(metadataKey, existing, data)); err != nil {
+			return err
+		}
+		return txn.Put(metadataKey, data)
+	})
+	if err != nil {
+		return err
+	}
+	c.config.Codebook = codebook
+
+	for len(ids) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n := quantizeBatchSize
+		if n > len(ids) {
+			n = len(ids)
+		}
+
+		err := withTxn(c.db, func(txn *embedded.Transaction) error {
+			for _, id := range ids[:n] {
+				value, err := txn.Get(c.vectorKey(id))
+				if err != nil {
+					return err
+				}
+				if value == nil {
+					continue
+				}
+
+				// Legacy vectors are migrated so their metadata is readable
+				// without decoding the vector
+				if isLegacyVector(value) {
+					var data vectorData
+					if err := json.Unmarshal(value, &data); err != nil {
+						return err
+					}
+					if _, err := c.writeVector(txn, id, data); err != nil {
+						return err
+					}
+					continue
+				}
+
+				vector, _, err := decodeVector(value)
+				if err != nil {
+					return err
+				}
+				if err := c.writeCode(txn, id, vector); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		ids = ids[n:]
+	}
+
+	return nil
+}
+
+// writeCode stores the quantized code of a vector if the collection has a
+// codebook
+func (c *Collection) writeCode(txn *embedded.Transaction, id string, vector []float32) error {
+	code := c.encodeCode(vector)
+	if code == nil {
+		return nil
+	}
+	return txn.Put(c.codeKey(id), code)
+}
+
+// encodeCode returns the stored code of a vector: the codebook version
+// followed by the quantized vector. It returns nil without a codebook.
+func (c *Collection) encodeCode(vector []float32) []byte {
+	codebook := c.config.Codebook
+	if codebook == nil || len(vector) != codebook.Dimension {
+		return nil
+	}
+
+	code := make([]byte, 4, 4+codebook.codeSize())
+	binary.LittleEndian.PutUint32(code, codebook.Version)
+	return codebook.encode(vector, code)
+}
+
+func (c *Collection) codeKey(id string) []byte {
+	return c.space().Pack("codes", id)
+}
+
+// Default vectors are also counted per dimension, kept like the vector count
+// as a base record plus one delta record per transaction. A quantized search
+// needs a current code for every vector of the codebook's dimension; vectors
+// of other dimensions, and documents holding only named vectors, have none.
+
+func (c *Collection) dimensionsKey() []byte {
+	return c.space().Pack("dims")
+}
+
+// addDimensions records that oldRecord was replaced with newRecord; a nil
+// record means the vector is absent
+func (c *Collection) addDimensions(txn *embedded.Transaction, oldRecord, newRecord []byte) error {
+	oldDim, newDim := recordDimension(oldRecord), recordDimension(newRecord)
+	if oldDim == newDim {
+		return nil
+	}
+
+	key := c.space().Pack("dims", txn.ID())
+	counts, err := readDimensions(txn, key)
+	if err != nil {
+		return err
+	}
+	if oldDim > 0 {
+		counts[oldDim]--
+	}
+	if newDim > 0 {
+		counts[newDim]++
+	}
+
+	data, err := json.Marshal(counts)
+	if err != nil {
+		return err
+	}
+	return txn.Put(key, data)
+}
+
+// loadDimensions sums the per-dimension vector counts, returning the keys of
+// the delta records alongside them. ok is false for collections created
+// before the counts were kept, until TrainQuantizer counts their vectors.
+func (c *Collection) loadDimensions(txn *embedded.Transaction) (counts map[int]int64, deltas [][]byte, ok bool, err error) {
+	counts = map[int]int64{}
+
+	base := c.dimensionsKey()
+	iter := txn.ScanPrefix(base)
+	defer iter.Close()
+
+	for {
+		key, value, found := iter.Next()
+		if !found {
+			break
+		}
+
+		var record map[int]int64
+		if err := json.Unmarshal(value, &record); err != nil {
+			return nil, nil, false, err
+		}
+		for dim, n := range record {
+			counts[dim] += n
+		}
+		if bytes.Equal(key, base) {
+			ok = true
+		} else {
+			deltas = append(deltas, key)
+		}
+	}
+
+	return counts, deltas, ok, iter.Err()
+}
+
+// putDimensions replaces the per-dimension count records with a single base
+// record holding counts
+func (c *Collection) putDimensions(txn *embedded.Transaction, counts map[int]int64, deltas [][]byte) error {
+	for _, key := range deltas {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(counts)
+	if err != nil {
+		return err
+	}
+	return txn.Put(c.dimensionsKey(), data)
+}
+
+func readDimensions(txn *embedded.Transaction, key []byte) (map[int]int64, error) {
+	counts := map[int]int64{}
+
+	data, err := txn.Get(key)
+	if err != nil || data == nil {
+		return counts, err
+	}
+	return counts, json.Unmarshal(data, &counts)
+}
+
+// recordDimension returns the dimension of a stored vector record, 0 for an
+// absent or empty vector
+func recordDimension(record []byte) int {
+	if isLegacyVector(record) {
+		var data vectorData
+		if json.Unmarshal(record, &data) != nil {
+			return 0
+		}
+		return len(data.Vector)
+	}
+	if len(record) < vectorHeaderSize {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(record[2:]))
+}
+
+// quantizedSearch picks candidates by their codes and re-scores them at full
+// precision. It falls back to an exact scan if some vectors of the codebook's
+// dimension have no current code, e.g. while the codebook is being retrained.
+func (c *Collection) quantizedSearch(txn *embedded.Transaction, query []float32, k int, floor float32, filter map[string]interface{}) ([]SearchResult, error) {
+	codebook := c.config.Codebook
+	// Approximate scores could miss matches of a range search
+	if len(query) != codebook.Dimension || k == unboundedK {
+		return c.exactSearch(txn, query, k, floor, filter)
+	}
+
+	rerank := defaultQuantizationRerank
+	if c.config.Quantization != nil && c.config.Quantization.Rerank > 0 {
+		rerank = c.config.Quantization.Rerank
+	}
+
+	approx := codebook.scorer(c.config.Metric, query)
+	candidates := &resultHeap{}
+	coded := int64(0)
+
+	codes := c.space().Sub("codes")
+	iter := txn.ScanPrefix(codes.Bytes())
+	defer iter.Close()
+
+	for {
+		key, value, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if len(value) != 4+codebook.codeSize() || binary.LittleEndian.Uint32(value) != codebook.Version {
+			continue
+		}
+		tuple, err := codes.Unpack(key)
+		if err != nil || len(tuple) != 1 {
+			continue
+		}
+		id, ok := tuple[0].(string)
+		if !ok {
+			continue
+		}
+		coded++
+
+		if filter != nil {
+			metadata, err := c.readMetadata(txn, id)
+			if err != nil {
+				return nil, err
+			}
+			if !matchesFilter(metadata, filter) {
+				continue
+			}
+		}
+
+		candidates.offer(SearchResult{ID: id, Score: approx(value[4:])}, saturatingMul(k, rerank))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	dims, _, counted, err := c.loadDimensions(txn)
+	if err != nil {
+		return nil, err
+	}
+	if !counted || coded != dims[codebook.Dimension] {
+		return c.exactSearch(txn, query, k, floor, filter)
+	}
+
+	top := &resultHeap{}
+	for _, candidate := range *candidates {
+		data, err := c.readVector(txn, candidate.ID)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil || len(data.Vector) != len(query) {
+			continue
+		}
+		s := score(c.config.Metric, query, data.Vector)
+		if s < floor {
+			continue
+		}
+		top.offer(SearchResult{ID: candidate.ID, Score: s, Distance: distanceFromScore(c.config.Metric, s)}, k)
+	}
+
+	return top.sorted(), nil
+}
+
+// ============================================================================
+// Codebooks
+// ============================================================================
+
+func trainCodebook(qc *QuantizationConfig, sample [][]float32) (*Codebook, error) {
+	dim := len(sample[0])
+	for _, v := range sample {
+		if len(v) != dim {
+			return nil, errors.New("cannot train a quantizer on vectors of mixed dimensions")
+		}
+	}
+
+	codebook := &Codebook{Version: 1, Type: qc.Type, Dimension: dim}
+
+	switch qc.Type {
+	case QuantizationScalar:
+		codebook.Min = append([]float32{}, sample[0]...)
+		codebook.Max = append([]float32{}, sample[0]...)
+		for _, v := range sample[1:] {
+			for i, x := range v {
+				codebook.Min[i] = float32(math.Min(float64(codebook.Min[i]), float64(x)))
+				codebook.Max[i] = float32(math.Max(float64(codebook.Max[i]), float64(x)))
+			}
+		}
+
+	case QuantizationProduct:
+		subvectors := qc.Subvectors
+		if subvectors <= 0 || dim%subvectors != 0 {
+			return nil, fmt.Errorf("subvectors must divide the dimension %d", dim)
+		}
+		centroids := qc.Centroids
+		if centroids <= 0 {
+			centroids = defaultPQCentroids
+		}
+		if centroids > 256 {
+			return nil, errors.New("product quantization supports at most 256 centroids")
+		}
+
+		width := dim / subvectors
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		codebook.Centroids = make([][][]float32, subvectors)
+		for s := range codebook.Centroids {
+			points := make([][]float32, len(sample))
+			for i, v := range sample {
+				points[i] = v[s*width : (s+1)*width]
+			}
+			codebook.Centroids[s] = kMeans(points, centroids, rng)
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown quantization type: %s", qc.Type)
+	}
+
+	return codebook, nil
+}
+
+// kMeans clusters points with Lloyd's algorithm
+func kMeans(points [][]float32, k int, rng *rand.Rand) [][]float32 {
+	if k > len(points) {
+		k = len(points)
+	}
+
+	centroids := make([][]float32, k)
+	for i, p := range rng.Perm(len(points))[:k] {
+		centroids[i] = append([]float32{}, points[p]...)
+	}
+
+	width := len(points[0])
+	assign := make([]int, len(points))
+	for iter := 0; iter < pqIterations; iter++ {
+		changed := iter == 0
+		for i, p := range points {
+			if nearest := nearestCentroid(centroids, p); nearest != assign[i] {
+				assign[i] = nearest
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		sums := make([][]float64, k)
+		counts := make([]int, k)
+		for i := range sums {
+			sums[i] = make([]float64, width)
+		}
+		for i, p := range points {
+			counts[assign[i]]++
+			for j, x := range p {
+				sums[assign[i]][j] += float64(x)
+			}
+		}
+		for c := range centroids {
+			// An empty cluster keeps its centroid
+			if counts[c] == 0 {
+				continue
+			}
+			for j := range centroids[c] {
+				centroids[c][j] = float32(sums[c][j] / float64(counts[c]))
+			}
+		}
+	}
+
+	return centroids
+}
+
+func nearestCentroid(centroids [][]float32, p []float32) int {
+	best, bestDist := 0, math.Inf(1)
+	for c, centroid := range centroids {
+		var dist float64
+		for j, x := range p {
+			d := float64(x - centroid[j])
+			dist += d * d
+		}
+		if dist < bestDist {
+			best, bestDist = c, dist
+		}
+	}
+	return best
+}
+
+// codeSize returns the length of a code in bytes
+func (cb *Codebook) codeSize() int {
+	if cb.Type == QuantizationProduct {
+		return len(cb.Centroids)
+	}
+	return cb.Dimension
+}
+
+// encode appends the code of vector to dst
+func (cb *Codebook) encode(vector []float32, dst []byte) []byte {
+	if cb.Type == QuantizationProduct {
+		width := cb.Dimension / len(cb.Centroids)
+		for s, centroids := range cb.Centroids {
+			dst = append(dst, byte(nearestCentroid(centroids, vector[s*width:(s+1)*width])))
+		}
+		return dst
+	}
+
+	for i, x := range vector {
+		span := cb.Max[i] - cb.Min[i]
+		if span <= 0 {
+			dst = append(dst, 0)
+			continue
+		}
+		q := math.Round(float64((x - cb.Min[i]) / span * 255))
+		dst = append(dst, byte(math.Max(0, math.Min(255, q))))
+	}
+	return dst
+}
+
+// scorer returns a function approximating score(metric, query, v) from the
+// code of v
+func (cb *Codebook) scorer(metric DistanceMetric, query []float32) func(code []byte) float32 {
+	var queryNorm float64
+	for _, x := range query {
+		queryNorm += float64(x) * float64(x)
+	}
+
+	if cb.Type == QuantizationProduct {
+		// Asymmetric distance: per-centroid partial products with the query
+		width := cb.Dimension / len(cb.Centroids)
+		dots := make([][]float64, len(cb.Centroids))
+		norms := make([][]float64, len(cb.Centroids))
+		for s, centroids := range cb.Centroids {
+			sub := query[s*width : (s+1)*width]
+			dots[s] = make([]float64, len(centroids))
+			norms[s] = make([]float64, len(centroids))
+			for c, centroid := range centroids {
+				for j, x := range centroid {
+					dots[s][c] += float64(sub[j]) * float64(x)
+					norms[s][c] += float64(x) * float64(x)
+				}
+			}
+		}
+
+		return func(code []byte) float32 {
+			var dot, norm float64
+			for s, c := range code {
+				dot += dots[s][c]
+				norm += norms[s][c]
+			}
+			return scoreFromProducts(metric, dot, queryNorm, norm)
+		}
+	}
+
+	scale := make([]float64, cb.Dimension)
+	for i := range scale {
+		scale[i] = float64(cb.Max[i]-cb.Min[i]) / 255
+	}
+
+	return func(code []byte) float32 {
+		var dot, norm float64
+		for i, q := range code {
+			x := float64(cb.Min[i]) + float64(q)*scale[i]
+			dot += float64(query[i]) * x
+			norm += x * x
+		}
+		return scoreFromProducts(metric, dot, queryNorm, norm)
+	}
+}
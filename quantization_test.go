@@ -0,0 +1,133 @@
+package sochdb
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/sochdb/sochdb-go/embedded"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCollectionQuantization tests scalar and product quantized search
+func TestCollectionQuantization(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+
+	rng := rand.New(rand.NewSource(1))
+	vectors := make([][]float32, 200)
+	for i := range vectors {
+		vectors[i] = make([]float32, 8)
+		for j := range vectors[i] {
+			vectors[i][j] = rng.Float32()*2 - 1
+		}
+	}
+
+	for _, qc := range []QuantizationConfig{
+		{Type: QuantizationScalar},
+		{Type: QuantizationProduct, Subvectors: 4, Centroids: 16, Rerank: 10},
+	} {
+		qc := qc
+		coll, err := ns.CreateCollection(CollectionConfig{Name: string(qc.Type), Dimension: 8, Quantization: &qc})
+		require.NoError(t, err)
+		for i, v := range vectors[:150] {
+			_, err := coll.Insert(v, nil, fmt.Sprintf("v%03d", i))
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, coll.TrainQuantizer(context.Background()))
+		assert.Equal(t, uint32(1), coll.config.Codebook.Version)
+
+		// Vectors inserted after training are encoded on write
+		for i, v := range vectors[150:] {
+			_, err := coll.Insert(v, nil, fmt.Sprintf("v%03d", 150+i))
+			require.NoError(t, err)
+		}
+
+		for _, i := range []int{3, 120, 180} {
+			results, err := coll.Search(SearchRequest{QueryVector: vectors[i], K: 3})
+			require.NoError(t, err)
+			require.Len(t, results, 3)
+			assert.Equal(t, fmt.Sprintf("v%03d", i), results[0].ID, "%s query %d", qc.Type, i)
+			assert.InDelta(t, 1, results[0].Score, 1e-5)
+		}
+
+		// A handle opened from the stored config sees the codebook
+		reopened, err := ns.Collection(string(qc.Type))
+		require.NoError(t, err)
+		require.NotNil(t, reopened.config.Codebook)
+
+		require.NoError(t, coll.TrainQuantizer(context.Background()))
+		assert.Equal(t, uint32(2), coll.config.Codebook.Version)
+
+		// The stale handle writes codes for the old codebook; search falls
+		// back to an exact scan instead of missing the vector
+		extra := []float32{1, 1, 1, 1, -1, -1, -1, -1}
+		_, err = reopened.Insert(extra, nil, "extra")
+		require.NoError(t, err)
+		results, err := coll.Search(SearchRequest{QueryVector: extra, K: 1})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, "extra", results[0].ID)
+	}
+}
+
+// TestQuantizationNamedOnlyDocuments tests that documents without a default
+// vector do not force quantized searches to an exact scan
+func TestQuantizationNamedOnlyDocuments(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+
+	coll, err := ns.CreateCollection(CollectionConfig{
+		Name:         "docs",
+		Dimension:    4,
+		Quantization: &QuantizationConfig{Type: QuantizationScalar},
+		Vectors:      map[string]NamedVectorConfig{"title": {Dimension: 2}},
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		_, err := coll.Insert([]float32{float32(i), 1, 0, -1}, nil, fmt.Sprintf("v%02d", i))
+		require.NoError(t, err)
+	}
+	_, err = coll.InsertDocument(Document{ID: "named", Vectors: map[string][]float32{"title": {1, 0}}})
+	require.NoError(t, err)
+	require.NoError(t, coll.TrainQuantizer(context.Background()))
+
+	// Replacing a vector with a named-only document drops its code
+	_, err = coll.UpsertDocument(Document{ID: "v00", Vectors: map[string][]float32{"title": {0, 1}}})
+	require.NoError(t, err)
+
+	codes := func() (coded, vectors int64) {
+		require.NoError(t, withTxn(db, func(txn *embedded.Transaction) error {
+			found, err := collectKeys(txn, coll.space().Sub("codes").Bytes())
+			coded = int64(len(found))
+			if err != nil {
+				return err
+			}
+			dims, _, counted, err := coll.loadDimensions(txn)
+			assert.True(t, counted)
+			vectors = dims[4]
+			return err
+		}))
+		return coded, vectors
+	}
+	coded, vectors := codes()
+	assert.Equal(t, int64(19), coded)
+	assert.Equal(t, int64(19), vectors)
+
+	require.NoError(t, coll.Delete("v01"))
+	coded, vectors = codes()
+	assert.Equal(t, int64(18), coded)
+	assert.Equal(t, int64(18), vectors)
+
+	results, err := coll.Search(SearchRequest{QueryVector: []float32{5, 1, 0, -1}, K: 1})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "v05", results[0].ID)
+}
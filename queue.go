@@ -8,6 +8,7 @@
 // - O(log N) enqueue/dequeue with ordered scans
 // - Atomic claim protocol for concurrent workers
 // - Visibility timeout for crash recovery
+// - Delayed delivery and exponential retry backoff
 //
 // Example:
 //
@@ -35,16 +36,20 @@
 //	}
 //	if task != nil {
 //	    // Process task...
-//	    err = queue.Ack(task.TaskID)
+//	    err = queue.AckClaim(task.TaskID, "worker-1")
 //	}
 package sochdb
 
 import (
-	"encoding/binary"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand"
 	"time"
+
+	"github.com/sochdb/sochdb-go/embedded"
+	"github.com/sochdb/sochdb-go/keys"
 )
 
 // ============================================================================
@@ -71,37 +76,18 @@ type QueueConfig struct {
 	VisibilityTimeout int    // milliseconds, default 30000
 	MaxRetries        int    // default 3
 	DeadLetterQueue   string // optional
-}
-
-// ============================================================================
-// Queue Key Encoding
-// ============================================================================
-
-// encodeU64BE encodes a u64 as big-endian for lexicographic ordering
-func encodeU64BE(value uint64) []byte {
-	buf := make([]byte, 8)
-	binary.BigEndian.PutUint64(buf, value)
-	return buf
-}
 
-// decodeU64BE decodes a big-endian u64
-func decodeU64BE(buf []byte) uint64 {
-	return binary.BigEndian.Uint64(buf[:8])
-}
+	// Namespace charges unfinished tasks against that namespace's
+	// MaxQueueDepth quota (optional)
+	Namespace string
 
-// encodeI64BE encodes an i64 as big-endian preserving order
-func encodeI64BE(value int64) []byte {
-	// Map i64 to u64 by adding offset
-	mapped := uint64(value) + (1 << 63)
-	buf := make([]byte, 8)
-	binary.BigEndian.PutUint64(buf, mapped)
-	return buf
-}
+	IDGenerator       IDGenerator // generates task IDs; default UUIDv7
+	IdempotencyWindow int         // milliseconds keyed enqueues are remembered, default 86400000
 
-// decodeI64BE decodes a big-endian i64
-func decodeI64BE(buf []byte) int64 {
-	mapped := binary.BigEndian.Uint64(buf[:8])
-	return int64(mapped - (1 << 63))
+	// Nacked tasks are retried after RetryBackoff, doubling per retry up to
+	// MaxRetryBackoff, with jitter so failing tasks do not retry in step
+	RetryBackoff    int // milliseconds, default 1000; negative retries immediately
+	MaxRetryBackoff int // milliseconds, default 300000
 }
 
 // ============================================================================
@@ -118,33 +104,77 @@ type QueueKey struct {
 }
 
 // Encode encodes the queue key to bytes
+//
+// Keys sort by priority, then ready time, then sequence, so an ordered scan
+// over a queue yields tasks in dequeue order.
 func (qk *QueueKey) Encode() []byte {
-	parts := [][]byte{
-		[]byte("queue/"),
-		[]byte(qk.QueueID),
-		[]byte("/"),
-		encodeI64BE(qk.Priority),
-		[]byte("/"),
-		encodeU64BE(uint64(qk.ReadyTs)),
-		[]byte("/"),
-		encodeU64BE(qk.Sequence),
-		[]byte("/"),
-		[]byte(qk.TaskID),
+	return queueTaskSpace(qk.QueueID).Pack(qk.Priority, qk.ReadyTs, qk.Sequence, qk.TaskID)
+}
+
+// encodeDelayed encodes the key of a task that is not ready yet. Delayed
+// keys sort by ready time first, so due tasks are found without scanning
+// past later ones.
+func (qk *QueueKey) encodeDelayed() []byte {
+	return queueDelayedSpace(qk.QueueID).Pack(qk.ReadyTs, qk.Priority, qk.Sequence, qk.TaskID)
+}
+
+// DecodeQueueKey decodes a queue key produced by QueueKey.Encode
+func DecodeQueueKey(key []byte) (*QueueKey, error) {
+	t, err := queueSpace.Unpack(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(t) != 6 || t[1] != "tasks" {
+		return nil, fmt.Errorf("invalid queue key")
 	}
 
-	totalLen := 0
-	for _, part := range parts {
-		totalLen += len(part)
+	queueID, ok1 := t[0].(string)
+	priority, ok2 := t[2].(int64)
+	readyTs, ok3 := t[3].(int64)
+	sequence, ok4 := tupleSequence(t[4])
+	taskID, ok5 := t[5].(string)
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 {
+		return nil, fmt.Errorf("invalid queue key")
 	}
 
-	result := make([]byte, totalLen)
-	offset := 0
-	for _, part := range parts {
-		copy(result[offset:], part)
-		offset += len(part)
+	return &QueueKey{
+		QueueID:  queueID,
+		Priority: priority,
+		ReadyTs:  readyTs,
+		Sequence: sequence,
+		TaskID:   taskID,
+	}, nil
+}
+
+// tupleSequence reads a sequence number from a decoded key tuple, where
+// those above math.MaxInt64 decode as uint64
+func tupleSequence(v interface{}) (uint64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return uint64(n), n >= 0
+	case uint64:
+		return n, true
 	}
+	return 0, false
+}
+
+func queueTaskSpace(queueID string) keys.Subspace {
+	return queueSpace.Sub(queueID, "tasks")
+}
 
-	return result
+// queueDelayedSpace holds tasks whose ready time has not passed
+func queueDelayedSpace(queueID string) keys.Subspace {
+	return queueSpace.Sub(queueID, "delayed")
+}
+
+// queueIDSpace maps task IDs to the keys of queued tasks
+func queueIDSpace(queueID string) keys.Subspace {
+	return queueSpace.Sub(queueID, "ids")
+}
+
+// queueDoneSpace holds completed and dead-lettered tasks by ID
+func queueDoneSpace(queueID string) keys.Subspace {
+	return queueSpace.Sub(queueID, "done")
 }
 
 // ============================================================================
@@ -158,6 +188,7 @@ type Task struct {
 	Payload     []byte                 `json:"payload"`
 	State       TaskState              `json:"state"`
 	EnqueuedAt  int64                  `json:"enqueued_at"`
+	ReadyAt     int64                  `json:"ready_at,omitempty"` // Not delivered before this time, in Unix milliseconds
 	ClaimedAt   *int64                 `json:"claimed_at,omitempty"`
 	ClaimedBy   string                 `json:"claimed_by,omitempty"`
 	CompletedAt *int64                 `json:"completed_at,omitempty"`
@@ -183,11 +214,23 @@ type QueueStats struct {
 // Priority Queue
 // ============================================================================
 
+const (
+	// maxConflictRetries bounds the retries of a queue transaction that
+	// conflicts with concurrent workers
+	maxConflictRetries = 64
+
+	// maxPromotedTasks bounds the delayed tasks a dequeue makes ready
+	maxPromotedTasks = 256
+
+	// statsCompactThreshold is the number of stats deltas that triggers
+	// compaction
+	statsCompactThreshold = 64
+)
+
 // PriorityQueue represents a priority queue
 type PriorityQueue struct {
-	db              interface{}
-	config          QueueConfig
-	sequenceCounter uint64
+	db     interface{}
+	config QueueConfig
 }
 
 // NewPriorityQueue creates a new priority queue
@@ -196,6 +239,8 @@ func NewPriorityQueue(db interface{}, name string, config *QueueConfig) *Priorit
 		Name:              name,
 		VisibilityTimeout: 30000,
 		MaxRetries:        3,
+		RetryBackoff:      1000,
+		MaxRetryBackoff:   300000,
 	}
 
 	if config != nil {
@@ -206,29 +251,59 @@ func NewPriorityQueue(db interface{}, name string, config *QueueConfig) *Priorit
 			cfg.MaxRetries = config.MaxRetries
 		}
 		cfg.DeadLetterQueue = config.DeadLetterQueue
+		cfg.Namespace = config.Namespace
+		if config.RetryBackoff != 0 {
+			cfg.RetryBackoff = config.RetryBackoff
+		}
+		if config.MaxRetryBackoff > 0 {
+			cfg.MaxRetryBackoff = config.MaxRetryBackoff
+		}
+		cfg.IDGenerator = config.IDGenerator
+		cfg.IdempotencyWindow = config.IdempotencyWindow
 	}
 
 	return &PriorityQueue{
-		db:              db,
-		config:          cfg,
-		sequenceCounter: 0,
+		db:     db,
+		config: cfg,
 	}
 }
 
 // Enqueue adds a task to the queue with priority
 // Lower priority number = higher urgency
 func (pq *PriorityQueue) Enqueue(priority int64, payload []byte, metadata map[string]interface{}) (string, error) {
+	return pq.enqueue(priority, payload, metadata, "", time.Time{})
+}
+
+// EnqueueAt adds a task that is not delivered before at
+func (pq *PriorityQueue) EnqueueAt(at time.Time, priority int64, payload []byte, metadata map[string]interface{}) (string, error) {
+	if at.IsZero() {
+		return "", errors.New("ready time is zero")
+	}
+	return pq.enqueue(priority, payload, metadata, "", at)
+}
+
+// EnqueueAfter adds a task that is not delivered until delay has passed
+func (pq *PriorityQueue) EnqueueAfter(delay time.Duration, priority int64, payload []byte, metadata map[string]interface{}) (string, error) {
+	return pq.enqueue(priority, payload, metadata, "", time.Now().Add(delay))
+}
+
+// EnqueueIdempotent adds a task once per idempotency key
+// Repeating it with the same key within the queue's IdempotencyWindow
+// enqueues nothing and returns the first call's task ID.
+func (pq *PriorityQueue) EnqueueIdempotent(key string, priority int64, payload []byte, metadata map[string]interface{}) (string, error) {
+	if key == "" {
+		return "", errors.New("idempotency key is empty")
+	}
+	return pq.enqueue(priority, payload, metadata, key, time.Time{})
+}
+
+func (pq *PriorityQueue) enqueue(priority int64, payload []byte, metadata map[string]interface{}, idempotencyKey string, readyAt time.Time) (string, error) {
 	taskID := pq.generateTaskID()
 	now := time.Now().UnixMilli()
-
-	key := QueueKey{
-		QueueID:  pq.config.Name,
-		Priority: priority,
-		ReadyTs:  now,
-		Sequence: pq.sequenceCounter,
-		TaskID:   taskID,
+	readyTs := now
+	if !readyAt.IsZero() && readyAt.UnixMilli() > now {
+		readyTs = readyAt.UnixMilli()
 	}
-	pq.sequenceCounter++
 
 	task := Task{
 		TaskID:     taskID,
@@ -236,193 +311,696 @@ func (pq *PriorityQueue) Enqueue(priority int64, payload []byte, metadata map[st
 		Payload:    payload,
 		State:      TaskStatePending,
 		EnqueuedAt: now,
+		ReadyAt:    readyTs,
 		Retries:    0,
 		Metadata:   metadata,
 	}
 
-	keyBuf := key.Encode()
 	valueBuf, err := json.Marshal(task)
 	if err != nil {
 		return "", err
 	}
 
-	switch db := pq.db.(type) {
-	case interface{ Put([]byte, []byte) error }:
-		err = db.Put(keyBuf, valueBuf)
+	replayed := ""
+	err = retryConflicts(pq.db, func(txn *embedded.Transaction) error {
+		replayed = ""
+		if idempotencyKey != "" {
+			idempotency := newIdempotencyKeys(queueSpace.Sub(pq.config.Name), int64(pq.config.IdempotencyWindow))
+			found, err := idempotency.lookup(txn, idempotencyKey, now, &replayed)
+			if err != nil || found {
+				return err
+			}
+			if err := idempotency.remember(txn, idempotencyKey, now, taskID); err != nil {
+				return err
+			}
+		}
+
+		if pq.config.Namespace != "" {
+			if err := recordNamespaceWrite(txn, pq.config.Namespace, NamespaceUsage{QueueDepth: 1}); err != nil {
+				return err
+			}
+		}
+
+		sequence, err := pq.nextSequence(txn)
 		if err != nil {
-			return "", err
+			return err
+		}
+		key := QueueKey{
+			QueueID:  pq.config.Name,
+			Priority: priority,
+			ReadyTs:  readyTs,
+			Sequence: sequence,
+			TaskID:   taskID,
+		}
+		if err := pq.putTask(txn, key, valueBuf, now); err != nil {
+			return err
 		}
-	default:
-		return "", errors.New("unsupported database type")
+		return pq.addStats(txn, QueueStats{Pending: 1, TotalEnqueued: 1})
+	})
+	if err != nil {
+		return "", err
+	}
+	if replayed != "" {
+		return replayed, nil
 	}
-
-	// Update stats
-	pq.incrementStat("totalEnqueued")
-	pq.incrementStat("pending")
 
 	return taskID, nil
 }
 
-// Dequeue gets the highest priority task
-// Returns nil if no tasks available
+// Dequeue claims the first ready task for workerID
+//
+// Ready tasks are claimed in priority order, then by ready time and enqueue
+// order; delayed and backed-off tasks are skipped until they are due. A
+// claimed task is invisible to other workers until it is acked, nacked, or
+// its VisibilityTimeout expires; an expired claim counts as a failed attempt
+// and the task is delivered again, or dead-lettered after MaxRetries.
+// Returns nil if no tasks available.
 func (pq *PriorityQueue) Dequeue(workerID string) (*Task, error) {
-	// TODO: Implement range scan to find first ready task
-	// For now, this is a placeholder
-	return nil, nil
-}
+	var claimed *Task
 
-// Ack acknowledges task completion
-func (pq *PriorityQueue) Ack(taskID string) error {
-	task, err := pq.getTask(taskID)
+	err := retryConflicts(pq.db, func(txn *embedded.Transaction) error {
+		claimed = nil
+		var expired, deadLettered int
+		now := time.Now().UnixMilli()
+		if err := pq.promoteDue(txn, now); err != nil {
+			return err
+		}
+		prefix := queueTaskSpace(pq.config.Name)
+
+		iter := txn.ScanPrefix(prefix.Bytes())
+		defer iter.Close()
+
+		for {
+			key, value, ok := iter.Next()
+			if !ok {
+				break
+			}
+			var task Task
+			if err := json.Unmarshal(value, &task); err != nil {
+				return err
+			}
+
+			if task.State == TaskStateClaimed {
+				if task.ClaimedAt == nil || *task.ClaimedAt+int64(pq.config.VisibilityTimeout) > now {
+					continue
+				}
+
+				// The worker holding the claim is presumed dead
+				expired++
+				task.Retries++
+				if task.Retries >= pq.config.MaxRetries {
+					if err := pq.finishTask(txn, key, &task, TaskStateDeadLettered, now); err != nil {
+						return err
+					}
+					deadLettered++
+					continue
+				}
+			}
+
+			task.State = TaskStateClaimed
+			task.ClaimedAt = &now
+			task.ClaimedBy = workerID
+			if err := pq.updateTask(txn, key, &task); err != nil {
+				return err
+			}
+			claimed = &task
+			break
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+
+		delta := QueueStats{Claimed: -deadLettered, DeadLettered: deadLettered}
+		if claimed != nil {
+			if expired == deadLettered {
+				// Not a redelivery of an expired claim
+				delta.Pending--
+				delta.Claimed++
+			}
+			delta.TotalDequeued++
+		}
+		return pq.addStats(txn, delta)
+	})
 	if err != nil {
-		return err
-	}
-	if task == nil {
-		return fmt.Errorf("task not found: %s", taskID)
+		return nil, err
 	}
 
-	if task.State != TaskStateClaimed {
-		return fmt.Errorf("task not in claimed state: %s", taskID)
-	}
+	return claimed, nil
+}
 
-	// Update task state
-	task.State = TaskStateCompleted
-	completedAt := time.Now().UnixMilli()
-	task.CompletedAt = &completedAt
+// StaleClaimError is returned when a worker acks or nacks a task it no
+// longer holds: its claim expired, or the task was redelivered or finished
+type StaleClaimError struct {
+	TaskID   string
+	WorkerID string
+}
 
-	if err := pq.updateTask(task); err != nil {
-		return err
+func (e *StaleClaimError) Error() string {
+	return fmt.Sprintf("task %s is not claimed by %s", e.TaskID, e.WorkerID)
+}
+
+// Ack acknowledges task completion
+//
+// Ack does not check which worker holds the claim; workers should use
+// AckClaim so that an ack after the claim expired is rejected.
+func (pq *PriorityQueue) Ack(taskID string) error {
+	return pq.ack(taskID, "")
+}
+
+// AckClaim acknowledges completion of a task claimed by workerID
+// Returns a *StaleClaimError if the worker's claim is no longer current.
+func (pq *PriorityQueue) AckClaim(taskID, workerID string) error {
+	if workerID == "" {
+		return errors.New("worker ID is empty")
 	}
+	return pq.ack(taskID, workerID)
+}
 
-	// Update stats
-	pq.decrementStat("claimed")
-	pq.incrementStat("completed")
+func (pq *PriorityQueue) ack(taskID, workerID string) error {
+	return retryConflicts(pq.db, func(txn *embedded.Transaction) error {
+		now := time.Now().UnixMilli()
+		key, task, err := pq.claimedTask(txn, taskID, workerID, now)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		if err := pq.finishTask(txn, key, task, TaskStateCompleted, now); err != nil {
+			return err
+		}
+		return pq.addStats(txn, QueueStats{Claimed: -1, Completed: 1})
+	})
 }
 
 // Nack returns a task to the queue (negative acknowledge)
+//
+// Nack does not check which worker holds the claim; workers should use
+// NackClaim so that a nack after the claim expired is rejected.
 func (pq *PriorityQueue) Nack(taskID string) error {
-	task, err := pq.getTask(taskID)
-	if err != nil {
-		return err
-	}
-	if task == nil {
-		return fmt.Errorf("task not found: %s", taskID)
+	return pq.nack(taskID, "")
+}
+
+// NackClaim returns a task claimed by workerID to the queue
+// Returns a *StaleClaimError if the worker's claim is no longer current.
+func (pq *PriorityQueue) NackClaim(taskID, workerID string) error {
+	if workerID == "" {
+		return errors.New("worker ID is empty")
 	}
+	return pq.nack(taskID, workerID)
+}
+
+func (pq *PriorityQueue) nack(taskID, workerID string) error {
+	return retryConflicts(pq.db, func(txn *embedded.Transaction) error {
+		now := time.Now().UnixMilli()
+		key, task, err := pq.claimedTask(txn, taskID, workerID, now)
+		if err != nil {
+			return err
+		}
+
+		task.Retries++
 
-	task.Retries++
+		if task.Retries >= pq.config.MaxRetries {
+			// Move to dead letter queue
+			if err := pq.finishTask(txn, key, task, TaskStateDeadLettered, now); err != nil {
+				return err
+			}
+			return pq.addStats(txn, QueueStats{Claimed: -1, DeadLettered: 1})
+		}
 
-	if task.Retries >= pq.config.MaxRetries {
-		// Move to dead letter queue
-		task.State = TaskStateDeadLettered
-		if err := pq.updateTask(task); err != nil {
+		// Return to pending after the backoff
+		queueKey, err := DecodeQueueKey(key)
+		if err != nil {
 			return err
 		}
-		pq.decrementStat("claimed")
-		pq.incrementStat("deadLettered")
-	} else {
-		// Return to pending
+		queueKey.ReadyTs = now + pq.retryDelay(task.Retries)
+
 		task.State = TaskStatePending
 		task.ClaimedAt = nil
 		task.ClaimedBy = ""
-		if err := pq.updateTask(task); err != nil {
+		task.ReadyAt = queueKey.ReadyTs
+		value, err := json.Marshal(task)
+		if err != nil {
+			return err
+		}
+		if err := txn.Delete(key); err != nil {
 			return err
 		}
-		pq.decrementStat("claimed")
-		pq.incrementStat("pending")
+		if err := pq.putTask(txn, *queueKey, value, now); err != nil {
+			return err
+		}
+		return pq.addStats(txn, QueueStats{Pending: 1, Claimed: -1})
+	})
+}
+
+// claimedTask finds a claimed task for an ack or nack. If workerID is not
+// empty, the task must be claimed by that worker within the visibility
+// timeout.
+func (pq *PriorityQueue) claimedTask(txn *embedded.Transaction, taskID, workerID string, now int64) ([]byte, *Task, error) {
+	key, task, err := pq.getTask(txn, taskID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if task == nil {
+		return nil, nil, fmt.Errorf("task not found: %s", taskID)
+	}
+	if workerID == "" {
+		if task.State != TaskStateClaimed {
+			return nil, nil, fmt.Errorf("task not in claimed state: %s", taskID)
+		}
+		return key, task, nil
 	}
 
-	return nil
+	if task.State != TaskStateClaimed || task.ClaimedBy != workerID ||
+		task.ClaimedAt == nil || *task.ClaimedAt+int64(pq.config.VisibilityTimeout) <= now {
+		return nil, nil, &StaleClaimError{TaskID: taskID, WorkerID: workerID}
+	}
+	return key, task, nil
 }
 
 // Stats returns queue statistics
+//
+// The statistics are updated in the transactions that change the tasks, so
+// they stay consistent with the queue under concurrent workers.
 func (pq *PriorityQueue) Stats() (*QueueStats, error) {
-	return &QueueStats{
-		Pending:       pq.getStat("pending"),
-		Claimed:       pq.getStat("claimed"),
-		Completed:     pq.getStat("completed"),
-		DeadLettered:  pq.getStat("deadLettered"),
-		TotalEnqueued: pq.getStat("totalEnqueued"),
-		TotalDequeued: pq.getStat("totalDequeued"),
-	}, nil
+	var stats *QueueStats
+	var deltas [][]byte
+	err := withTxn(pq.db, func(txn *embedded.Transaction) error {
+		var err error
+		stats, deltas, err = pq.loadStats(txn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(deltas) >= statsCompactThreshold {
+		err = retryConflicts(pq.db, pq.compactStats)
+	}
+	return stats, err
 }
 
 // Purge removes completed tasks
+// Dead-lettered tasks are kept for inspection.
 func (pq *PriorityQueue) Purge() (int, error) {
-	// TODO: Implement purging of completed tasks
-	return 0, nil
+	purged := 0
+	err := retryConflicts(pq.db, func(txn *embedded.Transaction) error {
+		purged = 0
+		var found [][]byte
+
+		iter := txn.ScanPrefix(queueDoneSpace(pq.config.Name).Bytes())
+		for {
+			key, value, ok := iter.Next()
+			if !ok {
+				break
+			}
+			var task Task
+			if err := json.Unmarshal(value, &task); err != nil {
+				iter.Close()
+				return err
+			}
+			if task.State == TaskStateCompleted {
+				found = append(found, key)
+			}
+		}
+		iter.Close()
+		if err := iter.Err(); err != nil {
+			return err
+		}
+
+		for _, key := range found {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		purged = len(found)
+		return pq.addStats(txn, QueueStats{Completed: -purged})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
 }
 
 // Helper methods
 func (pq *PriorityQueue) generateTaskID() string {
-	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), randomTaskString(9))
+	return newID(pq.config.IDGenerator)
 }
 
-func randomTaskString(n int) string {
-	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letters[time.Now().UnixNano()%int64(len(letters))]
+// getTask finds a task by ID. The key is nil for finished tasks, which are
+// no longer queued.
+func (pq *PriorityQueue) getTask(txn *embedded.Transaction, taskID string) ([]byte, *Task, error) {
+	value, err := txn.Get(queueDoneSpace(pq.config.Name).Pack(taskID))
+	if err != nil {
+		return nil, nil, err
+	}
+	if value != nil {
+		var task Task
+		return nil, &task, json.Unmarshal(value, &task)
+	}
+
+	key, err := txn.Get(queueIDSpace(pq.config.Name).Pack(taskID))
+	if err != nil {
+		return nil, nil, err
+	}
+	if key == nil {
+		return pq.scanTask(txn, taskID)
+	}
+
+	value, err = txn.Get(key)
+	if err != nil || value == nil {
+		return nil, nil, err
 	}
-	return string(b)
+	var task Task
+	return key, &task, json.Unmarshal(value, &task)
 }
 
-func (pq *PriorityQueue) getTask(taskID string) (*Task, error) {
-	// TODO: Implement task lookup
-	return nil, nil
+// scanTask finds a queued task by ID without the ID index, for tasks
+// enqueued before the index existed
+func (pq *PriorityQueue) scanTask(txn *embedded.Transaction, taskID string) ([]byte, *Task, error) {
+	iter := txn.ScanPrefix(queueTaskSpace(pq.config.Name).Bytes())
+	defer iter.Close()
+
+	for {
+		key, value, ok := iter.Next()
+		if !ok {
+			break
+		}
+		queueKey, err := DecodeQueueKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if queueKey.TaskID != taskID {
+			continue
+		}
+
+		var task Task
+		if err := json.Unmarshal(value, &task); err != nil {
+			return nil, nil, err
+		}
+		return key, &task, nil
+	}
+
+	return nil, nil, iter.Err()
 }
 
-func (pq *PriorityQueue) updateTask(task *Task) error {
-	// TODO: Implement task update
-	return nil
+// putTask stores a queued task and indexes its key by task ID. Tasks that
+// are not ready at now are stored as delayed.
+func (pq *PriorityQueue) putTask(txn *embedded.Transaction, key QueueKey, value []byte, now int64) error {
+	encoded := key.Encode()
+	if key.ReadyTs > now {
+		encoded = key.encodeDelayed()
+	}
+	if err := txn.Put(encoded, value); err != nil {
+		return err
+	}
+	return txn.Put(queueIDSpace(pq.config.Name).Pack(key.TaskID), encoded)
 }
 
-func (pq *PriorityQueue) getStat(name string) int {
-	key := fmt.Sprintf("_queue_stats/%s/%s", pq.config.Name, name)
+// promoteDue moves delayed tasks that are due at now into the dequeue order.
+// The scan stops at the first task that is not due.
+func (pq *PriorityQueue) promoteDue(txn *embedded.Transaction, now int64) error {
+	delayed := queueDelayedSpace(pq.config.Name)
 
-	var value []byte
-	switch db := pq.db.(type) {
-	case interface{ Get([]byte) ([]byte, error) }:
-		var err error
-		value, err = db.Get([]byte(key))
-		if err != nil {
-			return 0
+	type dueTask struct {
+		from  []byte
+		key   QueueKey
+		value []byte
+	}
+	var due []dueTask
+
+	iter := txn.ScanPrefix(delayed.Bytes())
+	for len(due) < maxPromotedTasks {
+		key, value, ok := iter.Next()
+		if !ok {
+			break
+		}
+		t, err := delayed.Unpack(key)
+		if err != nil || len(t) != 4 {
+			iter.Close()
+			return fmt.Errorf("invalid delayed queue key")
+		}
+		readyTs, ok1 := t[0].(int64)
+		priority, ok2 := t[1].(int64)
+		sequence, ok3 := tupleSequence(t[2])
+		taskID, ok4 := t[3].(string)
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			iter.Close()
+			return fmt.Errorf("invalid delayed queue key")
+		}
+		if readyTs > now {
+			break
+		}
+
+		due = append(due, dueTask{
+			from: key,
+			key: QueueKey{
+				QueueID:  pq.config.Name,
+				Priority: priority,
+				ReadyTs:  readyTs,
+				Sequence: sequence,
+				TaskID:   taskID,
+			},
+			value: value,
+		})
+	}
+	iter.Close()
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		if err := txn.Delete(d.from); err != nil {
+			return err
+		}
+		if err := pq.putTask(txn, d.key, d.value, now); err != nil {
+			return err
 		}
-	default:
-		return 0
 	}
+	return nil
+}
 
-	if value == nil {
+// retryDelay returns the backoff before retry number retries, in
+// milliseconds. Half of it is random.
+func (pq *PriorityQueue) retryDelay(retries int) int64 {
+	base := int64(pq.config.RetryBackoff)
+	if base <= 0 {
 		return 0
 	}
+	limit := int64(pq.config.MaxRetryBackoff)
 
-	var count int
-	json.Unmarshal(value, &count)
-	return count
+	delay := base
+	for i := 1; i < retries && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay/2 + rand.Int63n(delay/2+1)
 }
 
-func (pq *PriorityQueue) incrementStat(name string) {
-	current := pq.getStat(name)
-	key := fmt.Sprintf("_queue_stats/%s/%s", pq.config.Name, name)
-	valueBytes, _ := json.Marshal(current + 1)
+// nextSequence allocates the next enqueue sequence number of the queue
+//
+// The counter is stored with the queue, so FIFO order within a priority and
+// ready time holds across restarts and producers in other processes.
+// Concurrent enqueues conflict on it and are retried.
+func (pq *PriorityQueue) nextSequence(txn *embedded.Transaction) (uint64, error) {
+	key := queueSpace.Pack(pq.config.Name, "sequence")
+	value, err := txn.Get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	var sequence uint64
+	if value != nil {
+		if err := json.Unmarshal(value, &sequence); err != nil {
+			return 0, err
+		}
+	}
 
-	switch db := pq.db.(type) {
-	case interface{ Put([]byte, []byte) error }:
-		db.Put([]byte(key), valueBytes)
+	next, err := json.Marshal(sequence + 1)
+	if err != nil {
+		return 0, err
 	}
+	return sequence, txn.Put(key, next)
 }
 
-func (pq *PriorityQueue) decrementStat(name string) {
-	current := pq.getStat(name)
-	if current > 0 {
-		key := fmt.Sprintf("_queue_stats/%s/%s", pq.config.Name, name)
-		valueBytes, _ := json.Marshal(current - 1)
+// updateTask stores a queued task under its key
+func (pq *PriorityQueue) updateTask(txn *embedded.Transaction, key []byte, task *Task) error {
+	value, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	return txn.Put(key, value)
+}
+
+// finishTask moves a queued task out of the dequeue order and returns its
+// slot to the namespace quota
+func (pq *PriorityQueue) finishTask(txn *embedded.Transaction, key []byte, task *Task, state TaskState, now int64) error {
+	task.State = state
+	if state == TaskStateCompleted {
+		task.CompletedAt = &now
+	}
+
+	value, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	if err := txn.Delete(key); err != nil {
+		return err
+	}
+	if err := txn.Delete(queueIDSpace(pq.config.Name).Pack(task.TaskID)); err != nil {
+		return err
+	}
+	if err := txn.Put(queueDoneSpace(pq.config.Name).Pack(task.TaskID), value); err != nil {
+		return err
+	}
+
+	if pq.config.Namespace == "" {
+		return nil
+	}
+	return recordNamespaceWrite(txn, pq.config.Namespace, NamespaceUsage{QueueDepth: -1})
+}
 
-		switch db := pq.db.(type) {
-		case interface{ Put([]byte, []byte) error }:
-			db.Put([]byte(key), valueBytes)
+// retryConflicts runs fn in a transaction, retrying it when the commit
+// conflicts with a concurrent transaction
+func retryConflicts(db interface{}, fn func(*embedded.Transaction) error) error {
+	for attempt := 0; ; attempt++ {
+		err := withTxn(db, fn)
+		if !errors.Is(err, embedded.ErrConflict) || attempt == maxConflictRetries {
+			return err
+		}
+		time.Sleep(time.Duration(rand.Int63n(int64(attempt+1) * int64(time.Millisecond))))
+	}
+}
+
+// Queue statistics are kept as a base record plus one delta record per
+// transaction that changed them, like collection counts, so concurrent
+// workers do not rewrite a shared key. Stats folds the deltas back into the
+// base once enough have accumulated.
+
+// queueStatsSpace holds a queue's statistics records
+func queueStatsSpace(queueID string) keys.Subspace {
+	return queueSpace.Sub(queueID, "counts")
+}
+
+// loadStats sums the queue's statistics records, returning the keys of the
+// delta records alongside the statistics. Queues without a base record may
+// still have legacy per-counter records, which are added in and returned
+// with the deltas so compaction removes them.
+func (pq *PriorityQueue) loadStats(txn *embedded.Transaction) (*QueueStats, [][]byte, error) {
+	stats := &QueueStats{}
+	var deltas [][]byte
+	hasBase := false
+
+	base := queueStatsSpace(pq.config.Name).Bytes()
+	iter := txn.ScanPrefix(base)
+	defer iter.Close()
+
+	for {
+		key, value, ok := iter.Next()
+		if !ok {
+			break
 		}
+
+		var record QueueStats
+		if err := json.Unmarshal(value, &record); err != nil {
+			return nil, nil, err
+		}
+		*stats = addQueueStats(*stats, record)
+		if bytes.Equal(key, base) {
+			hasBase = true
+		} else {
+			deltas = append(deltas, key)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, nil, err
+	}
+	if hasBase {
+		return stats, deltas, nil
+	}
+
+	legacy := QueueStats{}
+	for _, stat := range []struct {
+		name  string
+		value *int
+	}{
+		{"pending", &legacy.Pending},
+		{"claimed", &legacy.Claimed},
+		{"completed", &legacy.Completed},
+		{"deadLettered", &legacy.DeadLettered},
+		{"totalEnqueued", &legacy.TotalEnqueued},
+		{"totalDequeued", &legacy.TotalDequeued},
+	} {
+		key := queueSpace.Pack(pq.config.Name, "stats", stat.name)
+		data, err := txn.Get(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if data == nil {
+			continue
+		}
+		if err := json.Unmarshal(data, stat.value); err != nil {
+			return nil, nil, err
+		}
+		deltas = append(deltas, key)
+	}
+	*stats = addQueueStats(*stats, legacy)
+	return stats, deltas, nil
+}
+
+// addStats adds delta to the transaction's own statistics record
+func (pq *PriorityQueue) addStats(txn *embedded.Transaction, delta QueueStats) error {
+	if delta == (QueueStats{}) {
+		return nil
+	}
+	key := queueStatsSpace(pq.config.Name).Pack(txn.ID())
+
+	var stats QueueStats
+	data, err := txn.Get(key)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		if err := json.Unmarshal(data, &stats); err != nil {
+			return err
+		}
+	}
+
+	if data, err = json.Marshal(addQueueStats(stats, delta)); err != nil {
+		return err
+	}
+	return txn.Put(key, data)
+}
+
+// compactStats replaces the queue's statistics records with a single base
+// record
+func (pq *PriorityQueue) compactStats(txn *embedded.Transaction) error {
+	stats, deltas, err := pq.loadStats(txn)
+	if err != nil {
+		return err
+	}
+	for _, key := range deltas {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return txn.Put(queueStatsSpace(pq.config.Name).Bytes(), data)
+}
+
+func addQueueStats(a, b QueueStats) QueueStats {
+	return QueueStats{
+		Pending:       a.Pending + b.Pending,
+		Claimed:       a.Claimed + b.Claimed,
+		Completed:     a.Completed + b.Completed,
+		DeadLettered:  a.DeadLettered + b.DeadLettered,
+		TotalEnqueued: a.TotalEnqueued + b.TotalEnqueued,
+		TotalDequeued: a.TotalDequeued + b.TotalDequeued,
 	}
 }
 
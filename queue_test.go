@@ -0,0 +1,383 @@
+package sochdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sochdb/sochdb-go/embedded"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestQueueKeyRoundTrip tests that queue keys decode and sort in dequeue order
+func TestQueueKeyRoundTrip(t *testing.T) {
+	urgent := QueueKey{QueueID: "jobs/eu", Priority: -1, ReadyTs: 2000, Sequence: 7, TaskID: "b"}
+	normal := QueueKey{QueueID: "jobs/eu", Priority: 1, ReadyTs: 1000, Sequence: 1, TaskID: "a"}
+
+	decoded, err := DecodeQueueKey(urgent.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, urgent, *decoded)
+
+	assert.Negative(t, bytes.Compare(urgent.Encode(), normal.Encode()))
+
+	// Sequences above math.MaxInt64 decode from the tuple as uint64
+	last := QueueKey{QueueID: "jobs/eu", Priority: 1, ReadyTs: 1000, Sequence: math.MaxUint64, TaskID: "c"}
+	decoded, err = DecodeQueueKey(last.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, last, *decoded)
+	assert.Negative(t, bytes.Compare(normal.Encode(), last.Encode()))
+}
+
+// TestQueueLargeSequence tests delayed tasks with sequences above
+// math.MaxInt64
+func TestQueueLargeSequence(t *testing.T) {
+	db := openTestDB(t)
+	queue := NewPriorityQueue(db, "jobs", nil)
+	require.NoError(t, db.WithTransaction(func(txn *embedded.Transaction) error {
+		return txn.Put(queueSpace.Pack("jobs", "sequence"), []byte(fmt.Sprint(uint64(math.MaxInt64)+1)))
+	}))
+
+	later, err := queue.EnqueueAfter(20*time.Millisecond, 0, []byte("later"), nil)
+	require.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+
+	task, err := queue.Dequeue("worker-1")
+	require.NoError(t, err)
+	require.NotNil(t, task)
+	assert.Equal(t, later, task.TaskID)
+	require.NoError(t, queue.Ack(later))
+}
+
+// TestQueueDequeue tests claiming, acking and visibility timeouts
+func TestQueueDequeue(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+
+	queue := NewPriorityQueue(db, "jobs", &QueueConfig{Namespace: "tenant", VisibilityTimeout: 50, RetryBackoff: -1})
+	low, err := queue.Enqueue(5, []byte("low"), nil)
+	require.NoError(t, err)
+	first, err := queue.Enqueue(1, []byte("first"), nil)
+	require.NoError(t, err)
+	second, err := queue.Enqueue(1, []byte("second"), nil)
+	require.NoError(t, err)
+
+	task, err := queue.Dequeue("worker-1")
+	require.NoError(t, err)
+	require.NotNil(t, task)
+	assert.Equal(t, first, task.TaskID)
+	assert.Equal(t, TaskStateClaimed, task.State)
+	assert.Equal(t, "worker-1", task.ClaimedBy)
+	require.NotNil(t, task.ClaimedAt)
+	require.NoError(t, queue.Ack(first))
+	assert.Error(t, queue.Ack(first))
+
+	task, err = queue.Dequeue("worker-1")
+	require.NoError(t, err)
+	assert.Equal(t, second, task.TaskID)
+	require.NoError(t, queue.Nack(second))
+	task, err = queue.Dequeue("worker-2")
+	require.NoError(t, err)
+	assert.Equal(t, second, task.TaskID)
+	assert.Equal(t, 1, task.Retries)
+
+	// An expired claim is delivered again
+	task, err = queue.Dequeue("worker-2")
+	require.NoError(t, err)
+	assert.Equal(t, low, task.TaskID)
+	task, err = queue.Dequeue("worker-2")
+	require.NoError(t, err)
+	assert.Nil(t, task)
+
+	time.Sleep(60 * time.Millisecond)
+	task, err = queue.Dequeue("worker-3")
+	require.NoError(t, err)
+	require.NotNil(t, task)
+	assert.Equal(t, second, task.TaskID)
+	assert.Equal(t, "worker-3", task.ClaimedBy)
+	assert.Equal(t, 2, task.Retries)
+
+	// The third failed attempt dead-letters the task
+	time.Sleep(60 * time.Millisecond)
+	task, err = queue.Dequeue("worker-3")
+	require.NoError(t, err)
+	assert.Equal(t, low, task.TaskID)
+	require.NoError(t, queue.Ack(low))
+	task, err = queue.Dequeue("worker-3")
+	require.NoError(t, err)
+	assert.Nil(t, task)
+
+	stats, err := queue.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Pending)
+	assert.Equal(t, 0, stats.Claimed)
+	assert.Equal(t, 2, stats.Completed)
+	assert.Equal(t, 1, stats.DeadLettered)
+	usage, err := ns.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), usage.QueueDepth)
+
+	purged, err := queue.Purge()
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	assert.EqualError(t, queue.Ack(first), "task not found: "+first)
+}
+
+// TestQueueStaleClaim tests that acks and nacks of expired claims are
+// rejected
+func TestQueueStaleClaim(t *testing.T) {
+	db := openTestDB(t)
+	queue := NewPriorityQueue(db, "jobs", &QueueConfig{VisibilityTimeout: 50, RetryBackoff: -1})
+	id, err := queue.Enqueue(1, nil, nil)
+	require.NoError(t, err)
+
+	task, err := queue.Dequeue("worker-1")
+	require.NoError(t, err)
+	require.NotNil(t, task)
+	var stale *StaleClaimError
+	assert.ErrorAs(t, queue.AckClaim(id, "worker-2"), &stale)
+	require.NoError(t, queue.NackClaim(id, "worker-1"))
+	assert.ErrorAs(t, queue.AckClaim(id, "worker-1"), &stale)
+
+	// worker-1's claim expires and the task is redelivered to worker-2
+	_, err = queue.Dequeue("worker-1")
+	require.NoError(t, err)
+	time.Sleep(60 * time.Millisecond)
+	assert.ErrorAs(t, queue.NackClaim(id, "worker-1"), &stale)
+	task, err = queue.Dequeue("worker-2")
+	require.NoError(t, err)
+	require.NotNil(t, task)
+	assert.Equal(t, id, task.TaskID)
+
+	err = queue.AckClaim(id, "worker-1")
+	require.ErrorAs(t, err, &stale)
+	assert.Equal(t, &StaleClaimError{TaskID: id, WorkerID: "worker-1"}, stale)
+	require.NoError(t, queue.AckClaim(id, "worker-2"))
+	assert.ErrorAs(t, queue.AckClaim(id, "worker-2"), &stale)
+
+	stats, err := queue.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Completed)
+	assert.Equal(t, 0, stats.Claimed)
+}
+
+// TestQueueConcurrentWorkers tests that each task is claimed by one worker
+func TestQueueConcurrentWorkers(t *testing.T) {
+	db := openTestDB(t)
+	queue := NewPriorityQueue(db, "jobs", nil)
+
+	const tasks = 60
+	for i := 0; i < tasks; i++ {
+		_, err := queue.Enqueue(int64(i%3), []byte(fmt.Sprint(i)), nil)
+		require.NoError(t, err)
+	}
+
+	var mu sync.Mutex
+	claims := make(map[string]int)
+	var wg sync.WaitGroup
+	for w := 0; w < 6; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for {
+				task, err := queue.Dequeue(fmt.Sprintf("worker-%d", w))
+				if !assert.NoError(t, err) || task == nil {
+					return
+				}
+				mu.Lock()
+				claims[task.TaskID]++
+				mu.Unlock()
+				if !assert.NoError(t, queue.Ack(task.TaskID)) {
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	assert.Len(t, claims, tasks)
+	for id, n := range claims {
+		assert.Equal(t, 1, n, "task %s claimed %d times", id, n)
+	}
+
+	stats, err := queue.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, QueueStats{Completed: tasks, TotalEnqueued: tasks, TotalDequeued: tasks}, *stats)
+}
+
+// TestQueueStats tests that legacy stat records are counted and compacted
+// with the delta records
+func TestQueueStats(t *testing.T) {
+	db := openTestDB(t)
+	queue := NewPriorityQueue(db, "jobs", nil)
+	require.NoError(t, db.WithTransaction(func(txn *embedded.Transaction) error {
+		if err := txn.Put(queueSpace.Pack("jobs", "stats", "completed"), []byte("3")); err != nil {
+			return err
+		}
+		return txn.Put(queueSpace.Pack("jobs", "stats", "totalEnqueued"), []byte("3"))
+	}))
+
+	for i := 0; i < statsCompactThreshold; i++ {
+		_, err := queue.Enqueue(1, nil, nil)
+		require.NoError(t, err)
+	}
+	stats, err := queue.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, QueueStats{Pending: statsCompactThreshold, Completed: 3, TotalEnqueued: 3 + statsCompactThreshold}, *stats)
+
+	require.NoError(t, db.WithTransaction(func(txn *embedded.Transaction) error {
+		compacted, deltas, err := queue.loadStats(txn)
+		require.NoError(t, err)
+		assert.Equal(t, stats, compacted)
+		assert.Empty(t, deltas)
+
+		legacy, err := txn.Get(queueSpace.Pack("jobs", "stats", "completed"))
+		require.NoError(t, err)
+		assert.Nil(t, legacy)
+		return nil
+	}))
+}
+
+// TestQueueTaskIndex tests task lookup by ID and the durable sequence counter
+func TestQueueTaskIndex(t *testing.T) {
+	db := openTestDB(t)
+
+	// Handles stand in for producers in separate processes
+	var wg sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			producer := NewPriorityQueue(db, "jobs", nil)
+			for i := 0; i < 10; i++ {
+				_, err := producer.Enqueue(1, nil, nil)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	sequences := make(map[uint64]bool)
+	require.NoError(t, db.WithTransaction(func(txn *embedded.Transaction) error {
+		found, err := collectKeys(txn, queueTaskSpace("jobs").Bytes())
+		if err != nil {
+			return err
+		}
+		for _, key := range found {
+			queueKey, err := DecodeQueueKey(key)
+			require.NoError(t, err)
+			sequences[queueKey.Sequence] = true
+
+			indexed, err := txn.Get(queueIDSpace("jobs").Pack(queueKey.TaskID))
+			require.NoError(t, err)
+			assert.Equal(t, key, indexed)
+		}
+		return nil
+	}))
+	assert.Len(t, sequences, 40)
+	for i := uint64(0); i < 40; i++ {
+		assert.True(t, sequences[i], "sequence %d missing", i)
+	}
+
+	// A restarted queue continues the sequence
+	restarted := NewPriorityQueue(db, "jobs", nil)
+	last, err := restarted.Enqueue(1, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.WithTransaction(func(txn *embedded.Transaction) error {
+		key, task, err := restarted.getTask(txn, last)
+		require.NoError(t, err)
+		require.NotNil(t, task)
+		queueKey, err := DecodeQueueKey(key)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(40), queueKey.Sequence)
+		return nil
+	}))
+
+	// Finished tasks leave the index
+	task, err := restarted.Dequeue("worker-1")
+	require.NoError(t, err)
+	require.NoError(t, restarted.Ack(task.TaskID))
+	value, err := db.Get(queueIDSpace("jobs").Pack(task.TaskID))
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	// Tasks stored before the index existed are still found
+	legacy := QueueKey{QueueID: "legacy", Priority: 1, ReadyTs: time.Now().UnixMilli(), TaskID: "old"}
+	data, err := json.Marshal(Task{TaskID: "old", Priority: 1, State: TaskStatePending})
+	require.NoError(t, err)
+	require.NoError(t, db.Put(legacy.Encode(), data))
+	old := NewPriorityQueue(db, "legacy", nil)
+	task, err = old.Dequeue("worker-1")
+	require.NoError(t, err)
+	require.NotNil(t, task)
+	require.NoError(t, old.Ack("old"))
+}
+
+// TestQueueDelayedDelivery tests scheduled tasks and retry backoff
+func TestQueueDelayedDelivery(t *testing.T) {
+	db := openTestDB(t)
+	queue := NewPriorityQueue(db, "jobs", &QueueConfig{MaxRetries: 5, RetryBackoff: 40, MaxRetryBackoff: 100})
+
+	// A delayed urgent task does not hold up ready ones
+	later, err := queue.EnqueueAfter(50*time.Millisecond, 0, []byte("later"), nil)
+	require.NoError(t, err)
+	now, err := queue.Enqueue(5, []byte("now"), nil)
+	require.NoError(t, err)
+	past, err := queue.EnqueueAt(time.Now().Add(-time.Hour), 5, []byte("past"), nil)
+	require.NoError(t, err)
+
+	task, err := queue.Dequeue("worker-1")
+	require.NoError(t, err)
+	assert.Equal(t, now, task.TaskID)
+	require.NoError(t, queue.Ack(now))
+	task, err = queue.Dequeue("worker-1")
+	require.NoError(t, err)
+	assert.Equal(t, past, task.TaskID)
+	require.NoError(t, queue.Ack(past))
+	task, err = queue.Dequeue("worker-1")
+	require.NoError(t, err)
+	assert.Nil(t, task)
+
+	time.Sleep(60 * time.Millisecond)
+	task, err = queue.Dequeue("worker-1")
+	require.NoError(t, err)
+	require.NotNil(t, task)
+	assert.Equal(t, later, task.TaskID)
+
+	// Nacks back off exponentially with jitter, up to the limit
+	readyAt := func() int64 {
+		var ready int64
+		require.NoError(t, db.WithTransaction(func(txn *embedded.Transaction) error {
+			_, task, err := queue.getTask(txn, later)
+			require.NoError(t, err)
+			ready = task.ReadyAt
+			return nil
+		}))
+		return ready
+	}
+	for retry, limit := range []int64{40, 80, 100} {
+		before := time.Now().UnixMilli()
+		require.NoError(t, queue.Nack(later))
+		delay := readyAt() - before
+		assert.GreaterOrEqual(t, delay, limit/2-1, "retry %d", retry+1)
+		assert.LessOrEqual(t, delay, limit+1, "retry %d", retry+1)
+
+		task, err = queue.Dequeue("worker-1")
+		require.NoError(t, err)
+		assert.Nil(t, task)
+		time.Sleep(time.Duration(limit+10) * time.Millisecond)
+		task, err = queue.Dequeue("worker-1")
+		require.NoError(t, err)
+		require.NotNil(t, task)
+		assert.Equal(t, retry+1, task.Retries)
+	}
+
+	_, err = queue.EnqueueAt(time.Time{}, 0, nil, nil)
+	assert.Error(t, err)
+}
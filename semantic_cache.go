@@ -1,17 +1,20 @@
 // Semantic Cache for LLM responses
 //
 // Cache LLM responses with similarity-based retrieval for cost savings.
-// Uses database prefix scanning to store and retrieve cached responses.
+// Entries are stored by key, and their embeddings are kept in an indexed
+// collection per embedding dimension, so lookups walk the HNSW graph instead
+// of scoring every entry. Like other index searches, lookups are approximate.
+// Expired entries are skipped by lookups until PurgeExpired removes them.
 
 package sochdb
 
 import (
 	"encoding/json"
 	"fmt"
-	"math"
 	"time"
 
 	"github.com/sochdb/sochdb-go/embedded"
+	"github.com/sochdb/sochdb-go/keys"
 )
 
 // SemanticCacheEntry represents a cached response with embedding
@@ -24,6 +27,11 @@ type SemanticCacheEntry struct {
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
 }
 
+// expired reports whether the entry's TTL has passed at now, in seconds
+func (e *SemanticCacheEntry) expired(now int64) bool {
+	return e.TTL > 0 && e.Timestamp > 0 && now > e.Timestamp+e.TTL
+}
+
 // SemanticCacheHit represents a cache hit with similarity score
 type SemanticCacheHit struct {
 	SemanticCacheEntry
@@ -39,11 +47,17 @@ type SemanticCacheStats struct {
 	MemoryUsage int64   `json:"memory_usage"`
 }
 
+// semanticCacheFetch is the number of index results a lookup asks for
+// first; it doubles while every result is needed
+const semanticCacheFetch = 10
+
 // SemanticCache provides semantic caching for LLM responses
 type SemanticCache struct {
 	db        *embedded.Database
 	cacheName string
-	prefix    []byte
+	space     keys.Subspace
+	index     keys.Subspace
+	indexed   bool // entries stored before the index existed are indexed
 	hits      int
 	misses    int
 }
@@ -53,30 +67,27 @@ func NewSemanticCache(db *embedded.Database, cacheName string) *SemanticCache {
 	return &SemanticCache{
 		db:        db,
 		cacheName: cacheName,
-		prefix:    []byte(fmt.Sprintf("cache:%s:", cacheName)),
+		space:     semanticCacheSpace.Sub(cacheName),
+		index:     semanticIndexSpace.Sub(cacheName),
 		hits:      0,
 		misses:    0,
 	}
 }
 
-// semanticCosineSimilarity calculates cosine similarity between two vectors
-func semanticCosineSimilarity(a, b []float32) (float32, error) {
-	if len(a) != len(b) {
-		return 0, fmt.Errorf("vectors must have same length")
+// embeddings returns the collection indexing the cache's embeddings of
+// dimension
+func (c *SemanticCache) embeddings(dimension int) *Collection {
+	return &Collection{
+		db:   c.db,
+		name: c.cacheName,
+		config: CollectionConfig{
+			Name:      c.cacheName,
+			Dimension: dimension,
+			Metric:    DistanceMetricCosine,
+			Indexed:   true,
+		},
+		keyspace: c.index.Sub(int64(dimension)),
 	}
-
-	var dotProduct, normA, normB float32
-	for i := 0; i < len(a); i++ {
-		dotProduct += a[i] * b[i]
-		normA += a[i] * a[i]
-		normB += b[i] * b[i]
-	}
-
-	if normA == 0 || normB == 0 {
-		return 0, nil
-	}
-
-	return dotProduct / (float32(math.Sqrt(float64(normA))) * float32(math.Sqrt(float64(normB)))), nil
 }
 
 // Put stores a cached response
@@ -95,74 +106,199 @@ func (c *SemanticCache) Put(key, value string, embedding []float32, ttlSeconds i
 		return fmt.Errorf("failed to marshal cache entry: %w", err)
 	}
 
-	entryKey := append(c.prefix, []byte(key)...)
-	return c.db.Put(entryKey, entryBytes)
+	return retryConflicts(c.db, func(txn *embedded.Transaction) error {
+		// A replaced embedding of another dimension leaves its index
+		old, err := c.readEntry(txn, key)
+		if err != nil {
+			return err
+		}
+		if old != nil && len(old.Embedding) != len(embedding) {
+			if err := c.unindexEntry(txn, key, old); err != nil {
+				return err
+			}
+		}
+		if err := txn.Put(c.space.Pack(key), entryBytes); err != nil {
+			return err
+		}
+		return c.indexEntry(txn, key, embedding)
+	})
 }
 
-// Get retrieves cached response by similarity
+// Get retrieves the most similar cached response scoring above threshold
 func (c *SemanticCache) Get(queryEmbedding []float32, threshold float32) (*SemanticCacheHit, error) {
-	now := time.Now().Unix()
-	var bestMatch *SemanticCacheHit
-	bestScore := threshold
+	hits, err := c.lookup(queryEmbedding, threshold, true)
+	if err != nil {
+		return nil, err
+	}
 
-	// Begin transaction
-	txn := c.db.Begin()
-	defer txn.Abort()
+	if len(hits) == 0 {
+		c.misses++
+		return nil, nil
+	}
+	c.hits++
+	return &hits[0], nil
+}
 
-	// Scan all cache entries with this prefix
-	iter := txn.ScanPrefix(c.prefix)
-	defer iter.Close()
+// Search returns every live cached response whose cosine similarity to
+// queryEmbedding is above threshold, most similar first. It does not count
+// towards the hit rate.
+func (c *SemanticCache) Search(queryEmbedding []float32, threshold float32) ([]SemanticCacheHit, error) {
+	return c.lookup(queryEmbedding, threshold, false)
+}
 
-	for {
-		_, value, ok := iter.Next()
-		if !ok {
-			break
-		}
+// lookup searches the index for live entries scoring above threshold, most
+// similar first. The search is widened until the index returns fewer
+// results than asked for, or, with first, until a live entry is found.
+func (c *SemanticCache) lookup(query []float32, threshold float32, first bool) ([]SemanticCacheHit, error) {
+	if len(query) == 0 {
+		return []SemanticCacheHit{}, nil
+	}
+	if err := c.indexLegacyEntries(); err != nil {
+		return nil, err
+	}
 
-		var entry SemanticCacheEntry
-		if err := json.Unmarshal(value, &entry); err != nil {
-			continue
+	embeddings := c.embeddings(len(query))
+	for k := semanticCacheFetch; ; k = saturatingMul(k, 2) {
+		results, err := embeddings.Search(SearchRequest{QueryVector: query, K: k, MinScore: &threshold})
+		if err != nil {
+			return nil, err
 		}
 
-		// Check TTL expiration
-		if entry.TTL > 0 && entry.Timestamp > 0 {
-			expiresAt := entry.Timestamp + entry.TTL
-			if now > expiresAt {
-				continue
+		hits := []SemanticCacheHit{}
+		now := time.Now().Unix()
+		err = withTxn(c.db, func(txn *embedded.Transaction) error {
+			for _, r := range results {
+				// Hits must score above the threshold, not at it
+				if r.Score <= threshold {
+					break
+				}
+				entry, err := c.readEntry(txn, r.ID)
+				if err != nil {
+					return err
+				}
+				if entry != nil && !entry.expired(now) {
+					hits = append(hits, SemanticCacheHit{SemanticCacheEntry: *entry, Score: r.Score})
+				}
 			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
 
-		// Calculate similarity
-		score, err := semanticCosineSimilarity(queryEmbedding, entry.Embedding)
-		if err != nil {
-			continue
+		if len(results) < k || first && len(hits) > 0 {
+			return hits, nil
 		}
+	}
+}
 
-		// Update best match
-		if score > bestScore {
-			bestScore = score
-			bestMatch = &SemanticCacheHit{
-				SemanticCacheEntry: entry,
-				Score:              score,
-			}
+// readEntry loads the entry under key; nil means it is absent or cannot be
+// decoded
+func (c *SemanticCache) readEntry(txn *embedded.Transaction, key string) (*SemanticCacheEntry, error) {
+	data, err := txn.Get(c.space.Pack(key))
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var entry SemanticCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+// indexEntry stores the embedding of the entry under key in the index of
+// its dimension within txn
+func (c *SemanticCache) indexEntry(txn *embedded.Transaction, key string, embedding []float32) error {
+	if len(embedding) == 0 {
+		return nil
+	}
+	embeddings := c.embeddings(len(embedding))
+
+	versions, err := embeddings.loadIndexVersions(txn)
+	if err != nil {
+		return err
+	}
+	if versions.Active == 0 {
+		if err := embeddings.initIndex(txn); err != nil {
+			return err
 		}
 	}
 
-	_ = txn.Commit()
+	p, err := embeddings.prepareVector(key, vectorData{Vector: embedding, Timestamp: time.Now().UnixMilli()})
+	if err != nil {
+		return err
+	}
+	created, err := embeddings.storeVector(txn, p, nil)
+	if err != nil || !created {
+		return err
+	}
+	return embeddings.addCount(txn, 1)
+}
 
-	if bestMatch != nil {
-		c.hits++
-	} else {
-		c.misses++
+// unindexEntry removes the embedding of entry, stored under key, from the
+// index within txn
+func (c *SemanticCache) unindexEntry(txn *embedded.Transaction, key string, entry *SemanticCacheEntry) error {
+	if entry == nil || len(entry.Embedding) == 0 {
+		return nil
+	}
+	return c.embeddings(len(entry.Embedding)).deleteVector(txn, key)
+}
+
+// indexLegacyEntries indexes the embeddings of entries stored before the
+// cache kept an index. It runs once per cache.
+func (c *SemanticCache) indexLegacyEntries() error {
+	if c.indexed {
+		return nil
 	}
 
-	return bestMatch, nil
+	err := retryConflicts(c.db, func(txn *embedded.Transaction) error {
+		marker := c.index.Pack("indexed")
+		done, err := txn.Get(marker)
+		if err != nil || done != nil {
+			return err
+		}
+
+		var entries []SemanticCacheEntry
+		iter := txn.ScanPrefix(c.space.Bytes())
+		for {
+			_, value, ok := iter.Next()
+			if !ok {
+				break
+			}
+			var entry SemanticCacheEntry
+			if err := json.Unmarshal(value, &entry); err == nil {
+				entries = append(entries, entry)
+			}
+		}
+		iter.Close()
+		if err := iter.Err(); err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if err := c.indexEntry(txn, entry.Key, entry.Embedding); err != nil {
+				return err
+			}
+		}
+		return txn.Put(marker, []byte{})
+	})
+	c.indexed = err == nil
+	return err
 }
 
 // Delete removes a specific cache entry
 func (c *SemanticCache) Delete(key string) error {
-	entryKey := append(c.prefix, []byte(key)...)
-	return c.db.Delete(entryKey)
+	return retryConflicts(c.db, func(txn *embedded.Transaction) error {
+		entry, err := c.readEntry(txn, key)
+		if err != nil {
+			return err
+		}
+		if err := c.unindexEntry(txn, key, entry); err != nil {
+			return err
+		}
+		return txn.Delete(c.space.Pack(key))
+	})
 }
 
 // Clear removes all entries in this cache
@@ -175,7 +311,7 @@ func (c *SemanticCache) Clear() (int, error) {
 	defer txn.Abort()
 
 	// Collect keys to delete
-	iter := txn.ScanPrefix(c.prefix)
+	iter := txn.ScanPrefix(c.space.Bytes())
 	defer iter.Close()
 
 	for {
@@ -189,6 +325,12 @@ func (c *SemanticCache) Clear() (int, error) {
 		toDelete = append(toDelete, keyCopy)
 	}
 
+	// The index is dropped with the entries
+	indexKeys, err := collectKeys(txn, c.index.Bytes())
+	if err != nil {
+		return 0, err
+	}
+
 	_ = txn.Commit()
 
 	// Delete collected keys
@@ -198,6 +340,11 @@ func (c *SemanticCache) Clear() (int, error) {
 		}
 		deleted++
 	}
+	for _, key := range indexKeys {
+		if err := c.db.Delete(key); err != nil {
+			return deleted, err
+		}
+	}
 
 	// Reset stats
 	c.hits = 0
@@ -216,7 +363,7 @@ func (c *SemanticCache) Stats() (*SemanticCacheStats, error) {
 	txn := c.db.Begin()
 	defer txn.Abort()
 
-	iter := txn.ScanPrefix(c.prefix)
+	iter := txn.ScanPrefix(c.space.Bytes())
 	defer iter.Close()
 
 	for {
@@ -231,11 +378,8 @@ func (c *SemanticCache) Stats() (*SemanticCacheStats, error) {
 		}
 
 		// Skip expired entries
-		if entry.TTL > 0 && entry.Timestamp > 0 {
-			expiresAt := entry.Timestamp + entry.TTL
-			if now > expiresAt {
-				continue
-			}
+		if entry.expired(now) {
+			continue
 		}
 
 		count++
@@ -263,18 +407,18 @@ func (c *SemanticCache) Stats() (*SemanticCacheStats, error) {
 func (c *SemanticCache) PurgeExpired() (int, error) {
 	now := time.Now().Unix()
 	purged := 0
-	toDelete := [][]byte{}
+	toDelete := []string{}
 
 	// Begin transaction for scanning
 	txn := c.db.Begin()
 	defer txn.Abort()
 
 	// Collect expired keys
-	iter := txn.ScanPrefix(c.prefix)
+	iter := txn.ScanPrefix(c.space.Bytes())
 	defer iter.Close()
 
 	for {
-		key, value, ok := iter.Next()
+		_, value, ok := iter.Next()
 		if !ok {
 			break
 		}
@@ -284,21 +428,16 @@ func (c *SemanticCache) PurgeExpired() (int, error) {
 			continue
 		}
 
-		if entry.TTL > 0 && entry.Timestamp > 0 {
-			expiresAt := entry.Timestamp + entry.TTL
-			if now > expiresAt {
-				keyCopy := make([]byte, len(key))
-				copy(keyCopy, key)
-				toDelete = append(toDelete, keyCopy)
-			}
+		if entry.expired(now) {
+			toDelete = append(toDelete, entry.Key)
 		}
 	}
 
 	_ = txn.Commit()
 
-	// Delete expired keys
+	// Delete expired entries with their embeddings
 	for _, key := range toDelete {
-		if err := c.db.Delete(key); err != nil {
+		if err := c.Delete(key); err != nil {
 			return purged, err
 		}
 		purged++
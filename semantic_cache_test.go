@@ -0,0 +1,131 @@
+package sochdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/sochdb/sochdb-go/embedded"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSemanticCacheSearch tests similarity lookups against the threshold
+func TestSemanticCacheSearch(t *testing.T) {
+	db := openTestDB(t)
+
+	cache := NewSemanticCache(db, "answers")
+	require.NoError(t, cache.Put("a", "A", []float32{1, 0}, 0, nil))
+	require.NoError(t, cache.Put("b", "B", []float32{1, 0.2}, 0, nil))
+	require.NoError(t, cache.Put("c", "C", []float32{0, 1}, 0, nil))
+	hits, err := cache.Search([]float32{1, 0}, 0.9)
+	require.NoError(t, err)
+	require.Len(t, hits, 2)
+	assert.Equal(t, "a", hits[0].Key)
+	assert.Equal(t, "b", hits[1].Key)
+
+	hit, err := cache.Get([]float32{0, 1}, 0.9)
+	require.NoError(t, err)
+	require.NotNil(t, hit)
+	assert.Equal(t, "C", hit.Value)
+	hit, err = cache.Get([]float32{-1, -1}, 0.9)
+	require.NoError(t, err)
+	assert.Nil(t, hit)
+	stats, err := cache.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Hits)
+	assert.Equal(t, 1, stats.Misses)
+
+	// Hits must score above the threshold, not at it
+	hit, err = cache.Get([]float32{1, 0}, 1)
+	require.NoError(t, err)
+	assert.Nil(t, hit)
+	hits, err = cache.Search([]float32{0, 1}, 1)
+	require.NoError(t, err)
+	assert.Empty(t, hits)
+}
+
+// TestSemanticCacheIndex tests that lookups walk the embedding index and
+// that the index follows the entries
+func TestSemanticCacheIndex(t *testing.T) {
+	db := openTestDB(t)
+
+	// Entries stored before the index existed are indexed on first lookup
+	legacy, err := json.Marshal(SemanticCacheEntry{Key: "legacy", Value: "L", Embedding: []float32{0, 0, 1}})
+	require.NoError(t, err)
+	require.NoError(t, db.Put(semanticCacheSpace.Pack("answers", "legacy"), legacy))
+
+	cache := NewSemanticCache(db, "answers")
+	rng := rand.New(rand.NewSource(3))
+	vectors := make(map[string][]float32)
+	for i := 0; i < 300; i++ {
+		v := make([]float32, 8)
+		for j := range v {
+			v[j] = rng.Float32()*2 - 1
+		}
+		key := fmt.Sprintf("q%03d", i)
+		vectors[key] = v
+		require.NoError(t, cache.Put(key, key, v, 0, nil))
+	}
+	for _, key := range []string{"q000", "q123", "q299"} {
+		hit, err := cache.Get(vectors[key], 0.999)
+		require.NoError(t, err)
+		require.NotNil(t, hit)
+		assert.Equal(t, key, hit.Key)
+	}
+	require.NoError(t, withTxn(db, func(txn *embedded.Transaction) error {
+		versions, err := cache.embeddings(8).loadIndexVersions(txn)
+		assert.NotZero(t, versions.Active)
+		return err
+	}))
+	hit, err := cache.Get([]float32{0, 0, 1}, 0.9)
+	require.NoError(t, err)
+	require.NotNil(t, hit)
+	assert.Equal(t, "L", hit.Value)
+
+	// Replacing an embedding moves it to the index of its dimension
+	require.NoError(t, cache.Put("legacy", "L2", []float32{0, 1}, 0, nil))
+	hits, err := cache.Search([]float32{0, 0, 1}, 0.9)
+	require.NoError(t, err)
+	assert.Empty(t, hits)
+	hit, err = cache.Get([]float32{0, 1}, 0.9)
+	require.NoError(t, err)
+	require.NotNil(t, hit)
+	assert.Equal(t, "L2", hit.Value)
+	require.NoError(t, cache.Delete("legacy"))
+	hit, err = cache.Get([]float32{0, 1}, 0.9)
+	require.NoError(t, err)
+	assert.Nil(t, hit)
+
+	// Expired entries are skipped, then purged with their embeddings
+	expired, err := json.Marshal(SemanticCacheEntry{Key: "old", Value: "O", Embedding: []float32{1, 0}, Timestamp: 1, TTL: 1})
+	require.NoError(t, err)
+	require.NoError(t, withTxn(db, func(txn *embedded.Transaction) error {
+		if err := txn.Put(cache.space.Pack("old"), expired); err != nil {
+			return err
+		}
+		return cache.indexEntry(txn, "old", []float32{1, 0})
+	}))
+	hit, err = cache.Get([]float32{1, 0}, 0.9)
+	require.NoError(t, err)
+	assert.Nil(t, hit)
+	purged, err := cache.PurgeExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	require.NoError(t, withTxn(db, func(txn *embedded.Transaction) error {
+		value, err := txn.Get(cache.embeddings(2).vectorKey("old"))
+		assert.Nil(t, value)
+		return err
+	}))
+
+	cleared, err := cache.Clear()
+	require.NoError(t, err)
+	assert.Equal(t, 300, cleared)
+	require.NoError(t, withTxn(db, func(txn *embedded.Transaction) error {
+		found, err := collectKeys(txn, cache.index.Bytes())
+		assert.Empty(t, found)
+		return err
+	}))
+}
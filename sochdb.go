@@ -56,7 +56,9 @@
 //	defer db.Close()
 //
 //	// Create namespace
-//	ns, err := db.CreateNamespace("tenant_123")
+//	ns, err := sochdb.NewNamespaceManager(db).CreateNamespace(sochdb.NamespaceConfig{
+//	    Name: "tenant_123",
+//	})
 //	if err != nil {
 //	    log.Fatal(err)
 //	}
@@ -71,7 +73,7 @@
 //	}
 //
 //	// Insert vectors
-//	err = collection.Insert([]float32{1.0, 2.0, 3.0}, map[string]interface{}{"source": "web"}, "")
+//	_, err = collection.Insert([]float32{1.0, 2.0, 3.0}, map[string]interface{}{"source": "web"}, "")
 //
 // Example (Priority Queue - v0.4.1):
 //
@@ -101,7 +103,7 @@
 //	}
 //	if task != nil {
 //	    // Process task...
-//	    err = queue.Ack(task.TaskID)
+//	    err = queue.AckClaim(task.TaskID, "worker-1")
 //	}
 package sochdb
 
@@ -0,0 +1,224 @@
+// Typed tables for Go structs
+//
+// Table[T] stores values of a single Go type under string primary keys,
+// marshaled with a pluggable Codec instead of hand-rolled JSON around
+// db.Put.
+//
+// Example:
+//
+//	type User struct {
+//	    Name  string
+//	    Email string
+//	}
+//
+//	users := sochdb.NewTable[User](db, "users", &sochdb.TableOptions[User]{
+//	    Codec:         sochdb.MsgPackCodec,
+//	    SchemaVersion: 2,
+//	})
+//
+//	err := users.Put("u1", User{Name: "Alice", Email: "alice@example.com"})
+//	user, err := users.Get("u1")
+//	err = users.Update("u1", func(u *User) error {
+//	    u.Email = "alice@example.org"
+//	    return nil
+//	})
+
+package sochdb
+
+import (
+	"github.com/sochdb/sochdb-go/embedded"
+	"github.com/sochdb/sochdb-go/keys"
+)
+
+// TableOptions configures a typed table
+type TableOptions[T any] struct {
+	// Codec used for new writes. Defaults to JSONCodec. Rows written with a
+	// different built-in or registered codec remain readable.
+	Codec Codec
+
+	// SchemaVersion is stamped on every written row. Defaults to 1.
+	SchemaVersion uint32
+
+	// Upgrade is called when a row with an older schema version is read,
+	// after it has been decoded into value.
+	Upgrade func(fromVersion uint32, value *T) error
+
+	// Indexes declares secondary indexes maintained on every write
+	Indexes []TableIndex[T]
+}
+
+// Record is a decoded table row
+type Record[T any] struct {
+	Key           string
+	Value         T
+	SchemaVersion uint32
+}
+
+// Table stores typed values under string primary keys
+type Table[T any] struct {
+	db            *embedded.Database
+	name          string
+	space         keys.Subspace
+	codec         Codec
+	schemaVersion uint32
+	upgrade       func(uint32, *T) error
+	indexes       []TableIndex[T]
+}
+
+// TypedCollection is an alias of Table for code that thinks of its data as
+// document collections.
+type TypedCollection[T any] = Table[T]
+
+// NewTable creates a typed table handle
+func NewTable[T any](db *embedded.Database, name string, opts *TableOptions[T]) *Table[T] {
+	t := &Table[T]{
+		db:            db,
+		name:          name,
+		space:         tableSpace.Sub(name),
+		codec:         JSONCodec,
+		schemaVersion: 1,
+	}
+
+	if opts != nil {
+		if opts.Codec != nil {
+			t.codec = opts.Codec
+		}
+		if opts.SchemaVersion > 0 {
+			t.schemaVersion = opts.SchemaVersion
+		}
+		t.upgrade = opts.Upgrade
+		t.indexes = append(t.indexes, opts.Indexes...)
+	}
+
+	return t
+}
+
+// Name returns the table name
+func (t *Table[T]) Name() string {
+	return t.name
+}
+
+// Put stores a value under key, replacing any existing value
+func (t *Table[T]) Put(key string, value T) error {
+	return t.db.WithTransaction(func(txn *embedded.Transaction) error {
+		return t.writeRow(txn, key, &value)
+	})
+}
+
+// Get retrieves the value stored under key
+// Returns nil if the key does not exist
+func (t *Table[T]) Get(key string) (*T, error) {
+	data, err := t.db.Get(t.rowKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	record, err := t.decode(key, data)
+	if err != nil {
+		return nil, err
+	}
+	return &record.Value, nil
+}
+
+// Update applies fn to the stored value and writes the result back in a
+// single transaction. Returns ErrNotFound if the key does not exist.
+func (t *Table[T]) Update(key string, fn func(value *T) error) error {
+	return t.db.WithTransaction(func(txn *embedded.Transaction) error {
+		rowKey := t.rowKey(key)
+
+		data, err := txn.Get(rowKey)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return ErrNotFound
+		}
+
+		record, err := t.decode(key, data)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(&record.Value); err != nil {
+			return err
+		}
+
+		return t.writeRow(txn, key, &record.Value)
+	})
+}
+
+// Delete removes the value stored under key
+func (t *Table[T]) Delete(key string) error {
+	return t.db.WithTransaction(func(txn *embedded.Transaction) error {
+		return t.deleteRow(txn, key)
+	})
+}
+
+// Scan returns all rows whose key starts with prefix, in key order
+// An empty prefix scans the whole table.
+func (t *Table[T]) Scan(prefix string) ([]Record[T], error) {
+	records := []Record[T]{}
+	rows := t.space.Sub("rows")
+
+	txn := t.db.Begin()
+	defer txn.Abort()
+
+	iter := txn.ScanPrefix(t.space.PackPrefix("rows", prefix))
+	defer iter.Close()
+
+	for {
+		key, value, ok := iter.Next()
+		if !ok {
+			break
+		}
+
+		tuple, err := rows.Unpack(key)
+		if err != nil || len(tuple) != 1 {
+			continue
+		}
+		pk, ok := tuple[0].(string)
+		if !ok {
+			continue
+		}
+
+		record, err := t.decode(pk, value)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *record)
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	_ = txn.Commit()
+	return records, nil
+}
+
+// Helper methods
+func (t *Table[T]) rowKey(key string) []byte {
+	return t.space.Pack("rows", key)
+}
+
+func (t *Table[T]) decode(key string, data []byte) (*Record[T], error) {
+	record := &Record[T]{Key: key}
+
+	version, err := decodeValue(t.codec, data, &record.Value)
+	if err != nil {
+		return nil, err
+	}
+	record.SchemaVersion = version
+
+	if version < t.schemaVersion && t.upgrade != nil {
+		if err := t.upgrade(version, &record.Value); err != nil {
+			return nil, err
+		}
+		record.SchemaVersion = t.schemaVersion
+	}
+
+	return record, nil
+}
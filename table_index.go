@@ -0,0 +1,483 @@
+// Secondary indexes for typed tables
+//
+// Indexes are declared on TableOptions and maintained in the same
+// transaction as the row write, so a lookup never sees a row without its
+// index entries or an index entry without its row.
+//
+// Example:
+//
+//	users := sochdb.NewTable[User](db, "users", &sochdb.TableOptions[User]{
+//	    Indexes: []sochdb.TableIndex[User]{
+//	        {Name: "by_email", Field: "email", Unique: true},
+//	        {Name: "by_city", Field: "address.city"},
+//	    },
+//	})
+//
+//	matches, err := users.LookupByIndex("by_city", "Berlin")
+
+package sochdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sochdb/sochdb-go/embedded"
+	"github.com/sochdb/sochdb-go/keys"
+)
+
+// TableIndex declares a secondary index on a typed table
+type TableIndex[T any] struct {
+	// Name identifies the index in lookups
+	Name string
+
+	// Field is a dotted path into the JSON form of the row, such as "email"
+	// or "address.city". Array values index the row once per element.
+	Field string
+
+	// Extract computes index values from a row and takes precedence over
+	// Field. Returning no values leaves the row out of the index.
+	Extract func(value *T) ([]interface{}, error)
+
+	// Unique rejects writes that would give two rows the same index value
+	Unique bool
+}
+
+// UniqueConstraintError is returned when a write violates a unique index
+type UniqueConstraintError struct {
+	Table string
+	Index string
+	Value interface{}
+}
+
+func (e *UniqueConstraintError) Error() string {
+	return fmt.Sprintf("unique index %s on table %s already contains value %v", e.Index, e.Table, e.Value)
+}
+
+// LookupByIndex returns all rows whose index value equals value
+func (t *Table[T]) LookupByIndex(index string, value interface{}) ([]Record[T], error) {
+	idx, err := t.index(index)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized, err := normalizeIndexValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := t.indexSpace(idx.Name).Pack(normalized)
+	return t.lookup(idx.Name, prefix, nil, nil)
+}
+
+// LookupRange returns all rows whose index value lies in [start, end), in
+// index order. A nil start or end leaves that side of the range open.
+func (t *Table[T]) LookupRange(index string, start, end interface{}) ([]Record[T], error) {
+	idx, err := t.index(index)
+	if err != nil {
+		return nil, err
+	}
+
+	space := t.indexSpace(idx.Name)
+
+	var lower, upper []byte
+	if start != nil {
+		normalized, err := normalizeIndexValue(start)
+		if err != nil {
+			return nil, err
+		}
+		lower = space.Pack(normalized)
+	}
+	if end != nil {
+		normalized, err := normalizeIndexValue(end)
+		if err != nil {
+			return nil, err
+		}
+		upper = space.Pack(normalized)
+	}
+
+	return t.lookup(idx.Name, space.Bytes(), lower, upper)
+}
+
+// RebuildIndex recomputes an index from the stored rows, for indexes added
+// to a table that already has data.
+func (t *Table[T]) RebuildIndex(index string) error {
+	idx, err := t.index(index)
+	if err != nil {
+		return err
+	}
+
+	return t.db.WithTransaction(func(txn *embedded.Transaction) error {
+		space := t.indexSpace(idx.Name)
+
+		stale, err := collectKeys(txn, space.Bytes())
+		if err != nil {
+			return err
+		}
+		for _, key := range stale {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		rows := t.space.Sub("rows")
+		iter := txn.ScanPrefix(rows.Bytes())
+		defer iter.Close()
+
+		for {
+			key, value, ok := iter.Next()
+			if !ok {
+				break
+			}
+
+			tuple, err := rows.Unpack(key)
+			if err != nil || len(tuple) != 1 {
+				continue
+			}
+			pk, _ := tuple[0].(string)
+
+			var row T
+			if _, err := decodeValue(t.codec, value, &row); err != nil {
+				return err
+			}
+			if err := t.addIndexEntries(txn, idx, pk, &row); err != nil {
+				return err
+			}
+		}
+
+		return iter.Err()
+	})
+}
+
+// Helper methods
+func (t *Table[T]) index(name string) (*TableIndex[T], error) {
+	for i := range t.indexes {
+		if t.indexes[i].Name == name {
+			return &t.indexes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("index not found: %s", name)
+}
+
+func (t *Table[T]) indexSpace(name string) keys.Subspace {
+	return t.space.Sub("index", name)
+}
+
+// lookup scans index entries under prefix, keeps those in [lower, upper)
+// and loads the referenced rows.
+func (t *Table[T]) lookup(index string, prefix, lower, upper []byte) ([]Record[T], error) {
+	records := []Record[T]{}
+	space := t.indexSpace(index)
+
+	txn := t.db.Begin()
+	defer txn.Abort()
+
+	iter := txn.ScanPrefix(prefix)
+	defer iter.Close()
+
+	for {
+		key, _, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if lower != nil && bytes.Compare(key, lower) < 0 {
+			continue
+		}
+		if upper != nil && bytes.Compare(key, upper) >= 0 {
+			break
+		}
+
+		tuple, err := space.Unpack(key)
+		if err != nil || len(tuple) != 2 {
+			continue
+		}
+		pk, ok := tuple[1].(string)
+		if !ok {
+			continue
+		}
+
+		data, err := txn.Get(t.rowKey(pk))
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+
+		record, err := t.decode(pk, data)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *record)
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	_ = txn.Commit()
+	return records, nil
+}
+
+// writeRow stores a row and moves its index entries from the previous
+// version of the row to the new one, within txn.
+func (t *Table[T]) writeRow(txn *embedded.Transaction, key string, value *T) error {
+	if err := t.removeRowIndexes(txn, key); err != nil {
+		return err
+	}
+
+	for i := range t.indexes {
+		if err := t.addIndexEntries(txn, &t.indexes[i], key, value); err != nil {
+			return err
+		}
+	}
+
+	data, err := encodeValue(t.codec, t.schemaVersion, value)
+	if err != nil {
+		return err
+	}
+	return txn.Put(t.rowKey(key), data)
+}
+
+// deleteRow removes a row and its index entries within txn
+func (t *Table[T]) deleteRow(txn *embedded.Transaction, key string) error {
+	if err := t.removeRowIndexes(txn, key); err != nil {
+		return err
+	}
+	return txn.Delete(t.rowKey(key))
+}
+
+func (t *Table[T]) removeRowIndexes(txn *embedded.Transaction, key string) error {
+	if len(t.indexes) == 0 {
+		return nil
+	}
+
+	data, err := txn.Get(t.rowKey(key))
+	if err != nil || data == nil {
+		return err
+	}
+
+	// Decode without upgrading: entries were derived from the row as written
+	var old T
+	if _, err := decodeValue(t.codec, data, &old); err != nil {
+		return err
+	}
+
+	for i := range t.indexes {
+		idx := &t.indexes[i]
+		values, err := indexValues(idx, &old)
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			if err := txn.Delete(t.indexSpace(idx.Name).Pack(v, key)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Table[T]) addIndexEntries(txn *embedded.Transaction, idx *TableIndex[T], key string, value *T) error {
+	values, err := indexValues(idx, value)
+	if err != nil {
+		return err
+	}
+
+	space := t.indexSpace(idx.Name)
+	for _, v := range values {
+		if idx.Unique {
+			existing, err := collectKeys(txn, space.Pack(v))
+			if err != nil {
+				return err
+			}
+			for _, entry := range existing {
+				tuple, err := space.Unpack(entry)
+				if err == nil && len(tuple) == 2 && tuple[1] != key {
+					return &UniqueConstraintError{Table: t.name, Index: idx.Name, Value: indexValueOf(v)}
+				}
+			}
+		}
+
+		// Index entries carry no value; the key is the whole entry
+		if err := txn.Put(space.Pack(v, key), []byte{1}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexValues computes the distinct, normalized index values of a row
+func indexValues[T any](idx *TableIndex[T], value *T) ([]interface{}, error) {
+	var raw []interface{}
+
+	if idx.Extract != nil {
+		extracted, err := idx.Extract(value)
+		if err != nil {
+			return nil, err
+		}
+		raw = extracted
+	} else {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		var doc interface{}
+		if err := unmarshalNumbers(data, &doc); err != nil {
+			return nil, err
+		}
+
+		field := lookupJSONPath(doc, idx.Field)
+		if list, ok := field.([]interface{}); ok {
+			raw = list
+		} else {
+			raw = []interface{}{field}
+		}
+	}
+
+	values := make([]interface{}, 0, len(raw))
+	seen := make(map[string]bool, len(raw))
+	for _, v := range raw {
+		if v == nil {
+			continue
+		}
+		normalized, err := normalizeIndexValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("index %s: %w", idx.Name, err)
+		}
+		packed := string(keys.Pack(normalized))
+		if !seen[packed] {
+			seen[packed] = true
+			values = append(values, normalized)
+		}
+	}
+	return values, nil
+}
+
+// lookupJSONPath walks a dotted path through decoded JSON objects
+func lookupJSONPath(doc interface{}, path string) interface{} {
+	current := doc
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = obj[part]
+	}
+	return current
+}
+
+// normalizeIndexValue maps index values onto the tuple types they are
+// stored as. Numbers become a nested tuple of their float64 value followed,
+// for integral numbers, by the exact int64 or uint64. So 5 and 5.0 match,
+// ranges over mixed integer and float values sort correctly, and distinct
+// integers above 2^53 keep distinct keys.
+func normalizeIndexValue(v interface{}) (interface{}, error) {
+	switch n := v.(type) {
+	case string, bool, []byte:
+		return n, nil
+	case int:
+		return intIndexValue(int64(n)), nil
+	case int8:
+		return intIndexValue(int64(n)), nil
+	case int16:
+		return intIndexValue(int64(n)), nil
+	case int32:
+		return intIndexValue(int64(n)), nil
+	case int64:
+		return intIndexValue(n), nil
+	case uint:
+		return uintIndexValue(uint64(n)), nil
+	case uint8:
+		return uintIndexValue(uint64(n)), nil
+	case uint16:
+		return uintIndexValue(uint64(n)), nil
+	case uint32:
+		return uintIndexValue(uint64(n)), nil
+	case uint64:
+		return uintIndexValue(n), nil
+	case float32:
+		return floatIndexValue(float64(n)), nil
+	case float64:
+		return floatIndexValue(n), nil
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return intIndexValue(i), nil
+		}
+		if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+			return uintIndexValue(u), nil
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", n)
+		}
+		return floatIndexValue(f), nil
+	default:
+		return nil, fmt.Errorf("unsupported index value type %T", v)
+	}
+}
+
+func intIndexValue(n int64) keys.Tuple {
+	return keys.Tuple{float64(n), n}
+}
+
+func uintIndexValue(n uint64) keys.Tuple {
+	return keys.Tuple{float64(n), n}
+}
+
+func floatIndexValue(f float64) keys.Tuple {
+	switch {
+	case f != math.Trunc(f) || math.IsInf(f, 0):
+		return keys.Tuple{f}
+	case f >= math.MinInt64 && f < math.MaxInt64:
+		return intIndexValue(int64(f))
+	case f >= 0 && f < math.MaxUint64:
+		return uintIndexValue(uint64(f))
+	default:
+		return keys.Tuple{f}
+	}
+}
+
+// indexValueOf returns the value a normalized index value was made from
+func indexValueOf(v interface{}) interface{} {
+	if t, ok := v.(keys.Tuple); ok && len(t) > 0 {
+		return t[len(t)-1]
+	}
+	return v
+}
+
+// isIntegerIndexValue reports whether a normalized index value is an
+// integral number
+func isIntegerIndexValue(v interface{}) bool {
+	t, ok := v.(keys.Tuple)
+	return ok && len(t) == 2
+}
+
+// unmarshalNumbers decodes JSON keeping numbers as json.Number, so integers
+// above 2^53 index exactly
+func unmarshalNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// collectKeys returns copies of all keys under prefix
+func collectKeys(txn *embedded.Transaction, prefix []byte) ([][]byte, error) {
+	var found [][]byte
+
+	iter := txn.ScanPrefix(prefix)
+	defer iter.Close()
+
+	for {
+		key, _, ok := iter.Next()
+		if !ok {
+			break
+		}
+		found = append(found, key)
+	}
+
+	return found, iter.Err()
+}
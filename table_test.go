@@ -0,0 +1,207 @@
+package sochdb
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/sochdb/sochdb-go/embedded"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type tableTestUser struct {
+	Name  string
+	Email string
+	Age   int
+}
+
+func openTestDB(t *testing.T) *embedded.Database {
+	t.Helper()
+	db, err := embedded.Open(filepath.Join(t.TempDir(), "db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestTableCodecs tests Put/Get/Scan round trips with each built-in codec
+func TestTableCodecs(t *testing.T) {
+	db := openTestDB(t)
+
+	for _, codec := range []Codec{JSONCodec, MsgPackCodec, GobCodec} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			users := NewTable[tableTestUser](db, "users_"+codec.Name(), &TableOptions[tableTestUser]{Codec: codec})
+
+			require.NoError(t, users.Put("user:1", tableTestUser{Name: "Alice", Age: 30}))
+			require.NoError(t, users.Put("user:2", tableTestUser{Name: "Bob", Age: 25}))
+			require.NoError(t, users.Put("group:1", tableTestUser{Name: "Admins"}))
+
+			user, err := users.Get("user:1")
+			require.NoError(t, err)
+			require.NotNil(t, user)
+			assert.Equal(t, "Alice", user.Name)
+
+			missing, err := users.Get("user:3")
+			require.NoError(t, err)
+			assert.Nil(t, missing)
+
+			records, err := users.Scan("user:")
+			require.NoError(t, err)
+			require.Len(t, records, 2)
+			assert.Equal(t, "user:1", records[0].Key)
+			assert.Equal(t, "Bob", records[1].Value.Name)
+			assert.Equal(t, uint32(1), records[1].SchemaVersion)
+		})
+	}
+
+	t.Run("protobuf", func(t *testing.T) {
+		names := NewTable[*wrapperspb.StringValue](db, "names", &TableOptions[*wrapperspb.StringValue]{Codec: ProtobufCodec})
+		require.NoError(t, names.Put("n1", wrapperspb.String("hello")))
+
+		value, err := names.Get("n1")
+		require.NoError(t, err)
+		assert.Equal(t, "hello", (*value).GetValue())
+	})
+}
+
+// TestTableUpdateAndUpgrade tests transactional updates and schema upgrades
+func TestTableUpdateAndUpgrade(t *testing.T) {
+	db := openTestDB(t)
+
+	v1 := NewTable[tableTestUser](db, "people", nil)
+	require.NoError(t, v1.Put("p1", tableTestUser{Name: "Carol"}))
+
+	// A newer reader upgrades v1 rows, even when it writes with another codec
+	v2 := NewTable[tableTestUser](db, "people", &TableOptions[tableTestUser]{
+		Codec:         MsgPackCodec,
+		SchemaVersion: 2,
+		Upgrade: func(from uint32, u *tableTestUser) error {
+			if from < 2 && u.Email == "" {
+				u.Email = "unknown@example.com"
+			}
+			return nil
+		},
+	})
+
+	user, err := v2.Get("p1")
+	require.NoError(t, err)
+	assert.Equal(t, "unknown@example.com", user.Email)
+
+	require.NoError(t, v2.Update("p1", func(u *tableTestUser) error {
+		u.Age++
+		return nil
+	}))
+
+	records, err := v2.Scan("")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, 1, records[0].Value.Age)
+	assert.Equal(t, uint32(2), records[0].SchemaVersion)
+
+	assert.ErrorIs(t, v2.Update("missing", func(*tableTestUser) error { return nil }), ErrNotFound)
+}
+
+// TestTableIndexes tests unique and non-unique secondary indexes
+func TestTableIndexes(t *testing.T) {
+	db := openTestDB(t)
+
+	users := NewTable[tableTestUser](db, "indexed_users", &TableOptions[tableTestUser]{
+		Indexes: []TableIndex[tableTestUser]{
+			{Name: "by_email", Field: "Email", Unique: true},
+			{Name: "by_age", Field: "Age"},
+		},
+	})
+
+	require.NoError(t, users.Put("u1", tableTestUser{Name: "Alice", Email: "a@example.com", Age: 30}))
+	require.NoError(t, users.Put("u2", tableTestUser{Name: "Bob", Email: "b@example.com", Age: 25}))
+	require.NoError(t, users.Put("u3", tableTestUser{Name: "Carol", Email: "c@example.com", Age: 30}))
+
+	var uniqueErr *UniqueConstraintError
+	err := users.Put("u4", tableTestUser{Name: "Mallory", Email: "a@example.com"})
+	assert.ErrorAs(t, err, &uniqueErr)
+
+	byEmail, err := users.LookupByIndex("by_email", "b@example.com")
+	require.NoError(t, err)
+	require.Len(t, byEmail, 1)
+	assert.Equal(t, "u2", byEmail[0].Key)
+
+	thirty, err := users.LookupByIndex("by_age", 30)
+	require.NoError(t, err)
+	assert.Len(t, thirty, 2)
+
+	// Updates move index entries
+	require.NoError(t, users.Update("u1", func(u *tableTestUser) error {
+		u.Email = "alice@example.com"
+		u.Age = 31
+		return nil
+	}))
+	old, err := users.LookupByIndex("by_email", "a@example.com")
+	require.NoError(t, err)
+	assert.Empty(t, old)
+
+	ranged, err := users.LookupRange("by_age", 26, nil)
+	require.NoError(t, err)
+	require.Len(t, ranged, 2)
+	assert.Equal(t, "u3", ranged[0].Key)
+	assert.Equal(t, "u1", ranged[1].Key)
+
+	require.NoError(t, users.Delete("u3"))
+	thirty, err = users.LookupByIndex("by_age", 30)
+	require.NoError(t, err)
+	assert.Empty(t, thirty)
+
+	// The freed unique value can be reused
+	require.NoError(t, users.Put("u4", tableTestUser{Name: "Dave", Email: "a@example.com"}))
+}
+
+// TestTableIndexNumbers tests exact integer keys and mixed numeric ranges
+func TestTableIndexNumbers(t *testing.T) {
+	db := openTestDB(t)
+
+	type account struct {
+		Number  int64
+		Serial  uint64
+		Balance float64
+	}
+	accounts := NewTable[account](db, "accounts", &TableOptions[account]{
+		Indexes: []TableIndex[account]{
+			{Name: "by_number", Field: "Number", Unique: true},
+			{Name: "by_serial", Field: "Serial", Unique: true},
+			{Name: "by_balance", Field: "Balance"},
+		},
+	})
+
+	// Distinct above 2^53, equal as float64
+	require.NoError(t, accounts.Put("a", account{Number: 1<<60 + 1, Serial: 1<<63 + 1, Balance: 2}))
+	require.NoError(t, accounts.Put("b", account{Number: 1<<60 + 2, Serial: 1<<63 + 2, Balance: 2.5}))
+	require.NoError(t, accounts.Put("c", account{Number: 7, Serial: 7, Balance: 3}))
+
+	found, err := accounts.LookupByIndex("by_number", int64(1<<60+2))
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "b", found[0].Key)
+	found, err = accounts.LookupByIndex("by_serial", uint64(1<<63+1))
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "a", found[0].Key)
+
+	var uniqueErr *UniqueConstraintError
+	err = accounts.Put("d", account{Number: 1<<60 + 1})
+	require.ErrorAs(t, err, &uniqueErr)
+	assert.Equal(t, int64(1<<60+1), uniqueErr.Value)
+
+	// Integers and floats compare by value
+	found, err = accounts.LookupByIndex("by_number", 7.0)
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+	ranged, err := accounts.LookupRange("by_balance", 2, 3)
+	require.NoError(t, err)
+	require.Len(t, ranged, 2)
+	assert.Equal(t, "a", ranged[0].Key)
+	assert.Equal(t, "b", ranged[1].Key)
+	ranged, err = accounts.LookupRange("by_balance", 2.2, nil)
+	require.NoError(t, err)
+	require.Len(t, ranged, 2)
+	assert.Equal(t, "b", ranged[0].Key)
+	assert.Equal(t, "c", ranged[1].Key)
+}
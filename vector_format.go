@@ -0,0 +1,453 @@
+// Binary vector storage format
+//
+// Collections store each vector as a compact binary record, with its metadata
+// kept under a separate key so scans over vectors never parse JSON:
+//
+//	version (1 byte) | encoding (1 byte) | dimension (uint32 LE) |
+//	timestamp (int64 LE, Unix milliseconds) | elements (LE)
+//
+// Vectors written by older releases as JSON documents are still readable and
+// can be rewritten in place with Collection.MigrateStorage.
+
+package sochdb
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/sochdb/sochdb-go/embedded"
+)
+
+// VectorEncoding selects how collection vectors are stored
+type VectorEncoding string
+
+const (
+	VectorEncodingFloat32  VectorEncoding = "float32"  // Exact; 4 bytes per element
+	VectorEncodingFloat16  VectorEncoding = "float16"  // IEEE half precision; 2 bytes per element
+	VectorEncodingBFloat16 VectorEncoding = "bfloat16" // float32 range, 8-bit mantissa; 2 bytes per element
+)
+
+const (
+	vectorFormatVersion    = 1
+	vectorHeaderSize       = 14
+	vectorMigrateBatchSize = 256
+)
+
+// Element encodings as stored in the record header
+const (
+	vectorElemFloat32  = 0
+	vectorElemFloat16  = 1
+	vectorElemBFloat16 = 2
+)
+
+// vectorMeta is the stored metadata of a vector
+type vectorMeta struct {
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// encodeVector builds a binary vector record
+func encodeVector(vector []float32, encoding VectorEncoding, timestamp int64) ([]byte, error) {
+	var elem byte
+	var width int
+	switch encoding {
+	case "", VectorEncodingFloat32:
+		elem, width = vectorElemFloat32, 4
+	case VectorEncodingFloat16:
+		elem, width = vectorElemFloat16, 2
+	case VectorEncodingBFloat16:
+		elem, width = vectorElemBFloat16, 2
+	default:
+		return nil, fmt.Errorf("unknown vector encoding: %s", encoding)
+	}
+
+	buf := make([]byte, vectorHeaderSize+len(vector)*width)
+	buf[0] = vectorFormatVersion
+	buf[1] = elem
+	binary.LittleEndian.PutUint32(buf[2:], uint32(len(vector)))
+	binary.LittleEndian.PutUint64(buf[6:], uint64(timestamp))
+
+	out := buf[vectorHeaderSize:]
+	for i, v := range vector {
+		switch elem {
+		case vectorElemFloat32:
+			binary.LittleEndian.PutUint32(out[i*4:], math.Float32bits(v))
+		case vectorElemFloat16:
+			binary.LittleEndian.PutUint16(out[i*2:], float32ToFloat16(v))
+		case vectorElemBFloat16:
+			binary.LittleEndian.PutUint16(out[i*2:], float32ToBFloat16(v))
+		}
+	}
+
+	return buf, nil
+}
+
+// decodeVector parses a binary vector record
+func decodeVector(data []byte) ([]float32, int64, error) {
+	if len(data) < vectorHeaderSize || data[0] != vectorFormatVersion {
+		return nil, 0, errors.New("invalid vector record")
+	}
+
+	var width int
+	switch data[1] {
+	case vectorElemFloat32:
+		width = 4
+	case vectorElemFloat16, vectorElemBFloat16:
+		width = 2
+	default:
+		return nil, 0, fmt.Errorf("unknown vector element encoding: %d", data[1])
+	}
+
+	dim := int(binary.LittleEndian.Uint32(data[2:]))
+	timestamp := int64(binary.LittleEndian.Uint64(data[6:]))
+	elems := data[vectorHeaderSize:]
+	if len(elems) != dim*width {
+		return nil, 0, errors.New("truncated vector record")
+	}
+
+	vector := make([]float32, dim)
+	for i := range vector {
+		switch data[1] {
+		case vectorElemFloat32:
+			vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(elems[i*4:]))
+		case vectorElemFloat16:
+			vector[i] = float16ToFloat32(binary.LittleEndian.Uint16(elems[i*2:]))
+		case vectorElemBFloat16:
+			vector[i] = math.Float32frombits(uint32(binary.LittleEndian.Uint16(elems[i*2:])) << 16)
+		}
+	}
+
+	return vector, timestamp, nil
+}
+
+// isLegacyVector reports whether a stored vector is a pre-binary JSON document
+func isLegacyVector(data []byte) bool {
+	return len(data) > 0 && data[0] == '{'
+}
+
+// readVector loads a vector and its metadata within txn; nil means absent
+func (c *Collection) readVector(txn *embedded.Transaction, id string) (*vectorData, error) {
+	value, err := txn.Get(c.vectorKey(id))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	if isLegacyVector(value) {
+		var data vectorData
+		if err := json.Unmarshal(value, &data); err != nil {
+			return nil, err
+		}
+		return &data, nil
+	}
+
+	vector, timestamp, err := decodeVector(value)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := c.readMetadata(txn, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &vectorData{Vector: vector, Metadata: metadata, Timestamp: timestamp}, nil
+}
+
+// storedMetadata decodes the metadata of a stored vector from its record
+// and meta key values, returning nil if it has none. Numbers decode as
+// json.Number so they produce the same index keys as when they were stored.
+func storedMetadata(record, meta []byte) map[string]interface{} {
+	if isLegacyVector(record) {
+		var data vectorData
+		if unmarshalNumbers(record, &data) == nil {
+			return data.Metadata
+		}
+		return nil
+	}
+
+	var stored vectorMeta
+	if meta == nil || unmarshalNumbers(meta, &stored) != nil {
+		return nil
+	}
+	return stored.Metadata
+}
+
+// preparedVector holds the encoded keys of a vector, ready to store
+type preparedVector struct {
+	id       string
+	record   []byte
+	meta     []byte // nil without metadata
+	code     []byte // nil without a codebook
+	metadata map[string]interface{}
+	named    map[string][]byte // nil leaves named vectors unchanged
+}
+
+// prepareVector encodes a vector for storage. It does no I/O, so batches can
+// prepare vectors in parallel.
+func (c *Collection) prepareVector(id string, data vectorData) (*preparedVector, error) {
+	record, err := encodeVector(data.Vector, c.config.VectorEncoding, data.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &preparedVector{id: id, record: record, code: c.encodeCode(data.Vector), metadata: data.Metadata}
+	if len(data.Metadata) > 0 {
+		if p.meta, err = json.Marshal(vectorMeta{Metadata: data.Metadata}); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// writeVector stores a vector and its metadata within txn, charging the
+// namespace for the change. It reports whether the vector is new.
+func (c *Collection) writeVector(txn *embedded.Transaction, id string, data vectorData) (bool, error) {
+	p, err := c.prepareVector(id, data)
+	if err != nil {
+		return false, err
+	}
+	return c.storeVector(txn, p, nil)
+}
+
+// storeVector writes a prepared vector within txn; see writeVector. Index
+// updates are added to batch if it is not nil.
+func (c *Collection) storeVector(txn *embedded.Transaction, p *preparedVector, batch indexBatch) (bool, error) {
+	vectorKey := c.vectorKey(p.id)
+	metaKey := c.vectorMetaKey(p.id)
+
+	oldRecord, err := txn.Get(vectorKey)
+	if err != nil {
+		return false, err
+	}
+	oldMeta, err := txn.Get(metaKey)
+	if err != nil {
+		return false, err
+	}
+
+	delta := addUsage(keyUsageDelta(vectorKey, oldRecord, p.record), keyUsageDelta(metaKey, oldMeta, p.meta))
+	if oldRecord == nil {
+		delta.Vectors = 1
+	}
+	if p.named != nil {
+		named, err := c.storeNamed(txn, p.id, p.named, batch)
+		if err != nil {
+			return false, err
+		}
+		delta = addUsage(delta, named)
+	}
+	if err := c.recordWrite(txn, delta); err != nil {
+		return false, err
+	}
+
+	if err := txn.Put(vectorKey, p.record); err != nil {
+		return false, err
+	}
+	if err := c.updateIndex(txn, p.id, oldRecord, p.record, batch); err != nil {
+		return false, err
+	}
+	if err := c.addDimensions(txn, oldRecord, p.record); err != nil {
+		return false, err
+	}
+	if p.code != nil {
+		if err := txn.Put(c.codeKey(p.id), p.code); err != nil {
+			return false, err
+		}
+	} else if oldRecord != nil {
+		// The old vector's code no longer applies
+		if err := txn.Delete(c.codeKey(p.id)); err != nil {
+			return false, err
+		}
+	}
+	if err := c.indexText(txn, p.id, p.metadata); err != nil {
+		return false, err
+	}
+	if err := c.indexFields(txn, p.id, storedMetadata(oldRecord, oldMeta), p.metadata); err != nil {
+		return false, err
+	}
+	if p.meta == nil {
+		if oldMeta != nil {
+			return oldRecord == nil, txn.Delete(metaKey)
+		}
+		return oldRecord == nil, nil
+	}
+	return oldRecord == nil, txn.Put(metaKey, p.meta)
+}
+
+// MigrateStorage rewrites vectors stored in the legacy JSON format using the
+// binary format, in batches of one transaction each. It returns the number
+// of vectors rewritten and may be run again to resume after an error.
+func (c *Collection) MigrateStorage(ctx context.Context) (_ int, err error) {
+	access, err := c.authorize(GrantOperationWrite)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { access.done(err) }()
+
+	var legacy []string
+	err = withTxn(c.db, func(txn *embedded.Transaction) error {
+		legacy = legacy[:0]
+
+		vectors := c.space().Sub("vectors")
+		iter := txn.ScanPrefix(vectors.Bytes())
+		defer iter.Close()
+
+		for {
+			key, value, ok := iter.Next()
+			if !ok {
+				break
+			}
+			if !isLegacyVector(value) {
+				continue
+			}
+			tuple, err := vectors.Unpack(key)
+			if err != nil || len(tuple) != 1 {
+				continue
+			}
+			if id, ok := tuple[0].(string); ok {
+				legacy = append(legacy, id)
+			}
+		}
+
+		return iter.Err()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for len(legacy) > 0 {
+		if err := ctx.Err(); err != nil {
+			return migrated, err
+		}
+
+		n := vectorMigrateBatchSize
+		if n > len(legacy) {
+			n = len(legacy)
+		}
+
+		var rewritten int
+		err := withTxn(c.db, func(txn *embedded.Transaction) error {
+			rewritten = 0
+			for _, id := range legacy[:n] {
+				// Skip vectors deleted or rewritten since the scan
+				value, err := txn.Get(c.vectorKey(id))
+				if err != nil {
+					return err
+				}
+				if !isLegacyVector(value) {
+					continue
+				}
+
+				var data vectorData
+				if err := json.Unmarshal(value, &data); err != nil {
+					return err
+				}
+				if _, err := c.writeVector(txn, id, data); err != nil {
+					return err
+				}
+				rewritten++
+			}
+			return nil
+		})
+		if err != nil {
+			return migrated, err
+		}
+
+		migrated += rewritten
+		legacy = legacy[n:]
+	}
+
+	return migrated, nil
+}
+
+// addUsage sums usage deltas
+func addUsage(a, b NamespaceUsage) NamespaceUsage {
+	return NamespaceUsage{
+		Keys:       a.Keys + b.Keys,
+		Bytes:      a.Bytes + b.Bytes,
+		Vectors:    a.Vectors + b.Vectors,
+		QueueDepth: a.QueueDepth + b.QueueDepth,
+	}
+}
+
+// ============================================================================
+// Half-Precision Conversion
+// ============================================================================
+
+// float32ToFloat16 converts to IEEE 754 half precision, rounding to nearest
+// even and saturating to infinity
+func float32ToFloat16(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int32(bits>>23) & 0xff
+	mant := bits & 0x7fffff
+
+	switch {
+	case exp == 0xff:
+		// Infinity or NaN
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	case exp-127 > 15:
+		return sign | 0x7c00
+	case exp-127 >= -14:
+		// Normal half
+		half := uint32(exp-127+15)<<10 | mant>>13
+		round := mant & 0x1fff
+		if round > 0x1000 || (round == 0x1000 && half&1 == 1) {
+			half++ // may carry into the exponent, which is still correct
+		}
+		return sign | uint16(half)
+	case exp-127 >= -25:
+		// Subnormal half
+		mant |= 0x800000
+		shift := uint32(-(exp - 127) - 14 + 13)
+		half := mant >> shift
+		round := mant & (1<<shift - 1)
+		halfway := uint32(1) << (shift - 1)
+		if round > halfway || (round == halfway && half&1 == 1) {
+			half++
+		}
+		return sign | uint16(half)
+	default:
+		return sign
+	}
+}
+
+// float16ToFloat32 converts from IEEE 754 half precision
+func float16ToFloat32(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+
+	switch {
+	case exp == 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	case exp == 0:
+		if mant == 0 {
+			return math.Float32frombits(sign)
+		}
+		// Subnormal: normalize the mantissa
+		e := uint32(127 - 15 + 1)
+		for mant&0x400 == 0 {
+			mant <<= 1
+			e--
+		}
+		return math.Float32frombits(sign | e<<23 | (mant&0x3ff)<<13)
+	default:
+		return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+	}
+}
+
+// float32ToBFloat16 truncates to bfloat16, rounding to nearest even
+func float32ToBFloat16(f float32) uint16 {
+	bits := math.Float32bits(f)
+	if f != f {
+		return uint16(bits>>16) | 0x40
+	}
+	bits += 0x7fff + (bits>>16)&1
+	return uint16(bits >> 16)
+}
@@ -0,0 +1,134 @@
+package sochdb
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCollectionVectorFormat tests binary vector storage and legacy migration
+func TestCollectionVectorFormat(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+
+	vector := []float32{0.1, -2.5, 1e-3, 65504, 3.14159}
+	for _, encoding := range []VectorEncoding{VectorEncodingFloat32, VectorEncodingFloat16, VectorEncodingBFloat16} {
+		coll, err := ns.CreateCollection(CollectionConfig{Name: string(encoding), VectorEncoding: encoding})
+		require.NoError(t, err)
+
+		_, err = coll.Insert(vector, map[string]interface{}{"source": "web"}, "v")
+		require.NoError(t, err)
+
+		got, err := coll.Get("v")
+		require.NoError(t, err)
+		require.Len(t, got.Vector, len(vector))
+		assert.Equal(t, "web", got.Metadata["source"])
+		for i := range vector {
+			assert.InEpsilon(t, vector[i], got.Vector[i], 0.01, "%s element %d", encoding, i)
+		}
+		if encoding == VectorEncodingFloat32 {
+			assert.Equal(t, vector, got.Vector)
+		}
+
+		raw, err := db.Get(coll.vectorKey("v"))
+		require.NoError(t, err)
+		width := map[VectorEncoding]int{VectorEncodingFloat32: 4, VectorEncodingFloat16: 2, VectorEncodingBFloat16: 2}[encoding]
+		assert.Len(t, raw, vectorHeaderSize+len(vector)*width)
+	}
+
+	// Vectors written by older releases as JSON
+	coll, err := ns.CreateCollection(CollectionConfig{Name: "legacy"})
+	require.NoError(t, err)
+	legacy, err := json.Marshal(vectorData{Vector: []float32{1, 2}, Metadata: map[string]interface{}{"k": "v"}, Timestamp: 42})
+	require.NoError(t, err)
+	require.NoError(t, db.Put(coll.vectorKey("old"), legacy))
+
+	got, err := coll.Get("old")
+	require.NoError(t, err)
+	assert.Equal(t, []float32{1, 2}, got.Vector)
+
+	migrated, err := coll.MigrateStorage(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, migrated)
+
+	raw, err := db.Get(coll.vectorKey("old"))
+	require.NoError(t, err)
+	assert.False(t, isLegacyVector(raw))
+
+	got, err = coll.Get("old")
+	require.NoError(t, err)
+	assert.Equal(t, &vectorData{Vector: []float32{1, 2}, Metadata: map[string]interface{}{"k": "v"}, Timestamp: 42}, got)
+
+	migrated, err = coll.MigrateStorage(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+}
+
+// TestMigrateLegacyCollection tests moving a collection from the key layout
+// of releases before tuple keys
+func TestMigrateLegacyCollection(t *testing.T) {
+	db := openTestDB(t)
+	ns, err := NewNamespaceManager(db).CreateNamespace(NamespaceConfig{Name: "tenant"})
+	require.NoError(t, err)
+
+	require.NoError(t, db.Put([]byte("_collection/tenant/docs/metadata"),
+		[]byte(`{"name":"docs","dimension":2,"metric":"cosine","indexed":true,"createdAt":1}`)))
+	for id, vector := range map[string][]float32{"a": {1, 0}, "b": {0, 1}} {
+		value, err := json.Marshal(vectorData{Vector: vector, Metadata: map[string]interface{}{"id": id}, Timestamp: 42})
+		require.NoError(t, err)
+		require.NoError(t, db.Put([]byte("_collection/tenant/docs/vectors/"+id), value))
+	}
+
+	moved, err := ns.MigrateLegacyCollection(context.Background(), "docs")
+	require.NoError(t, err)
+	assert.Equal(t, 2, moved)
+
+	docs, err := ns.Collection("docs")
+	require.NoError(t, err)
+	assert.Equal(t, 2, docs.config.Dimension)
+	assert.True(t, docs.config.Indexed)
+	count, err := docs.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	got, err := docs.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, &vectorData{Vector: []float32{0, 1}, Metadata: map[string]interface{}{"id": "b"}, Timestamp: 42}, got)
+	results, err := docs.Search(SearchRequest{QueryVector: []float32{1, 0}, K: 1})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "a", results[0].ID)
+	usage, err := ns.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), usage.Vectors)
+
+	for _, key := range []string{"_collection/tenant/docs/metadata", "_collection/tenant/docs/vectors/a"} {
+		value, err := db.Get([]byte(key))
+		require.NoError(t, err)
+		assert.Nil(t, value, key)
+	}
+	_, err = ns.MigrateLegacyCollection(context.Background(), "docs")
+	var notFound *CollectionNotFoundError
+	assert.ErrorAs(t, err, &notFound)
+
+	_, err = ns.MigrateLegacyCollection(context.Background(), "a/b")
+	assert.Error(t, err)
+}
+
+// TestHalfPrecision tests float16 and bfloat16 conversions
+func TestHalfPrecision(t *testing.T) {
+	for _, f := range []float32{0, 1, -1, 0.5, 65504, 6.103515625e-05, 5.960464477539063e-08} {
+		assert.Equal(t, f, float16ToFloat32(float32ToFloat16(f)))
+	}
+	assert.True(t, math.IsInf(float64(float16ToFloat32(float32ToFloat16(1e6))), 1))
+	assert.True(t, math.IsNaN(float64(float16ToFloat32(float32ToFloat16(float32(math.NaN()))))))
+	assert.Equal(t, float32(0), float16ToFloat32(float32ToFloat16(1e-10)))
+
+	for _, f := range []float32{0, 1, -2, 0x1p100, 0.15625} {
+		assert.Equal(t, f, math.Float32frombits(uint32(float32ToBFloat16(f))<<16))
+	}
+}